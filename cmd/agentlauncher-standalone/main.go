@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/runtimes"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+// The standalone binary hosts the launcher API and every runtime in one
+// process. Without NATS_URL, STORE_BACKEND and REDIS_URL it runs entirely in
+// memory.
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	eventBus, err := newEventBus()
+	if err != nil {
+		log.Fatalf("Failed to initialize event bus: %v", err)
+	}
+
+	stores, err := newStores()
+	if err != nil {
+		log.Fatalf("Failed to initialize stores: %v", err)
+	}
+
+	toolRuntime := runtimes.NewToolRuntime(eventBus)
+	if err := toolRuntime.RequireApproval(runtimes.ToolsRequiringApprovalFromEnv()); err != nil {
+		log.Fatalf("Failed to initialize tool runtime: %v", err)
+	}
+	llmRuntime := runtimes.NewLLMRuntime(eventBus, runtimes.StubLLMProcessor)
+	agentRuntime := runtimes.NewAgentRuntime(eventBus, stores.agent, stores.archive)
+	taskTimeout, err := runtimes.TaskTimeoutFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize agent launcher: %v", err)
+	}
+	sessionTTL, err := runtimes.SessionTTLFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize agent launcher: %v", err)
+	}
+	idempotencyKeyTTL, err := runtimes.IdempotencyKeyTTLFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize agent launcher: %v", err)
+	}
+	batchConcurrency, err := runtimes.BatchConcurrencyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize agent launcher: %v", err)
+	}
+
+	launcher := runtimes.NewAgentLauncher(eventBus, stores.task, stores.archive, toolRuntime).
+		SetTaskTimeout(taskTimeout).
+		SetSessionTTL(sessionTTL).
+		SetIdempotencyKeyTTL(idempotencyKeyTTL).
+		SetBatchConcurrency(batchConcurrency).
+		SetAgentStore(stores.agent).
+		SetScheduleStore(stores.schedule).
+		SetProfileStore(stores.profile)
+
+	if err := toolRuntime.Start(); err != nil {
+		log.Fatalf("Failed to start tool runtime: %v", err)
+	}
+	if err := llmRuntime.Start(); err != nil {
+		log.Fatalf("Failed to start LLM runtime: %v", err)
+	}
+	if err := agentRuntime.Start(); err != nil {
+		log.Fatalf("Failed to start agent runtime: %v", err)
+	}
+	if err := launcher.Start(); err != nil {
+		log.Fatalf("Failed to start agent launcher: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	launcher.RegisterRoutes(mux)
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: mux,
+	}
+
+	go func() {
+		log.Printf("Agent Launcher (standalone) starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down Agent Launcher (standalone)...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+	launcher.Stop()
+
+	if err := eventBus.Drain(ctx); err != nil {
+		log.Printf("Event bus drain incomplete: %v", err)
+	}
+	stores.Close()
+	log.Println("Agent Launcher (standalone) stopped")
+}
+
+func newEventBus() (eventbus.EventBus, error) {
+	natsURL := os.Getenv("NATS_URL")
+	if natsURL == "" {
+		log.Println("NATS_URL not set, using in-memory event bus")
+		return eventbus.NewMemoryEventBus(), nil
+	}
+
+	eventBusConfig, err := eventbus.ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	eventBus, err := eventbus.NewDistributedEventBus(natsURL, eventBusConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := eventBus.ProvisionQueues(runtimes.QueueGroups); err != nil {
+		eventBus.Close()
+		return nil, err
+	}
+	return eventBus, nil
+}
+
+type standaloneStores struct {
+	agent    store.AgentStore
+	task     store.TaskStore
+	archive  store.ArchiveStore
+	schedule store.ScheduleStore
+	profile  store.ProfileStore
+}
+
+// Close closes every store that has been opened.
+func (s *standaloneStores) Close() {
+	if s.agent != nil {
+		s.agent.Close()
+	}
+	if s.task != nil {
+		s.task.Close()
+	}
+	if s.archive != nil {
+		s.archive.Close()
+	}
+	if s.schedule != nil {
+		s.schedule.Close()
+	}
+	if s.profile != nil {
+		s.profile.Close()
+	}
+}
+
+func newStores() (*standaloneStores, error) {
+	defaults := store.DefaultConfig()
+	defaults.Archive = store.BackendMemory
+	if os.Getenv("REDIS_URL") == "" {
+		defaults.Backend = store.BackendMemory
+	}
+
+	storeConfig, err := store.ConfigFromEnvWithDefaults(defaults)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using %s stores with %s archive", storeConfig.Backend, storeConfig.Archive)
+
+	stores := &standaloneStores{}
+	if stores.agent, err = store.OpenAgentStore(storeConfig); err != nil {
+		return nil, err
+	}
+	if stores.task, err = store.OpenTaskStore(storeConfig); err != nil {
+		stores.Close()
+		return nil, err
+	}
+	if stores.archive, err = store.OpenArchiveStore(storeConfig); err != nil {
+		stores.Close()
+		return nil, err
+	}
+	if stores.schedule, err = store.OpenScheduleStore(storeConfig); err != nil {
+		stores.Close()
+		return nil, err
+	}
+	if stores.profile, err = store.OpenProfileStore(storeConfig); err != nil {
+		stores.Close()
+		return nil, err
+	}
+	return stores, nil
+}
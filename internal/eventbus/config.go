@@ -0,0 +1,183 @@
+package eventbus
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	DefaultSubjectPrefix = "agentlauncher"
+	DefaultStreamName    = "AGENTLAUNCHER"
+)
+
+type StreamConfig struct {
+	Name       string
+	Retention  nats.RetentionPolicy
+	Storage    nats.StorageType
+	Replicas   int
+	MaxAge     time.Duration
+	Duplicates time.Duration
+}
+
+type ConsumerConfig struct {
+	Workers       int
+	BatchSize     int
+	MaxAckPending int
+	AckWait       time.Duration
+	MaxDeliver    int
+	FetchWait     time.Duration
+}
+
+type Config struct {
+	SubjectPrefix        string
+	Stream               StreamConfig
+	Consumer             ConsumerConfig
+	MigrateLegacyStreams bool
+	// MigrateRetention recreates an existing stream whose retention differs
+	// from Stream.Retention, carrying over its durable consumers and stored
+	// messages. One replica migrates while the others wait for it. Without it
+	// such a stream is an error.
+	MigrateRetention bool
+}
+
+// DefaultConfig uses interest retention: several queue groups consume the same
+// events (tool requests reach both the tool runtime and the launcher), which a
+// work-queue stream does not allow. Streams created with work-queue retention
+// by older releases are recreated on startup when EVENTBUS_MIGRATE_RETENTION
+// is set; see recreateStream.
+func DefaultConfig() Config {
+	return Config{
+		SubjectPrefix: DefaultSubjectPrefix,
+		Stream: StreamConfig{
+			Name:       DefaultStreamName,
+			Retention:  nats.InterestPolicy,
+			Storage:    nats.FileStorage,
+			Replicas:   1,
+			MaxAge:     24 * time.Hour,
+			Duplicates: 2 * time.Minute,
+		},
+		Consumer: ConsumerConfig{
+			Workers:       4,
+			BatchSize:     4,
+			MaxAckPending: 64,
+			AckWait:       30 * time.Second,
+			MaxDeliver:    3,
+			FetchWait:     5 * time.Second,
+		},
+	}
+}
+
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+
+	if v := os.Getenv("EVENTBUS_SUBJECT_PREFIX"); v != "" {
+		cfg.SubjectPrefix = v
+	}
+	if v := os.Getenv("EVENTBUS_STREAM_NAME"); v != "" {
+		cfg.Stream.Name = v
+	}
+
+	if v := os.Getenv("EVENTBUS_RETENTION"); v != "" {
+		retention, err := parseRetention(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Stream.Retention = retention
+	}
+
+	if v := os.Getenv("EVENTBUS_STORAGE"); v != "" {
+		storage, err := parseStorage(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Stream.Storage = storage
+	}
+
+	if v := os.Getenv("EVENTBUS_REPLICAS"); v != "" {
+		replicas, err := strconv.Atoi(v)
+		if err != nil || replicas < 1 {
+			return cfg, fmt.Errorf("invalid EVENTBUS_REPLICAS %q", v)
+		}
+		cfg.Stream.Replicas = replicas
+	}
+
+	if v := os.Getenv("EVENTBUS_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid EVENTBUS_MAX_AGE %q: %w", v, err)
+		}
+		cfg.Stream.MaxAge = maxAge
+	}
+
+	for env, target := range map[string]*int{
+		"EVENTBUS_WORKERS":         &cfg.Consumer.Workers,
+		"EVENTBUS_BATCH_SIZE":      &cfg.Consumer.BatchSize,
+		"EVENTBUS_MAX_ACK_PENDING": &cfg.Consumer.MaxAckPending,
+		"EVENTBUS_MAX_DELIVER":     &cfg.Consumer.MaxDeliver,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("invalid %s %q", env, v)
+			}
+			*target = n
+		}
+	}
+
+	if v := os.Getenv("EVENTBUS_ACK_WAIT"); v != "" {
+		ackWait, err := time.ParseDuration(v)
+		if err != nil || ackWait < time.Second {
+			return cfg, fmt.Errorf("invalid EVENTBUS_ACK_WAIT %q", v)
+		}
+		cfg.Consumer.AckWait = ackWait
+	}
+
+	if v := os.Getenv("EVENTBUS_MIGRATE_LEGACY_STREAMS"); v != "" {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid EVENTBUS_MIGRATE_LEGACY_STREAMS %q", v)
+		}
+		cfg.MigrateLegacyStreams = migrate
+	}
+
+	if v := os.Getenv("EVENTBUS_MIGRATE_RETENTION"); v != "" {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid EVENTBUS_MIGRATE_RETENTION %q", v)
+		}
+		cfg.MigrateRetention = migrate
+	}
+
+	if strings.ContainsAny(cfg.SubjectPrefix, " *>") || strings.HasPrefix(cfg.SubjectPrefix, ".") || strings.HasSuffix(cfg.SubjectPrefix, ".") {
+		return cfg, fmt.Errorf("invalid EVENTBUS_SUBJECT_PREFIX %q", cfg.SubjectPrefix)
+	}
+
+	return cfg, nil
+}
+
+func parseRetention(v string) (nats.RetentionPolicy, error) {
+	switch strings.ToLower(v) {
+	case "workqueue", "work_queue":
+		return nats.WorkQueuePolicy, nil
+	case "interest":
+		return nats.InterestPolicy, nil
+	case "limits":
+		return nats.LimitsPolicy, nil
+	}
+	return 0, fmt.Errorf("invalid EVENTBUS_RETENTION %q (expected workqueue, interest or limits)", v)
+}
+
+func parseStorage(v string) (nats.StorageType, error) {
+	switch strings.ToLower(v) {
+	case "file":
+		return nats.FileStorage, nil
+	case "memory":
+		return nats.MemoryStorage, nil
+	}
+	return 0, fmt.Errorf("invalid EVENTBUS_STORAGE %q (expected file or memory)", v)
+}
@@ -0,0 +1,166 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func (deb *DistributedEventBus) ensureConsumer(consumerName, subject string, deliverPolicy nats.DeliverPolicy) error {
+	cc := deb.config.Consumer
+	consumerConfig := &nats.ConsumerConfig{
+		Durable:       consumerName,
+		FilterSubject: subject,
+		DeliverPolicy: deliverPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       cc.AckWait,
+		MaxDeliver:    cc.MaxDeliver,
+		MaxAckPending: cc.MaxAckPending,
+	}
+
+	_, err := deb.jetStream.ConsumerInfo(deb.config.Stream.Name, consumerName)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		if _, err := deb.jetStream.AddConsumer(deb.config.Stream.Name, consumerConfig); err != nil {
+			return fmt.Errorf("failed to create consumer %s: %w", consumerName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up consumer %s: %w", consumerName, err)
+	}
+
+	if _, err := deb.jetStream.UpdateConsumer(deb.config.Stream.Name, consumerConfig); err != nil {
+		log.Printf("EventBus: failed to update consumer %s to configured settings: %v", consumerName, err)
+	}
+	return nil
+}
+
+func (deb *DistributedEventBus) startConsumer(sub *nats.Subscription, handler MessageHandler) {
+	deb.consumers.Add(1)
+	go func() {
+		defer deb.consumers.Done()
+		deb.consume(sub, handler)
+	}()
+}
+
+// consume fetches without holding a worker, so idle long-polls do not starve
+// the other subscriptions of workers. Each fetched message waits for a worker
+// of its own, and the next fetch waits until all of them have one, so a busy
+// replica never piles up messages it cannot start on.
+func (deb *DistributedEventBus) consume(sub *nats.Subscription, handler MessageHandler) {
+	for deb.ctx.Err() == nil {
+		ctx, cancel := context.WithTimeout(deb.ctx, deb.config.Consumer.FetchWait)
+		batch, err := sub.FetchBatch(deb.fetchSize(), nats.Context(ctx))
+		if err != nil {
+			cancel()
+			if deb.ctx.Err() != nil {
+				return
+			}
+			log.Printf("EventBus: Fetch from %s failed: %v", sub.Subject, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var started sync.WaitGroup
+		for msg := range batch.Messages() {
+			started.Add(1)
+			go deb.dispatch(msg, handler, &started)
+		}
+		cancel()
+
+		if err := batch.Error(); err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) && !errors.Is(err, nats.ErrTimeout) {
+			log.Printf("EventBus: Fetch from %s ended with error: %v", sub.Subject, err)
+		}
+		started.Wait()
+	}
+}
+
+// fetchSize asks for as many messages as there are idle workers, and for one
+// when there are none so that the subscription still waits its turn.
+func (deb *DistributedEventBus) fetchSize() int {
+	idle := cap(deb.workers) - len(deb.workers)
+	return max(1, min(idle, deb.config.Consumer.BatchSize))
+}
+
+// dispatch runs the message once a worker is free. A message still waiting
+// when the bus shuts down is handed back for redelivery.
+func (deb *DistributedEventBus) dispatch(msg *nats.Msg, handler MessageHandler, started *sync.WaitGroup) {
+	if !deb.acquireWorker(msg) {
+		started.Done()
+		if err := msg.Nak(); err != nil {
+			log.Printf("EventBus: Failed to return message on %s: %v", msg.Subject, err)
+		}
+		return
+	}
+	deb.inFlight.Add(1)
+	started.Done()
+	deb.process(msg, handler)
+}
+
+// acquireWorker blocks until a worker is free, extending the message's ack
+// deadline while it waits.
+func (deb *DistributedEventBus) acquireWorker(msg *nats.Msg) bool {
+	ticker := time.NewTicker(deb.config.Consumer.AckWait / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case deb.workers <- struct{}{}:
+			return true
+		case <-deb.ctx.Done():
+			return false
+		case <-ticker.C:
+			if err := msg.InProgress(); err != nil {
+				log.Printf("EventBus: Failed to extend ack deadline on %s: %v", msg.Subject, err)
+			}
+		}
+	}
+}
+
+func (deb *DistributedEventBus) releaseWorker() {
+	<-deb.workers
+}
+
+func (deb *DistributedEventBus) process(msg *nats.Msg, handler MessageHandler) {
+	defer deb.inFlight.Done()
+	defer deb.releaseWorker()
+
+	log.Printf("EventBus: Received message on %s", msg.Subject)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(deb.config.Consumer.AckWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					log.Printf("EventBus: Failed to extend ack deadline on %s: %v", msg.Subject, err)
+				}
+			}
+		}
+	}()
+
+	ctx := deb.handlerCtx
+	if meta, err := msg.Metadata(); err == nil {
+		ctx = withEventSequence(ctx, meta.Sequence.Stream)
+	}
+	handler(ctx, msg.Data)
+	close(done)
+
+	if deb.handlerCtx.Err() != nil {
+		log.Printf("EventBus: Handler for %s cancelled, leaving message for redelivery", msg.Subject)
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		log.Printf("EventBus: Failed to ack message on %s: %v", msg.Subject, err)
+	}
+}
@@ -1,22 +1,34 @@
 package eventbus
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
 type DistributedEventBus struct {
-	nats           *nats.Conn
-	jetStream      nats.JetStreamContext
-	subscriptions  []*nats.Subscription
-	createdStreams map[string]bool
+	nats          *nats.Conn
+	jetStream     nats.JetStreamContext
+	config        Config
+	mu            sync.Mutex
+	subscriptions []*nats.Subscription
+
+	ctx           context.Context
+	cancel        context.CancelFunc
+	handlerCtx    context.Context
+	cancelHandler context.CancelFunc
+	workers       chan struct{}
+	consumers     sync.WaitGroup
+	inFlight      sync.WaitGroup
 }
 
-func NewDistributedEventBus(natsURL string) (*DistributedEventBus, error) {
+func NewDistributedEventBus(natsURL string, config Config) (*DistributedEventBus, error) {
 	nc, err := nats.Connect(natsURL,
 		nats.ReconnectWait(2*time.Second),
 		nats.MaxReconnects(-1),
@@ -37,49 +49,96 @@ func NewDistributedEventBus(natsURL string) (*DistributedEventBus, error) {
 		return nil, fmt.Errorf("failed to initialize JetStream: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	handlerCtx, cancelHandler := context.WithCancel(context.Background())
 	deb := &DistributedEventBus{
-		nats:           nc,
-		jetStream:      js,
-		createdStreams: make(map[string]bool),
+		nats:          nc,
+		jetStream:     js,
+		config:        config,
+		ctx:           ctx,
+		cancel:        cancel,
+		handlerCtx:    handlerCtx,
+		cancelHandler: cancelHandler,
+		workers:       make(chan struct{}, config.Consumer.Workers),
+	}
+
+	if err := deb.ensureStream(); err != nil {
+		cancel()
+		cancelHandler()
+		nc.Close()
+		return nil, err
 	}
 
 	log.Printf("Connected to NATS at %s", natsURL)
 	return deb, nil
 }
 
-func (deb *DistributedEventBus) ensureStreamForSubject(subject string) error {
-	if deb.createdStreams[subject] {
-		return nil
+func (deb *DistributedEventBus) ensureStream() error {
+	sc := deb.config.Stream
+	streamConfig := &nats.StreamConfig{
+		Name:       sc.Name,
+		Subjects:   []string{deb.config.SubjectPrefix + ".>"},
+		Retention:  sc.Retention,
+		Storage:    sc.Storage,
+		Replicas:   sc.Replicas,
+		Duplicates: sc.Duplicates,
+		MaxAge:     sc.MaxAge,
 	}
 
-	_, err := deb.jetStream.StreamInfo(subject)
-	if err != nil {
-		streamConfig := &nats.StreamConfig{
-			Name:       subject,
-			Subjects:   []string{subject},
-			Retention:  nats.WorkQueuePolicy,
-			Storage:    nats.FileStorage,
-			Duplicates: 2 * time.Minute,
-			MaxAge:     24 * time.Hour,
-		}
-
-		_, err = deb.jetStream.AddStream(streamConfig)
+	info, err := deb.jetStream.StreamInfo(sc.Name)
+	if err != nil && !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("failed to look up stream %s: %w", sc.Name, err)
+	}
+	if err == nil && info.Config.Retention == sc.Retention {
+		inProgress, err := deb.migrationInProgress(sc.Name)
 		if err != nil {
-			return fmt.Errorf("failed to create stream %s: %w", subject, err)
+			return err
+		}
+		if !inProgress {
+			deb.updateStream(info, streamConfig)
+			return nil
 		}
-		log.Printf("Created JetStream stream: %s", subject)
 	}
 
-	deb.createdStreams[subject] = true
-	return nil
+	// The stream is created or recreated by one replica at a time; the
+	// others wait for it and then find the stream in place.
+	unlock, err := deb.lockStream(sc.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return deb.reconcileStream(streamConfig)
 }
 
-func (deb *DistributedEventBus) Emit(event Event) error {
-	subject := event.Subject()
+// updateStream applies the configured settings that can change in place.
+func (deb *DistributedEventBus) updateStream(info *nats.StreamInfo, streamConfig *nats.StreamConfig) {
+	if info.Config.Storage == streamConfig.Storage && info.Config.Replicas == streamConfig.Replicas && info.Config.MaxAge == streamConfig.MaxAge {
+		return
+	}
+	if _, err := deb.jetStream.UpdateStream(streamConfig); err != nil {
+		log.Printf("EventBus: failed to update stream %s to configured settings: %v", streamConfig.Name, err)
+	} else {
+		log.Printf("Updated JetStream stream %s", streamConfig.Name)
+	}
+}
 
-	if err := deb.ensureStreamForSubject(subject); err != nil {
-		return fmt.Errorf("failed to ensure stream for %s: %w", subject, err)
+func (deb *DistributedEventBus) subject(eventName, agentID string) string {
+	if agentID == "" {
+		agentID = "_"
 	}
+	return fmt.Sprintf("%s.%s.%s", deb.config.SubjectPrefix, eventName, agentID)
+}
+
+func (deb *DistributedEventBus) EventSubjects(eventName string) string {
+	return fmt.Sprintf("%s.%s.*", deb.config.SubjectPrefix, eventName)
+}
+
+func (deb *DistributedEventBus) AgentSubjects(agentID string) string {
+	return fmt.Sprintf("%s.*.%s", deb.config.SubjectPrefix, agentID)
+}
+
+func (deb *DistributedEventBus) Emit(event Event) error {
+	subject := deb.subject(event.Subject(), event.GetAgentID())
 
 	data, err := json.Marshal(event)
 	if err != nil {
@@ -95,43 +154,142 @@ func (deb *DistributedEventBus) Emit(event Event) error {
 	return nil
 }
 
-func Subscribe[T Event](eventBus *DistributedEventBus, subject, queue string, handler EventHandler[T]) error {
-	if err := eventBus.ensureStreamForSubject(subject); err != nil {
-		return err
-	}
-
-	consumerName := fmt.Sprintf("%s-%s-consumer", queue, subject)
+func (deb *DistributedEventBus) Subscribe(eventName, queue string, handler MessageHandler) error {
+	subject := deb.EventSubjects(eventName)
+	consumerName := queueConsumerName(queue, eventName)
 	log.Printf("EventBus: Creating JetStream consumer '%s' for subject '%s' with queue '%s'", consumerName, subject, queue)
 
-	sub, err := eventBus.jetStream.QueueSubscribe(subject, queue,
-		func(msg *nats.Msg) {
-			log.Printf("EventBus: Received message on %s", subject)
-			if event, ok := UnmarshalEvent[T](msg.Data, subject); ok {
-				handler(nil, event)
-			}
-			msg.Ack()
-		},
-		nats.Durable(consumerName),
-		nats.ManualAck(),
-		nats.AckWait(30*time.Second),
-		nats.MaxDeliver(3),
-	)
+	if err := deb.ensureConsumer(consumerName, subject, nats.DeliverAllPolicy); err != nil {
+		log.Printf("EventBus: ERROR creating consumer for %s: %v", subject, err)
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
 
+	sub, err := deb.jetStream.PullSubscribe(subject, consumerName, nats.Bind(deb.config.Stream.Name, consumerName))
 	if err != nil {
 		log.Printf("EventBus: ERROR creating subscription for %s: %v", subject, err)
 		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
 	}
 
-	eventBus.subscriptions = append(eventBus.subscriptions, sub)
+	deb.addSubscription(sub)
+	deb.startConsumer(sub, handler)
 
 	log.Printf("EventBus: Successfully subscribed to %s with queue %s (consumer: %s)", subject, queue, consumerName)
 	return nil
 }
 
+// ProvisionQueues creates the durable consumers of the given queue groups,
+// keyed by queue name, without subscribing to them. Under interest retention
+// an event is only kept for consumers that exist when it is published, so
+// every process provisions all queue groups instead of waiting for the
+// runtime that owns each one to start.
+func (deb *DistributedEventBus) ProvisionQueues(queues map[string][]string) error {
+	for queue, eventNames := range queues {
+		for _, eventName := range eventNames {
+			consumerName := queueConsumerName(queue, eventName)
+			if err := deb.ensureConsumer(consumerName, deb.EventSubjects(eventName), nats.DeliverAllPolicy); err != nil {
+				return fmt.Errorf("failed to provision %s: %w", consumerName, err)
+			}
+		}
+	}
+	return nil
+}
+
+func queueConsumerName(queue, eventName string) string {
+	return fmt.Sprintf("%s-%s-consumer", queue, eventName)
+}
+
+func (deb *DistributedEventBus) SubscribeBroadcast(eventName, name string, handler MessageHandler) error {
+	if deb.config.Stream.Retention == nats.WorkQueuePolicy {
+		return fmt.Errorf("broadcast subscription to %s requires an interest or limits retention stream, %s uses work-queue retention",
+			eventName, deb.config.Stream.Name)
+	}
+
+	subject := deb.EventSubjects(eventName)
+
+	var sub *nats.Subscription
+	var err error
+	if name == "" {
+		sub, err = deb.jetStream.PullSubscribe(subject, "",
+			nats.BindStream(deb.config.Stream.Name),
+			nats.DeliverNew(),
+			nats.AckWait(deb.config.Consumer.AckWait),
+			nats.MaxAckPending(deb.config.Consumer.MaxAckPending),
+		)
+	} else {
+		consumerName := fmt.Sprintf("%s-%s-observer", name, eventName)
+		if err = deb.ensureConsumer(consumerName, subject, nats.DeliverNewPolicy); err == nil {
+			sub, err = deb.jetStream.PullSubscribe(subject, consumerName, nats.Bind(deb.config.Stream.Name, consumerName))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to broadcast-subscribe to %s: %w", subject, err)
+	}
+
+	deb.addSubscription(sub)
+	deb.startConsumer(sub, handler)
+
+	log.Printf("EventBus: Observing %s as %q", subject, name)
+	return nil
+}
+
+func (deb *DistributedEventBus) addSubscription(sub *nats.Subscription) {
+	deb.mu.Lock()
+	defer deb.mu.Unlock()
+	deb.subscriptions = append(deb.subscriptions, sub)
+}
+
+// Drain stops fetching new messages and gives in-flight handlers until ctx is
+// done to finish and ack. Handlers still running at the deadline see their
+// context cancelled and their messages are redelivered after AckWait.
+func (deb *DistributedEventBus) Drain(ctx context.Context) error {
+	log.Println("Draining EventBus...")
+
+	deb.cancel()
+	deb.consumers.Wait()
+
+	handlersDone := make(chan struct{})
+	go func() {
+		deb.inFlight.Wait()
+		close(handlersDone)
+	}()
+
+	var drainErr error
+	select {
+	case <-handlersDone:
+		log.Println("EventBus: All in-flight handlers finished")
+	case <-ctx.Done():
+		drainErr = fmt.Errorf("drain deadline exceeded with handlers still running: %w", ctx.Err())
+		log.Printf("EventBus: %v", drainErr)
+		deb.cancelHandler()
+	}
+
+	select {
+	case <-deb.jetStream.PublishAsyncComplete():
+	case <-ctx.Done():
+		log.Printf("EventBus: %d async publishes still pending at drain deadline", deb.jetStream.PublishAsyncPending())
+	}
+
+	if err := deb.nats.Flush(); err != nil {
+		log.Printf("EventBus: Failed to flush connection: %v", err)
+	}
+
+	deb.Close()
+	return drainErr
+}
+
 func (deb *DistributedEventBus) Close() error {
 	log.Println("Closing EventBus connections...")
 
-	for _, sub := range deb.subscriptions {
+	deb.cancel()
+	deb.cancelHandler()
+	deb.consumers.Wait()
+
+	deb.mu.Lock()
+	subscriptions := deb.subscriptions
+	deb.subscriptions = nil
+	deb.mu.Unlock()
+
+	for _, sub := range subscriptions {
 		if err := sub.Unsubscribe(); err != nil {
 			log.Printf("Error unsubscribing: %v", err)
 		}
@@ -158,12 +316,3 @@ func (deb *DistributedEventBus) Status() string {
 	}
 	return "Disconnected"
 }
-
-func UnmarshalEvent[T any](data []byte, eventName string) (T, bool) {
-	var event T
-	if err := json.Unmarshal(data, &event); err != nil {
-		log.Printf("Failed to unmarshal %s event: %v", eventName, err)
-		return event, false
-	}
-	return event, true
-}
@@ -0,0 +1,441 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+type pingEvent struct {
+	AgentID string `json:"agent_id"`
+	Seq     int    `json:"seq"`
+}
+
+func (e pingEvent) Subject() string    { return "ping" }
+func (e pingEvent) GetAgentID() string { return e.AgentID }
+
+func runJetStreamServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+
+	srv := natstest.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// TestDistributedEventBusConcurrentEmitSubscribe adds queue groups while
+// events are being emitted. Each group must get every event emitted after its
+// Subscribe returned; what it gets of the earlier ones depends on timing.
+func TestDistributedEventBusConcurrentEmitSubscribe(t *testing.T) {
+	srv := runJetStreamServer(t)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+
+	deb, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus: %v", err)
+	}
+	defer deb.Close()
+
+	const (
+		groups   = 4
+		emitters = 8
+		// tail is how many events each emitter sends once every group
+		// has subscribed.
+		tail = 25
+	)
+
+	// started[i] counts the events emitter i has begun to emit, so an event
+	// with a Seq of at least the count read after a Subscribe returned was
+	// emitted after it.
+	var started [emitters]atomic.Int64
+	var subscribed atomic.Bool
+	var emitted [emitters]int
+
+	var emitting sync.WaitGroup
+	for i := 0; i < emitters; i++ {
+		emitting.Add(1)
+		go func(i int) {
+			defer emitting.Done()
+			remaining := tail
+			for n := 0; remaining > 0; n++ {
+				if subscribed.Load() {
+					remaining--
+				}
+				started[i].Add(1)
+				if err := deb.Emit(pingEvent{AgentID: fmt.Sprintf("agent:%d", i), Seq: n}); err != nil {
+					t.Errorf("Emit: %v", err)
+					return
+				}
+				emitted[i] = n + 1
+			}
+		}(i)
+	}
+
+	type received struct {
+		mu     sync.Mutex
+		events map[[2]int]bool
+		// from[i] is the first Seq of emitter i the group must get.
+		from [emitters]int64
+	}
+	receivers := make([]*received, groups)
+	var subscribers sync.WaitGroup
+	for g := 0; g < groups; g++ {
+		receivers[g] = &received{events: make(map[[2]int]bool)}
+		subscribers.Add(1)
+		go func(g int) {
+			defer subscribers.Done()
+			// Stagger the groups so that they join a stream that is
+			// already busy.
+			time.Sleep(time.Duration(g) * 10 * time.Millisecond)
+			r := receivers[g]
+			err := Subscribe(deb, "ping", fmt.Sprintf("group-%d", g), func(ctx context.Context, e pingEvent) {
+				var i int
+				fmt.Sscanf(e.AgentID, "agent:%d", &i)
+				r.mu.Lock()
+				r.events[[2]int{i, e.Seq}] = true
+				r.mu.Unlock()
+			})
+			if err != nil {
+				t.Errorf("Subscribe group-%d: %v", g, err)
+				return
+			}
+			r.mu.Lock()
+			for i := range started {
+				r.from[i] = started[i].Load()
+			}
+			r.mu.Unlock()
+		}(g)
+	}
+	subscribers.Wait()
+	subscribed.Store(true)
+	emitting.Wait()
+
+	if last := receivers[groups-1]; last.from == [emitters]int64{} {
+		t.Fatal("every group subscribed before the first event was emitted")
+	}
+
+	missing := func() (int, string) {
+		for g, r := range receivers {
+			r.mu.Lock()
+			for i := 0; i < emitters; i++ {
+				for n := int(r.from[i]); n < emitted[i]; n++ {
+					if !r.events[[2]int{i, n}] {
+						r.mu.Unlock()
+						return g, fmt.Sprintf("event %d of agent:%d", n, i)
+					}
+				}
+			}
+			r.mu.Unlock()
+		}
+		return -1, ""
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		g, event := missing()
+		if g < 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("group-%d did not receive %s, emitted after it subscribed", g, event)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDistributedEventBusProvisionsStreamUpFront(t *testing.T) {
+	srv := runJetStreamServer(t)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+
+	deb, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus: %v", err)
+	}
+	defer deb.Close()
+
+	info, err := deb.jetStream.StreamInfo(config.Stream.Name)
+	if err != nil {
+		t.Fatalf("stream %s not provisioned before first emit: %v", config.Stream.Name, err)
+	}
+	if len(info.Config.Subjects) != 1 || info.Config.Subjects[0] != "agentlauncher.>" {
+		t.Fatalf("unexpected stream subjects %v", info.Config.Subjects)
+	}
+
+	second, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("second NewDistributedEventBus against existing stream: %v", err)
+	}
+	second.Close()
+}
+
+type namedEvent struct {
+	Name    string `json:"name"`
+	AgentID string `json:"agent_id"`
+}
+
+func (e namedEvent) Subject() string    { return e.Name }
+func (e namedEvent) GetAgentID() string { return e.AgentID }
+
+func TestDistributedEventBusIdleSubscriptionsDoNotHoldWorkers(t *testing.T) {
+	srv := runJetStreamServer(t)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+
+	deb, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus: %v", err)
+	}
+	defer deb.Close()
+
+	subscriptions := 3 * config.Consumer.Workers
+	delivered := make(chan string, subscriptions)
+	for i := 0; i < subscriptions; i++ {
+		name := fmt.Sprintf("idle-%d", i)
+		err := Subscribe(deb, name, "workers", func(ctx context.Context, e namedEvent) {
+			delivered <- e.Name
+		})
+		if err != nil {
+			t.Fatalf("Subscribe %s: %v", name, err)
+		}
+	}
+	// Let every consumer settle into its long-poll.
+	time.Sleep(200 * time.Millisecond)
+
+	for i := subscriptions - 1; i >= 0; i -= config.Consumer.Workers {
+		name := fmt.Sprintf("idle-%d", i)
+		start := time.Now()
+		if err := deb.Emit(namedEvent{Name: name, AgentID: "agent:1"}); err != nil {
+			t.Fatalf("Emit %s: %v", name, err)
+		}
+		select {
+		case got := <-delivered:
+			if got != name {
+				t.Fatalf("delivered %s, want %s", got, name)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("%s took %s to be delivered", name, elapsed)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s was not delivered while other subscriptions were idle", name)
+		}
+	}
+}
+
+func TestDistributedEventBusProvisionedQueuesKeepEarlyEvents(t *testing.T) {
+	srv := runJetStreamServer(t)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+
+	deb, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus: %v", err)
+	}
+	defer deb.Close()
+
+	if err := deb.ProvisionQueues(map[string][]string{"workers": {"ping"}}); err != nil {
+		t.Fatalf("ProvisionQueues: %v", err)
+	}
+	if err := deb.Emit(pingEvent{AgentID: "agent:1", Seq: 1}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+
+	delivered := make(chan int, 1)
+	err = Subscribe(deb, "ping", "workers", func(ctx context.Context, e pingEvent) {
+		delivered <- e.Seq
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	select {
+	case seq := <-delivered:
+		if seq != 1 {
+			t.Fatalf("delivered seq %d, want 1", seq)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event emitted before the subscription was dropped")
+	}
+}
+
+// emitToWorkQueueStream creates the stream with the work-queue retention of
+// older releases, and leaves pending pings on it for the workers queue.
+func emitToWorkQueueStream(t *testing.T, srv *server.Server, pending int) {
+	t.Helper()
+
+	legacy := DefaultConfig()
+	legacy.Stream.Storage = nats.MemoryStorage
+	legacy.Stream.Retention = nats.WorkQueuePolicy
+
+	old, err := NewDistributedEventBus(srv.ClientURL(), legacy)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus (work queue): %v", err)
+	}
+	defer old.Close()
+	if err := old.ProvisionQueues(map[string][]string{"workers": {"ping"}}); err != nil {
+		t.Fatalf("ProvisionQueues: %v", err)
+	}
+	for n := 0; n < pending; n++ {
+		if err := old.Emit(pingEvent{AgentID: "agent:1", Seq: n}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+}
+
+// expectMigrated checks that the stream was recreated with interest
+// retention and that each pending ping reaches the workers queue once.
+func expectMigrated(t *testing.T, deb *DistributedEventBus, pending int) {
+	t.Helper()
+
+	info, err := deb.jetStream.StreamInfo(deb.config.Stream.Name)
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if info.Config.Retention != nats.InterestPolicy {
+		t.Fatalf("retention is %s after migration, want %s", info.Config.Retention, nats.InterestPolicy)
+	}
+	if info.State.FirstSeq <= uint64(pending) {
+		t.Fatalf("stream sequences restarted at %d after migration", info.State.FirstSeq)
+	}
+	if _, err := deb.jetStream.StreamInfo(backupStreamName(deb.config.Stream.Name)); !errors.Is(err, nats.ErrStreamNotFound) {
+		t.Fatalf("backup stream left after migration: %v", err)
+	}
+
+	delivered := make(chan int, 2*pending)
+	err = Subscribe(deb, "ping", "workers", func(ctx context.Context, e pingEvent) {
+		delivered <- e.Seq
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	seen := make(map[int]bool)
+	for len(seen) < pending {
+		select {
+		case seq := <-delivered:
+			if seen[seq] {
+				t.Fatalf("event %d delivered twice after migration", seq)
+			}
+			seen[seq] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d pending events survived the migration", len(seen), pending)
+		}
+	}
+	select {
+	case seq := <-delivered:
+		t.Fatalf("event %d delivered again after migration", seq)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestDistributedEventBusMigratesRetention(t *testing.T) {
+	srv := runJetStreamServer(t)
+	const pending = 3
+	emitToWorkQueueStream(t, srv, pending)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+	if _, err := NewDistributedEventBus(srv.ClientURL(), config); err == nil {
+		t.Fatal("expected a retention mismatch error without MigrateRetention")
+	}
+
+	config.MigrateRetention = true
+	deb, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus with MigrateRetention: %v", err)
+	}
+	defer deb.Close()
+
+	expectMigrated(t, deb, pending)
+}
+
+func TestDistributedEventBusMigratesRetentionOnce(t *testing.T) {
+	srv := runJetStreamServer(t)
+	const pending = 20
+	emitToWorkQueueStream(t, srv, pending)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+	config.MigrateRetention = true
+
+	const replicas = 4
+	buses := make([]*DistributedEventBus, replicas)
+	errs := make([]error, replicas)
+	var wg sync.WaitGroup
+	for r := 0; r < replicas; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			buses[r], errs[r] = NewDistributedEventBus(srv.ClientURL(), config)
+		}(r)
+	}
+	wg.Wait()
+	for r, err := range errs {
+		if err != nil {
+			t.Fatalf("replica %d: NewDistributedEventBus: %v", r, err)
+		}
+		defer buses[r].Close()
+	}
+
+	expectMigrated(t, buses[0], pending)
+}
+
+func TestDistributedEventBusResumesInterruptedMigration(t *testing.T) {
+	srv := runJetStreamServer(t)
+	const pending = 3
+	emitToWorkQueueStream(t, srv, pending)
+
+	config := DefaultConfig()
+	config.Stream.Storage = nats.MemoryStorage
+	config.MigrateRetention = true
+
+	// Stop a migration the way a process that died after deleting the
+	// stream would leave it.
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream: %v", err)
+	}
+	interrupted := &DistributedEventBus{jetStream: js, config: config}
+	info, err := js.StreamInfo(config.Stream.Name)
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if _, err := interrupted.backupStream(info); err != nil {
+		t.Fatalf("backupStream: %v", err)
+	}
+	if err := js.DeleteStream(config.Stream.Name); err != nil {
+		t.Fatalf("DeleteStream: %v", err)
+	}
+
+	// The migration is finished even without MigrateRetention, as the
+	// events are only left in the backup.
+	config.MigrateRetention = false
+	deb, err := NewDistributedEventBus(srv.ClientURL(), config)
+	if err != nil {
+		t.Fatalf("NewDistributedEventBus: %v", err)
+	}
+	defer deb.Close()
+
+	expectMigrated(t, deb, pending)
+}
@@ -0,0 +1,261 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// MemoryEventBus delivers events inside a single process. Every queue group
+// subscribed to an event receives each message once, and members of the same
+// group take turns; a broadcast subscriber without a name forms its own group.
+type MemoryEventBus struct {
+	mu        sync.RWMutex
+	groups    map[string]map[string]*memoryGroup
+	observers int
+	sequence  uint64
+	closed    bool
+	wg        sync.WaitGroup
+
+	handlerCtx    context.Context
+	cancelHandler context.CancelFunc
+}
+
+type memoryGroup struct {
+	members []*memorySubscriber
+	next    int
+}
+
+type memoryMessage struct {
+	data     []byte
+	sequence uint64
+}
+
+type memorySubscriber struct {
+	ctx       context.Context
+	handler   MessageHandler
+	mu        sync.Mutex
+	queue     []memoryMessage
+	notify    chan struct{}
+	draining  chan struct{}
+	done      chan struct{}
+	drainOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func NewMemoryEventBus() *MemoryEventBus {
+	handlerCtx, cancelHandler := context.WithCancel(context.Background())
+	return &MemoryEventBus{
+		groups:        make(map[string]map[string]*memoryGroup),
+		handlerCtx:    handlerCtx,
+		cancelHandler: cancelHandler,
+	}
+}
+
+func (meb *MemoryEventBus) Emit(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	meb.mu.Lock()
+	defer meb.mu.Unlock()
+
+	if meb.closed {
+		return fmt.Errorf("failed to publish event to %s: event bus closed", event.Subject())
+	}
+
+	meb.sequence++
+	msg := memoryMessage{data: data, sequence: meb.sequence}
+	for _, group := range meb.groups[event.Subject()] {
+		member := group.members[group.next%len(group.members)]
+		group.next++
+		member.enqueue(msg)
+	}
+
+	return nil
+}
+
+func (meb *MemoryEventBus) Subscribe(eventName, queue string, handler MessageHandler) error {
+	return meb.addMember(eventName, queue, handler)
+}
+
+func (meb *MemoryEventBus) SubscribeBroadcast(eventName, name string, handler MessageHandler) error {
+	if name == "" {
+		meb.mu.Lock()
+		meb.observers++
+		name = fmt.Sprintf("observer-%d", meb.observers)
+		meb.mu.Unlock()
+	}
+	return meb.addMember(eventName, "broadcast:"+name, handler)
+}
+
+func (meb *MemoryEventBus) addMember(eventName, groupName string, handler MessageHandler) error {
+	meb.mu.Lock()
+	defer meb.mu.Unlock()
+
+	if meb.closed {
+		return fmt.Errorf("failed to subscribe to %s: event bus closed", eventName)
+	}
+
+	groups, ok := meb.groups[eventName]
+	if !ok {
+		groups = make(map[string]*memoryGroup)
+		meb.groups[eventName] = groups
+	}
+	group, ok := groups[groupName]
+	if !ok {
+		group = &memoryGroup{}
+		groups[groupName] = group
+	}
+
+	member := &memorySubscriber{
+		ctx:      meb.handlerCtx,
+		handler:  handler,
+		notify:   make(chan struct{}, 1),
+		draining: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	group.members = append(group.members, member)
+
+	meb.wg.Add(1)
+	go func() {
+		defer meb.wg.Done()
+		member.run()
+	}()
+
+	log.Printf("EventBus: Subscribed to %s in memory with group %s", eventName, groupName)
+	return nil
+}
+
+func (meb *MemoryEventBus) IsConnected() bool {
+	meb.mu.RLock()
+	defer meb.mu.RUnlock()
+	return !meb.closed
+}
+
+// Drain stops accepting events and lets every subscriber work through the
+// events already queued for it. Subscribers still busy at the deadline see
+// their context cancelled and drop what remains of their queue.
+func (meb *MemoryEventBus) Drain(ctx context.Context) error {
+	members := meb.shutdown()
+	for _, member := range members {
+		member.drain()
+	}
+
+	handlersDone := make(chan struct{})
+	go func() {
+		meb.wg.Wait()
+		close(handlersDone)
+	}()
+
+	select {
+	case <-handlersDone:
+		log.Println("EventBus closed")
+		return nil
+	case <-ctx.Done():
+		for _, member := range members {
+			member.stop()
+		}
+		meb.cancelHandler()
+		return fmt.Errorf("drain deadline exceeded with handlers still running: %w", ctx.Err())
+	}
+}
+
+func (meb *MemoryEventBus) Close() error {
+	for _, member := range meb.shutdown() {
+		member.stop()
+	}
+	meb.cancelHandler()
+	meb.wg.Wait()
+	log.Println("EventBus closed")
+	return nil
+}
+
+// shutdown closes the bus to new events and subscriptions and returns the
+// subscribers, which no longer change once it is closed.
+func (meb *MemoryEventBus) shutdown() []*memorySubscriber {
+	meb.mu.Lock()
+	defer meb.mu.Unlock()
+
+	meb.closed = true
+	var members []*memorySubscriber
+	for _, groups := range meb.groups {
+		for _, group := range groups {
+			members = append(members, group.members...)
+		}
+	}
+	return members
+}
+
+func (ms *memorySubscriber) enqueue(msg memoryMessage) {
+	ms.mu.Lock()
+	ms.queue = append(ms.queue, msg)
+	ms.mu.Unlock()
+
+	select {
+	case ms.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (ms *memorySubscriber) run() {
+	for {
+		select {
+		case <-ms.done:
+			return
+		default:
+		}
+
+		ms.mu.Lock()
+		if len(ms.queue) == 0 {
+			ms.mu.Unlock()
+			select {
+			case <-ms.notify:
+				continue
+			case <-ms.done:
+				return
+			case <-ms.draining:
+				if ms.empty() {
+					return
+				}
+				continue
+			}
+		}
+		msg := ms.queue[0]
+		ms.queue = ms.queue[1:]
+		ms.mu.Unlock()
+
+		ms.handle(msg)
+	}
+}
+
+// handle runs the handler on one event. A panicking handler loses its event
+// but, unlike a crashed runtime in distributed mode, would otherwise take down
+// every runtime of the process with it.
+func (ms *memorySubscriber) handle(msg memoryMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("EventBus: Handler panicked: %v", r)
+		}
+	}()
+	ms.handler(withEventSequence(ms.ctx, msg.sequence), msg.data)
+}
+
+func (ms *memorySubscriber) empty() bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return len(ms.queue) == 0
+}
+
+// drain makes the subscriber return once its queue is empty.
+func (ms *memorySubscriber) drain() {
+	ms.drainOnce.Do(func() { close(ms.draining) })
+}
+
+// stop makes the subscriber return after the event it is handling.
+func (ms *memorySubscriber) stop() {
+	ms.stopOnce.Do(func() { close(ms.done) })
+}
@@ -0,0 +1,288 @@
+package eventbus
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryEventBusDrainDeliversQueuedEvents(t *testing.T) {
+	bus := NewMemoryEventBus()
+
+	release := make(chan struct{})
+	var handled atomic.Int32
+	err := Subscribe(bus, "ping", "workers", func(ctx context.Context, e pingEvent) {
+		<-release
+		handled.Add(1)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	const total = 5
+	for i := range total {
+		if err := bus.Emit(pingEvent{AgentID: "agent", Seq: i}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- bus.Drain(ctx)
+	}()
+
+	// Wait for Drain to close the bus before letting the handler go.
+	for bus.IsConnected() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := bus.Emit(pingEvent{AgentID: "agent"}); err == nil {
+		t.Error("Emit succeeded on a draining bus")
+	}
+	close(release)
+
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if got := handled.Load(); got != total {
+		t.Errorf("handled %d events, want %d", got, total)
+	}
+}
+
+// collect subscribes a handler that reports the sequence numbers of the ping
+// events it receives.
+func collect(t *testing.T, subscribe func(EventHandler[pingEvent]) error) <-chan int {
+	t.Helper()
+
+	received := make(chan int, 100)
+	err := subscribe(func(ctx context.Context, e pingEvent) {
+		received <- e.Seq
+	})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	return received
+}
+
+func emitPings(t *testing.T, bus EventBus, n int) {
+	t.Helper()
+
+	for i := range n {
+		if err := bus.Emit(pingEvent{AgentID: "agent", Seq: i}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+}
+
+func receive(t *testing.T, received <-chan int, n int) []int {
+	t.Helper()
+
+	var seqs []int
+	for range n {
+		select {
+		case seq := <-received:
+			seqs = append(seqs, seq)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d events, want %d", len(seqs), n)
+		}
+	}
+	return seqs
+}
+
+func expectNothing(t *testing.T, received <-chan int) {
+	t.Helper()
+
+	select {
+	case seq := <-received:
+		t.Errorf("received unexpected event %d", seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryEventBusDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		subscribe func(bus EventBus) []func(EventHandler[pingEvent]) error
+		// want holds how many of the six events each subscription gets.
+		want []int
+	}{
+		{
+			name: "queue group members take turns",
+			subscribe: func(bus EventBus) []func(EventHandler[pingEvent]) error {
+				return []func(EventHandler[pingEvent]) error{
+					func(h EventHandler[pingEvent]) error { return Subscribe(bus, "ping", "workers", h) },
+					func(h EventHandler[pingEvent]) error { return Subscribe(bus, "ping", "workers", h) },
+				}
+			},
+			want: []int{3, 3},
+		},
+		{
+			name: "every queue group gets each event",
+			subscribe: func(bus EventBus) []func(EventHandler[pingEvent]) error {
+				return []func(EventHandler[pingEvent]) error{
+					func(h EventHandler[pingEvent]) error { return Subscribe(bus, "ping", "launcher", h) },
+					func(h EventHandler[pingEvent]) error { return Subscribe(bus, "ping", "runtime", h) },
+				}
+			},
+			want: []int{6, 6},
+		},
+		{
+			name: "unnamed broadcast subscribers each get every event",
+			subscribe: func(bus EventBus) []func(EventHandler[pingEvent]) error {
+				return []func(EventHandler[pingEvent]) error{
+					func(h EventHandler[pingEvent]) error { return SubscribeBroadcast(bus, "ping", "", h) },
+					func(h EventHandler[pingEvent]) error { return SubscribeBroadcast(bus, "ping", "", h) },
+					func(h EventHandler[pingEvent]) error { return Subscribe(bus, "ping", "workers", h) },
+				}
+			},
+			want: []int{6, 6, 6},
+		},
+		{
+			name: "broadcast subscribers sharing a name take turns",
+			subscribe: func(bus EventBus) []func(EventHandler[pingEvent]) error {
+				return []func(EventHandler[pingEvent]) error{
+					func(h EventHandler[pingEvent]) error { return SubscribeBroadcast(bus, "ping", "observer", h) },
+					func(h EventHandler[pingEvent]) error { return SubscribeBroadcast(bus, "ping", "observer", h) },
+				}
+			},
+			want: []int{3, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewMemoryEventBus()
+			defer bus.Close()
+
+			var received []<-chan int
+			for _, subscribe := range tt.subscribe(bus) {
+				received = append(received, collect(t, subscribe))
+			}
+			emitPings(t, bus, 6)
+
+			for i, ch := range received {
+				seqs := receive(t, ch, tt.want[i])
+				for j := 1; j < len(seqs); j++ {
+					if seqs[j] <= seqs[j-1] {
+						t.Errorf("subscriber %d received %v out of order", i, seqs)
+					}
+				}
+				expectNothing(t, ch)
+			}
+		})
+	}
+}
+
+func TestMemoryEventBusSequencesEvents(t *testing.T) {
+	bus := NewMemoryEventBus()
+	defer bus.Close()
+
+	sequences := make(chan uint64, 3)
+	err := bus.Subscribe("ping", "workers", func(ctx context.Context, data []byte) {
+		sequences <- EventSequence(ctx)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	emitPings(t, bus, 3)
+
+	var last uint64
+	for range 3 {
+		seq := <-sequences
+		if seq <= last {
+			t.Errorf("event sequence %d after %d", seq, last)
+		}
+		last = seq
+	}
+}
+
+func TestMemoryEventBusSurvivesHandlerPanic(t *testing.T) {
+	bus := NewMemoryEventBus()
+	defer bus.Close()
+
+	received := collect(t, func(h EventHandler[pingEvent]) error {
+		return Subscribe(bus, "ping", "workers", func(ctx context.Context, e pingEvent) {
+			if e.Seq == 0 {
+				panic("handler failed")
+			}
+			h(ctx, e)
+		})
+	})
+	emitPings(t, bus, 3)
+
+	if seqs := receive(t, received, 2); seqs[0] != 1 || seqs[1] != 2 {
+		t.Errorf("received %v after the panic, want [1 2]", seqs)
+	}
+}
+
+func TestMemoryEventBusClose(t *testing.T) {
+	bus := NewMemoryEventBus()
+
+	started := make(chan struct{})
+	var handled atomic.Int32
+	err := Subscribe(bus, "ping", "workers", func(ctx context.Context, e pingEvent) {
+		handled.Add(1)
+		close(started)
+		<-ctx.Done()
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	emitPings(t, bus, 3)
+	<-started
+
+	closed := make(chan error, 1)
+	go func() { closed <- bus.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not cancel the running handler")
+	}
+
+	if got := handled.Load(); got != 1 {
+		t.Errorf("handled %d events, want only the one in flight", got)
+	}
+	if bus.IsConnected() {
+		t.Error("bus still connected after Close")
+	}
+	if err := bus.Emit(pingEvent{AgentID: "agent"}); err == nil {
+		t.Error("Emit succeeded after Close")
+	}
+	if err := bus.Subscribe("ping", "workers", func(context.Context, []byte) {}); err == nil {
+		t.Error("Subscribe succeeded after Close")
+	}
+}
+
+func TestMemoryEventBusDrainDeadline(t *testing.T) {
+	bus := NewMemoryEventBus()
+	defer bus.Close()
+
+	started := make(chan struct{}, 1)
+	cancelled := make(chan struct{})
+	err := Subscribe(bus, "ping", "workers", func(ctx context.Context, e pingEvent) {
+		started <- struct{}{}
+		<-ctx.Done()
+		close(cancelled)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	emitPings(t, bus, 1)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bus.Drain(ctx); err == nil {
+		t.Error("Drain returned no error with a handler still running")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context was not cancelled at the drain deadline")
+	}
+}
@@ -0,0 +1,358 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	legacyMigrationConsumer = "agentlauncher-stream-migration"
+
+	streamLockBucket        = "agentlauncher-stream-locks"
+	streamLockTTL           = 10 * time.Minute
+	streamLockRetryInterval = 500 * time.Millisecond
+
+	backupConsumersKey = "agentlauncher.consumers"
+	backupLastSeqKey   = "agentlauncher.last_seq"
+	backupCompleteKey  = "agentlauncher.complete"
+)
+
+// MigrateLegacyStreams moves messages still pending in the old one-stream-per-event
+// layout into the consolidated stream and deletes the old streams afterwards.
+func (deb *DistributedEventBus) MigrateLegacyStreams(eventNames []string) error {
+	for _, eventName := range eventNames {
+		if eventName == deb.config.Stream.Name {
+			continue
+		}
+
+		_, err := deb.jetStream.StreamInfo(eventName)
+		if errors.Is(err, nats.ErrStreamNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up legacy stream %s: %w", eventName, err)
+		}
+
+		moved, err := deb.migrateLegacyStream(eventName)
+		if err != nil {
+			return fmt.Errorf("failed to migrate legacy stream %s: %w", eventName, err)
+		}
+
+		if err := deb.jetStream.DeleteStream(eventName); err != nil {
+			return fmt.Errorf("failed to delete legacy stream %s: %w", eventName, err)
+		}
+		log.Printf("EventBus: Migrated %d pending messages from legacy stream %s", moved, eventName)
+	}
+	return nil
+}
+
+func (deb *DistributedEventBus) migrateLegacyStream(eventName string) (int, error) {
+	// Work-queue streams only allow one consumer per subject, so the old
+	// queue-group consumers have to go before the stream can be drained.
+	for consumer := range deb.jetStream.ConsumerNames(eventName) {
+		if err := deb.jetStream.DeleteConsumer(eventName, consumer); err != nil {
+			return 0, fmt.Errorf("failed to delete consumer %s: %w", consumer, err)
+		}
+	}
+
+	sub, err := deb.jetStream.PullSubscribe(eventName, legacyMigrationConsumer, nats.BindStream(eventName))
+	if err != nil {
+		return 0, err
+	}
+	defer sub.Unsubscribe()
+
+	moved := 0
+	for {
+		msgs, err := sub.Fetch(100, nats.MaxWait(2*time.Second))
+		if errors.Is(err, nats.ErrTimeout) {
+			return moved, nil
+		}
+		if err != nil {
+			return moved, err
+		}
+
+		for _, msg := range msgs {
+			var envelope struct {
+				AgentID string `json:"agent_id"`
+			}
+			if err := json.Unmarshal(msg.Data, &envelope); err != nil {
+				log.Printf("EventBus: Dropping unreadable message from legacy stream %s: %v", eventName, err)
+				msg.Ack()
+				continue
+			}
+
+			if _, err := deb.jetStream.Publish(deb.subject(eventName, envelope.AgentID), msg.Data); err != nil {
+				return moved, err
+			}
+			msg.Ack()
+			moved++
+		}
+	}
+}
+
+// reconcileStream creates the stream, or recreates it when its retention
+// differs, finishing first any migration an earlier process left behind. The
+// caller holds the stream lock.
+func (deb *DistributedEventBus) reconcileStream(streamConfig *nats.StreamConfig) error {
+	name := streamConfig.Name
+
+	backup, err := deb.jetStream.StreamInfo(backupStreamName(name))
+	switch {
+	case err == nil && backup.Config.Metadata[backupCompleteKey] == "true":
+		log.Printf("EventBus: Resuming the interrupted migration of stream %s", name)
+		return deb.restoreStream(streamConfig, backup)
+	case err == nil:
+		// The copy did not finish, so the stream itself was never touched.
+		if err := deb.jetStream.DeleteStream(backup.Config.Name); err != nil {
+			return fmt.Errorf("failed to delete incomplete backup of stream %s: %w", name, err)
+		}
+	case !errors.Is(err, nats.ErrStreamNotFound):
+		return fmt.Errorf("failed to look up backup of stream %s: %w", name, err)
+	}
+
+	info, err := deb.jetStream.StreamInfo(name)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		if _, err := deb.jetStream.AddStream(streamConfig); err != nil {
+			return fmt.Errorf("failed to create stream %s: %w", name, err)
+		}
+		log.Printf("Created JetStream stream %s for %s (retention=%s, storage=%s, replicas=%d)",
+			name, streamConfig.Subjects[0], streamConfig.Retention, streamConfig.Storage, streamConfig.Replicas)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up stream %s: %w", name, err)
+	}
+
+	if info.Config.Retention == streamConfig.Retention {
+		deb.updateStream(info, streamConfig)
+		return nil
+	}
+	if !deb.config.MigrateRetention {
+		return fmt.Errorf("stream %s has retention %s but %s is configured; retention cannot be changed in place, set EVENTBUS_MIGRATE_RETENTION=true to recreate the stream",
+			name, info.Config.Retention, streamConfig.Retention)
+	}
+	return deb.recreateStream(streamConfig, info)
+}
+
+// recreateStream replaces the existing stream with one using the configured
+// settings, which is the only way to change its retention. The stored
+// messages and durable consumers are first copied to a backup stream, so a
+// process that stops part way leaves the migration for the next one to
+// finish. Acknowledgement state is not carried over: messages a work-queue
+// stream still holds have not been processed, but those an interest or limits
+// stream holds may be redelivered.
+func (deb *DistributedEventBus) recreateStream(streamConfig *nats.StreamConfig, info *nats.StreamInfo) error {
+	backup, err := deb.backupStream(info)
+	if err != nil {
+		return err
+	}
+	return deb.restoreStream(streamConfig, backup)
+}
+
+// backupStream copies the messages of a stream to its backup stream and
+// records its durable consumers and last sequence in the backup's metadata.
+// The backup is marked complete once every message is in it.
+func (deb *DistributedEventBus) backupStream(info *nats.StreamInfo) (*nats.StreamInfo, error) {
+	name := info.Config.Name
+
+	var consumers []*nats.ConsumerConfig
+	for consumer := range deb.jetStream.ConsumersInfo(name) {
+		if consumer.Config.Durable != "" {
+			config := consumer.Config
+			consumers = append(consumers, &config)
+		}
+	}
+	consumersJSON, err := json.Marshal(consumers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal consumers of stream %s: %w", name, err)
+	}
+
+	backupConfig := &nats.StreamConfig{
+		Name:      backupStreamName(name),
+		Subjects:  []string{backupSubject(name, ">")},
+		Retention: nats.LimitsPolicy,
+		Storage:   info.Config.Storage,
+		Replicas:  info.Config.Replicas,
+		Metadata: map[string]string{
+			backupConsumersKey: string(consumersJSON),
+			backupLastSeqKey:   strconv.FormatUint(info.State.LastSeq, 10),
+		},
+	}
+	if _, err := deb.jetStream.AddStream(backupConfig); err != nil {
+		return nil, fmt.Errorf("failed to create backup of stream %s: %w", name, err)
+	}
+
+	copied := 0
+	for seq := info.State.FirstSeq; info.State.Msgs > 0 && seq <= info.State.LastSeq; seq++ {
+		msg, err := deb.jetStream.GetMsg(name, seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message %d of stream %s: %w", seq, name, err)
+		}
+		backupMsg := &nats.Msg{Subject: backupSubject(name, msg.Subject), Header: msg.Header, Data: msg.Data}
+		if _, err := deb.jetStream.PublishMsg(backupMsg); err != nil {
+			return nil, fmt.Errorf("failed to back up message %d of stream %s: %w", seq, name, err)
+		}
+		copied++
+	}
+
+	backupConfig.Metadata[backupCompleteKey] = "true"
+	backup, err := deb.jetStream.UpdateStream(backupConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete backup of stream %s: %w", name, err)
+	}
+	log.Printf("EventBus: Backed up %d messages and %d consumers of stream %s", copied, len(consumers), name)
+	return backup, nil
+}
+
+// restoreStream recreates the stream from a complete backup and deletes the
+// backup. It can run again after being interrupted: a stream that still has
+// the old retention is replaced, consumers that exist are kept, and messages
+// are republished under a message ID so that the stream drops those already
+// restored within its duplicates window.
+func (deb *DistributedEventBus) restoreStream(streamConfig *nats.StreamConfig, backup *nats.StreamInfo) error {
+	name := streamConfig.Name
+
+	var consumers []*nats.ConsumerConfig
+	if err := json.Unmarshal([]byte(backup.Config.Metadata[backupConsumersKey]), &consumers); err != nil {
+		return fmt.Errorf("failed to read consumers from backup of stream %s: %w", name, err)
+	}
+	lastSeq, err := strconv.ParseUint(backup.Config.Metadata[backupLastSeqKey], 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to read last sequence from backup of stream %s: %w", name, err)
+	}
+
+	info, err := deb.jetStream.StreamInfo(name)
+	if err != nil && !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("failed to look up stream %s: %w", name, err)
+	}
+	if err == nil && info.Config.Retention != streamConfig.Retention {
+		if err := deb.jetStream.DeleteStream(name); err != nil {
+			return fmt.Errorf("failed to delete stream %s: %w", name, err)
+		}
+	}
+	if err != nil || info.Config.Retention != streamConfig.Retention {
+		// Sequences carry on from the old stream so that EventSequence
+		// keeps growing across the migration.
+		recreated := *streamConfig
+		recreated.FirstSeq = lastSeq + 1
+		if _, err := deb.jetStream.AddStream(&recreated); err != nil {
+			return fmt.Errorf("failed to recreate stream %s: %w", name, err)
+		}
+	}
+
+	for _, consumer := range consumers {
+		_, err := deb.jetStream.ConsumerInfo(name, consumer.Durable)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
+			return fmt.Errorf("failed to look up consumer %s: %w", consumer.Durable, err)
+		}
+		if _, err := deb.jetStream.AddConsumer(name, consumer); err != nil {
+			return fmt.Errorf("failed to recreate consumer %s: %w", consumer.Durable, err)
+		}
+	}
+
+	prefix := backupSubject(name, "")
+	restored := 0
+	for seq := backup.State.FirstSeq; backup.State.Msgs > 0 && seq <= backup.State.LastSeq; seq++ {
+		msg, err := deb.jetStream.GetMsg(backup.Config.Name, seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read message %d of backup of stream %s: %w", seq, name, err)
+		}
+		header := msg.Header
+		if header == nil {
+			header = nats.Header{}
+		}
+		if header.Get(nats.MsgIdHdr) == "" {
+			header.Set(nats.MsgIdHdr, fmt.Sprintf("%s-migration-%d-%d", name, lastSeq, seq))
+		}
+		restoredMsg := &nats.Msg{Subject: strings.TrimPrefix(msg.Subject, prefix), Header: header, Data: msg.Data}
+		if _, err := deb.jetStream.PublishMsg(restoredMsg); err != nil {
+			return fmt.Errorf("failed to republish message %d to stream %s: %w", seq, name, err)
+		}
+		restored++
+	}
+
+	if err := deb.jetStream.DeleteStream(backup.Config.Name); err != nil {
+		return fmt.Errorf("failed to delete backup of stream %s: %w", name, err)
+	}
+	log.Printf("EventBus: Recreated stream %s with retention %s, carrying over %d consumers and %d messages",
+		name, streamConfig.Retention, len(consumers), restored)
+	return nil
+}
+
+// migrationInProgress reports whether a migration of the stream was
+// interrupted and has to be finished.
+func (deb *DistributedEventBus) migrationInProgress(name string) (bool, error) {
+	_, err := deb.jetStream.StreamInfo(backupStreamName(name))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up backup of stream %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// lockStream keeps other replicas from creating or recreating the stream at
+// the same time, waiting while another replica holds the lock. The lock
+// expires after streamLockTTL in case its holder stops without releasing it.
+func (deb *DistributedEventBus) lockStream(name string) (func(), error) {
+	kv, err := deb.jetStream.KeyValue(streamLockBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = deb.jetStream.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:   streamLockBucket,
+			TTL:      streamLockTTL,
+			Storage:  deb.config.Stream.Storage,
+			Replicas: deb.config.Stream.Replicas,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream lock bucket: %w", err)
+	}
+
+	owner, _ := os.Hostname()
+	deadline := time.Now().Add(streamLockTTL)
+	for {
+		revision, err := kv.Create(name, []byte(owner))
+		if err == nil {
+			return func() {
+				if err := kv.Delete(name, nats.LastRevision(revision)); err != nil {
+					log.Printf("EventBus: Failed to release the lock on stream %s: %v", name, err)
+				}
+			}, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return nil, fmt.Errorf("failed to lock stream %s: %w", name, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the lock on stream %s", name)
+		}
+		time.Sleep(streamLockRetryInterval)
+	}
+}
+
+func backupStreamName(name string) string {
+	return name + "_MIGRATION"
+}
+
+// backupSubject is the subject a message of the stream is kept under in its
+// backup, so that the backup does not capture the stream's own subjects.
+func backupSubject(name, subject string) string {
+	return "_migration." + name + "." + subject
+}
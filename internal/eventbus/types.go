@@ -1,14 +1,65 @@
 package eventbus
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
 
 type Event interface {
 	Subject() string
+	GetAgentID() string
 }
 
 type EventHandler[T Event] func(context.Context, T)
 
+type MessageHandler func(ctx context.Context, data []byte)
+
+type eventSequenceKey struct{}
+
+// EventSequence returns the position of the event being handled in the order
+// events were published, or 0 when ctx does not come from a handler. Later
+// events have higher sequences, so handlers receiving related events out of
+// order can tell which is newer.
+func EventSequence(ctx context.Context) uint64 {
+	seq, _ := ctx.Value(eventSequenceKey{}).(uint64)
+	return seq
+}
+
+func withEventSequence(ctx context.Context, seq uint64) context.Context {
+	return context.WithValue(ctx, eventSequenceKey{}, seq)
+}
+
 type EventBus interface {
 	Emit(event Event) error
+	Subscribe(eventName, queue string, handler MessageHandler) error
+	SubscribeBroadcast(eventName, name string, handler MessageHandler) error
+	IsConnected() bool
+	Drain(ctx context.Context) error
 	Close() error
 }
+
+func Subscribe[T Event](eventBus EventBus, eventName, queue string, handler EventHandler[T]) error {
+	return eventBus.Subscribe(eventName, queue, typedHandler(eventName, handler))
+}
+
+func SubscribeBroadcast[T Event](eventBus EventBus, eventName, name string, handler EventHandler[T]) error {
+	return eventBus.SubscribeBroadcast(eventName, name, typedHandler(eventName, handler))
+}
+
+func typedHandler[T Event](eventName string, handler EventHandler[T]) MessageHandler {
+	return func(ctx context.Context, data []byte) {
+		if event, ok := UnmarshalEvent[T](data, eventName); ok {
+			handler(ctx, event)
+		}
+	}
+}
+
+func UnmarshalEvent[T any](data []byte, eventName string) (T, bool) {
+	var event T
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("Failed to unmarshal %s event: %v", eventName, err)
+		return event, false
+	}
+	return event, true
+}
@@ -1,48 +1,59 @@
 package events
 
 import (
+	"time"
+
 	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
 )
 
 type AgentCreateEvent struct {
-	AgentID      string                    `json:"agent_id"`
-	Task         string                    `json:"task"`
-	ToolSchemas  []llminterface.ToolSchema `json:"tool_schemas"`
-	Conversation []llminterface.Message    `json:"conversation"`
-	SystemPrompt string                    `json:"system_prompt"`
+	AgentID          string                    `json:"agent_id"`
+	Task             string                    `json:"task"`
+	ToolSchemas      []llminterface.ToolSchema `json:"tool_schemas"`
+	Conversation     []llminterface.Message    `json:"conversation"`
+	SystemPrompt     string                    `json:"system_prompt"`
+	SessionExpiresAt *time.Time                `json:"session_expires_at,omitempty"`
+	Limits           llminterface.AgentLimits  `json:"limits"`
+	ModelParams      llminterface.ModelParams  `json:"model_params"`
 }
 
-func (e AgentCreateEvent) Subject() string { return AgentCreateEventName }
+func (e AgentCreateEvent) Subject() string    { return AgentCreateEventName }
+func (e AgentCreateEvent) GetAgentID() string { return e.AgentID }
 
 type AgentStartEvent struct {
 	AgentID string `json:"agent_id"`
 }
 
-func (e AgentStartEvent) Subject() string { return AgentStartEventName }
+func (e AgentStartEvent) Subject() string    { return AgentStartEventName }
+func (e AgentStartEvent) GetAgentID() string { return e.AgentID }
 
 type AgentFinishEvent struct {
 	AgentID string `json:"agent_id"`
 	Result  string `json:"result"`
 }
 
-func (e AgentFinishEvent) Subject() string { return AgentFinishEventName }
+func (e AgentFinishEvent) Subject() string    { return AgentFinishEventName }
+func (e AgentFinishEvent) GetAgentID() string { return e.AgentID }
 
 type AgentErrorEvent struct {
 	AgentID string `json:"agent_id"`
 	Error   string `json:"error"`
 }
 
-func (e AgentErrorEvent) Subject() string { return AgentErrorEventName }
+func (e AgentErrorEvent) Subject() string    { return AgentErrorEventName }
+func (e AgentErrorEvent) GetAgentID() string { return e.AgentID }
 
 type AgentRuntimeErrorEvent struct {
 	AgentID string `json:"agent_id"`
 	Error   string `json:"error"`
 }
 
-func (e AgentRuntimeErrorEvent) Subject() string { return AgentRuntimeErrorEventName }
+func (e AgentRuntimeErrorEvent) Subject() string    { return AgentRuntimeErrorEventName }
+func (e AgentRuntimeErrorEvent) GetAgentID() string { return e.AgentID }
 
 type AgentDeletedEvent struct {
 	AgentID string `json:"agent_id"`
 }
 
-func (e AgentDeletedEvent) Subject() string { return AgentDeletedEventName }
+func (e AgentDeletedEvent) Subject() string    { return AgentDeletedEventName }
+func (e AgentDeletedEvent) GetAgentID() string { return e.AgentID }
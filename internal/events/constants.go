@@ -11,6 +11,10 @@ const (
 	TaskCreateEventName = "task-create"
 	TaskFinishEventName = "task-finish"
 	TaskErrorEventName  = "task-error"
+	TaskCancelEventName = "task-cancel"
+
+	SessionMessageEventName = "session-message"
+	SessionCloseEventName   = "session-close"
 
 	LLMRequestEventName  = "llm-request"
 	LLMResponseEventName = "llm-response"
@@ -34,4 +38,52 @@ const (
 	ToolExecFinishEventName   = "tool-exec-finish"
 	ToolExecErrorEventName    = "tool-exec-error"
 	ToolRuntimeErrorEventName = "tool-runtime-error"
+
+	ToolApprovalRequestEventName = "tool-approval-request"
+	ToolCallsResolvedEventName   = "tool-calls-resolved"
+	UserInputRequestEventName    = "user-input-request"
 )
+
+var AllEventNames = []string{
+	AgentCreateEventName,
+	AgentStartEventName,
+	AgentFinishEventName,
+	AgentErrorEventName,
+	AgentRuntimeErrorEventName,
+	AgentDeletedEventName,
+
+	TaskCreateEventName,
+	TaskFinishEventName,
+	TaskErrorEventName,
+	TaskCancelEventName,
+
+	SessionMessageEventName,
+	SessionCloseEventName,
+
+	LLMRequestEventName,
+	LLMResponseEventName,
+	LLMErrorEventName,
+
+	MessageAddEventName,
+	MessageStreamStartEventName,
+	MessageStreamDeltaEventName,
+	MessageStreamDoneEventName,
+	MessageStreamErrorEventName,
+
+	ToolCallStreamNameEventName,
+	ToolCallStreamArgsStartEventName,
+	ToolCallStreamArgsDeltaEventName,
+	ToolCallStreamArgsDoneEventName,
+	ToolCallStreamArgsErrorEventName,
+
+	ToolExecRequestEventName,
+	ToolExecResultsEventName,
+	ToolExecStartEventName,
+	ToolExecFinishEventName,
+	ToolExecErrorEventName,
+	ToolRuntimeErrorEventName,
+
+	ToolApprovalRequestEventName,
+	ToolCallsResolvedEventName,
+	UserInputRequestEventName,
+}
@@ -8,10 +8,12 @@ type LLMRequestEvent struct {
 	AgentID     string                    `json:"agent_id"`
 	Messages    []llminterface.Message    `json:"messages"`
 	ToolSchemas []llminterface.ToolSchema `json:"tool_schemas"`
+	ModelParams llminterface.ModelParams  `json:"model_params"`
 	RetryCount  int                       `json:"retry_count"`
 }
 
-func (e LLMRequestEvent) Subject() string { return LLMRequestEventName }
+func (e LLMRequestEvent) Subject() string    { return LLMRequestEventName }
+func (e LLMRequestEvent) GetAgentID() string { return e.AgentID }
 
 type LLMResponseEvent struct {
 	AgentID      string                 `json:"agent_id"`
@@ -19,7 +21,8 @@ type LLMResponseEvent struct {
 	Response     []llminterface.Message `json:"response"`
 }
 
-func (e LLMResponseEvent) Subject() string { return LLMResponseEventName }
+func (e LLMResponseEvent) Subject() string    { return LLMResponseEventName }
+func (e LLMResponseEvent) GetAgentID() string { return e.AgentID }
 
 type LLMRuntimeErrorEvent struct {
 	AgentID      string          `json:"agent_id"`
@@ -27,4 +30,5 @@ type LLMRuntimeErrorEvent struct {
 	RequestEvent LLMRequestEvent `json:"request_event"`
 }
 
-func (e LLMRuntimeErrorEvent) Subject() string { return LLMErrorEventName }
+func (e LLMRuntimeErrorEvent) Subject() string    { return LLMErrorEventName }
+func (e LLMRuntimeErrorEvent) GetAgentID() string { return e.AgentID }
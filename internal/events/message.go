@@ -9,34 +9,39 @@ type MessagesAddEvent struct {
 	Messages []llminterface.Message `json:"messages"`
 }
 
-func (e MessagesAddEvent) Subject() string { return MessageAddEventName }
+func (e MessagesAddEvent) Subject() string    { return MessageAddEventName }
+func (e MessagesAddEvent) GetAgentID() string { return e.AgentID }
 
 type MessageStartStreamingEvent struct {
 	AgentID string `json:"agent_id"`
 }
 
-func (e MessageStartStreamingEvent) Subject() string { return MessageStreamStartEventName }
+func (e MessageStartStreamingEvent) Subject() string    { return MessageStreamStartEventName }
+func (e MessageStartStreamingEvent) GetAgentID() string { return e.AgentID }
 
 type MessageDeltaStreamingEvent struct {
 	AgentID string `json:"agent_id"`
 	Delta   string `json:"delta"`
 }
 
-func (e MessageDeltaStreamingEvent) Subject() string { return MessageStreamDeltaEventName }
+func (e MessageDeltaStreamingEvent) Subject() string    { return MessageStreamDeltaEventName }
+func (e MessageDeltaStreamingEvent) GetAgentID() string { return e.AgentID }
 
 type MessageDoneStreamingEvent struct {
 	AgentID string `json:"agent_id"`
 	Message string `json:"message"`
 }
 
-func (e MessageDoneStreamingEvent) Subject() string { return MessageStreamDoneEventName }
+func (e MessageDoneStreamingEvent) Subject() string    { return MessageStreamDoneEventName }
+func (e MessageDoneStreamingEvent) GetAgentID() string { return e.AgentID }
 
 type MessageErrorStreamingEvent struct {
 	AgentID string `json:"agent_id"`
 	Error   string `json:"error"`
 }
 
-func (e MessageErrorStreamingEvent) Subject() string { return MessageStreamErrorEventName }
+func (e MessageErrorStreamingEvent) Subject() string    { return MessageStreamErrorEventName }
+func (e MessageErrorStreamingEvent) GetAgentID() string { return e.AgentID }
 
 type ToolCallNameStreamingEvent struct {
 	AgentID    string `json:"agent_id"`
@@ -44,7 +49,8 @@ type ToolCallNameStreamingEvent struct {
 	ToolName   string `json:"tool_name"`
 }
 
-func (e ToolCallNameStreamingEvent) Subject() string { return ToolCallStreamNameEventName }
+func (e ToolCallNameStreamingEvent) Subject() string    { return ToolCallStreamNameEventName }
+func (e ToolCallNameStreamingEvent) GetAgentID() string { return e.AgentID }
 
 type ToolCallArgumentsStartStreamingEvent struct {
 	AgentID    string `json:"agent_id"`
@@ -55,6 +61,8 @@ func (e ToolCallArgumentsStartStreamingEvent) Subject() string {
 	return ToolCallStreamArgsStartEventName
 }
 
+func (e ToolCallArgumentsStartStreamingEvent) GetAgentID() string { return e.AgentID }
+
 type ToolCallArgumentsDeltaStreamingEvent struct {
 	AgentID        string `json:"agent_id"`
 	ToolCallID     string `json:"tool_call_id"`
@@ -65,6 +73,8 @@ func (e ToolCallArgumentsDeltaStreamingEvent) Subject() string {
 	return ToolCallStreamArgsDeltaEventName
 }
 
+func (e ToolCallArgumentsDeltaStreamingEvent) GetAgentID() string { return e.AgentID }
+
 type ToolCallArgumentsDoneStreamingEvent struct {
 	AgentID    string `json:"agent_id"`
 	ToolCallID string `json:"tool_call_id"`
@@ -75,6 +85,8 @@ func (e ToolCallArgumentsDoneStreamingEvent) Subject() string {
 	return ToolCallStreamArgsDoneEventName
 }
 
+func (e ToolCallArgumentsDoneStreamingEvent) GetAgentID() string { return e.AgentID }
+
 type ToolCallArgumentsErrorStreamingEvent struct {
 	AgentID    string `json:"agent_id"`
 	ToolCallID string `json:"tool_call_id"`
@@ -84,3 +96,5 @@ type ToolCallArgumentsErrorStreamingEvent struct {
 func (e ToolCallArgumentsErrorStreamingEvent) Subject() string {
 	return ToolCallStreamArgsErrorEventName
 }
+
+func (e ToolCallArgumentsErrorStreamingEvent) GetAgentID() string { return e.AgentID }
@@ -0,0 +1,21 @@
+package events
+
+// SessionMessageEvent continues a session's conversation with another user
+// message and runs the agent loop again.
+type SessionMessageEvent struct {
+	AgentID string `json:"agent_id"`
+	Content string `json:"content"`
+}
+
+func (e SessionMessageEvent) Subject() string    { return SessionMessageEventName }
+func (e SessionMessageEvent) GetAgentID() string { return e.AgentID }
+
+// SessionCloseEvent releases an idle session's agent. Its last turn has
+// already been archived.
+type SessionCloseEvent struct {
+	AgentID string `json:"agent_id"`
+	Reason  string `json:"reason"`
+}
+
+func (e SessionCloseEvent) Subject() string    { return SessionCloseEventName }
+func (e SessionCloseEvent) GetAgentID() string { return e.AgentID }
@@ -1,29 +1,48 @@
 package events
 
 import (
+	"time"
+
 	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
 )
 
 type TaskCreateEvent struct {
-	AgentID      string                    `json:"agent_id"`
-	Task         string                    `json:"task"`
-	ToolSchemas  []llminterface.ToolSchema `json:"tool_schemas"`
-	SystemPrompt string                    `json:"system_prompt"`
-	Conversation []llminterface.Message    `json:"conversation"`
+	AgentID          string                    `json:"agent_id"`
+	Task             string                    `json:"task"`
+	ToolSchemas      []llminterface.ToolSchema `json:"tool_schemas"`
+	SystemPrompt     string                    `json:"system_prompt"`
+	Conversation     []llminterface.Message    `json:"conversation"`
+	SessionExpiresAt *time.Time                `json:"session_expires_at,omitempty"`
+	Limits           llminterface.AgentLimits  `json:"limits"`
+	ModelParams      llminterface.ModelParams  `json:"model_params"`
 }
 
-func (e TaskCreateEvent) Subject() string { return TaskCreateEventName }
+func (e TaskCreateEvent) Subject() string    { return TaskCreateEventName }
+func (e TaskCreateEvent) GetAgentID() string { return e.AgentID }
 
 type TaskFinishEvent struct {
 	AgentID string `json:"agent_id"`
 	Result  string `json:"result"`
 }
 
-func (e TaskFinishEvent) Subject() string { return TaskFinishEventName }
+func (e TaskFinishEvent) Subject() string    { return TaskFinishEventName }
+func (e TaskFinishEvent) GetAgentID() string { return e.AgentID }
 
 type TaskErrorEvent struct {
 	AgentID string `json:"agent_id"`
 	Error   string `json:"error"`
 }
 
-func (e TaskErrorEvent) Subject() string { return TaskErrorEventName }
+func (e TaskErrorEvent) Subject() string    { return TaskErrorEventName }
+func (e TaskErrorEvent) GetAgentID() string { return e.AgentID }
+
+// TaskCancelEvent stops a primary agent. Status is the terminal task state it
+// ends in, "cancelled" or "timed_out".
+type TaskCancelEvent struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+}
+
+func (e TaskCancelEvent) Subject() string    { return TaskCancelEventName }
+func (e TaskCancelEvent) GetAgentID() string { return e.AgentID }
@@ -19,21 +19,24 @@ type ToolsExecRequestEvent struct {
 	ToolCalls []ToolCall `json:"tool_calls"`
 }
 
-func (e ToolsExecRequestEvent) Subject() string { return ToolExecRequestEventName }
+func (e ToolsExecRequestEvent) Subject() string    { return ToolExecRequestEventName }
+func (e ToolsExecRequestEvent) GetAgentID() string { return e.AgentID }
 
 type ToolsExecResultsEvent struct {
 	AgentID     string       `json:"agent_id"`
 	ToolResults []ToolResult `json:"tool_results"`
 }
 
-func (e ToolsExecResultsEvent) Subject() string { return ToolExecResultsEventName }
+func (e ToolsExecResultsEvent) Subject() string    { return ToolExecResultsEventName }
+func (e ToolsExecResultsEvent) GetAgentID() string { return e.AgentID }
 
 type ToolRuntimeErrorEvent struct {
 	AgentID string `json:"agent_id"`
 	Error   string `json:"error"`
 }
 
-func (e ToolRuntimeErrorEvent) Subject() string { return ToolRuntimeErrorEventName }
+func (e ToolRuntimeErrorEvent) Subject() string    { return ToolRuntimeErrorEventName }
+func (e ToolRuntimeErrorEvent) GetAgentID() string { return e.AgentID }
 
 type ToolExecStartEvent struct {
 	AgentID    string         `json:"agent_id"`
@@ -42,7 +45,8 @@ type ToolExecStartEvent struct {
 	Arguments  map[string]any `json:"arguments"`
 }
 
-func (e ToolExecStartEvent) Subject() string { return ToolExecStartEventName }
+func (e ToolExecStartEvent) Subject() string    { return ToolExecStartEventName }
+func (e ToolExecStartEvent) GetAgentID() string { return e.AgentID }
 
 type ToolExecFinishEvent struct {
 	AgentID    string `json:"agent_id"`
@@ -51,7 +55,8 @@ type ToolExecFinishEvent struct {
 	Result     string `json:"result"`
 }
 
-func (e ToolExecFinishEvent) Subject() string { return ToolExecFinishEventName }
+func (e ToolExecFinishEvent) Subject() string    { return ToolExecFinishEventName }
+func (e ToolExecFinishEvent) GetAgentID() string { return e.AgentID }
 
 type ToolExecErrorEvent struct {
 	AgentID    string `json:"agent_id"`
@@ -60,4 +65,35 @@ type ToolExecErrorEvent struct {
 	Error      string `json:"error"`
 }
 
-func (e ToolExecErrorEvent) Subject() string { return ToolExecErrorEventName }
+func (e ToolExecErrorEvent) Subject() string    { return ToolExecErrorEventName }
+func (e ToolExecErrorEvent) GetAgentID() string { return e.AgentID }
+
+// ToolApprovalRequestEvent announces tool calls that wait for a person to
+// approve or reject them before the agent can continue.
+type ToolApprovalRequestEvent struct {
+	AgentID   string     `json:"agent_id"`
+	ToolCalls []ToolCall `json:"tool_calls"`
+}
+
+func (e ToolApprovalRequestEvent) Subject() string    { return ToolApprovalRequestEventName }
+func (e ToolApprovalRequestEvent) GetAgentID() string { return e.AgentID }
+
+// UserInputRequestEvent carries a question an agent asked through the
+// ask_user tool. The answer becomes the tool call's result.
+type UserInputRequestEvent struct {
+	AgentID    string `json:"agent_id"`
+	ToolCallID string `json:"tool_call_id"`
+	Question   string `json:"question"`
+}
+
+func (e UserInputRequestEvent) Subject() string    { return UserInputRequestEventName }
+func (e UserInputRequestEvent) GetAgentID() string { return e.AgentID }
+
+// ToolCallsResolvedEvent tells the agent runtime that every pending call of
+// the agent has been decided or answered.
+type ToolCallsResolvedEvent struct {
+	AgentID string `json:"agent_id"`
+}
+
+func (e ToolCallsResolvedEvent) Subject() string    { return ToolCallsResolvedEventName }
+func (e ToolCallsResolvedEvent) GetAgentID() string { return e.AgentID }
@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
 	"github.com/cugtyt/agentlauncher-distributed/internal/events"
@@ -11,13 +14,16 @@ import (
 	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
 )
 
+const maxConversationWriteAttempts = 3
+
 type AgentHandler struct {
-	eventBus              *eventbus.DistributedEventBus
-	agentStore            *store.AgentStore
+	eventBus              eventbus.EventBus
+	agentStore            store.AgentStore
+	archiveStore          store.ArchiveStore
 	conversationProcessor func([]llminterface.Message) []llminterface.Message
 }
 
-func NewAgentHandler(eb *eventbus.DistributedEventBus, as *store.AgentStore) *AgentHandler {
+func NewAgentHandler(eb eventbus.EventBus, as store.AgentStore) *AgentHandler {
 	return &AgentHandler{
 		eventBus:   eb,
 		agentStore: as,
@@ -29,13 +35,60 @@ func (ah *AgentHandler) SetConversationProcessor(processor func([]llminterface.M
 	return ah
 }
 
+func (ah *AgentHandler) SetArchiveStore(archiveStore store.ArchiveStore) *AgentHandler {
+	ah.archiveStore = archiveStore
+	return ah
+}
+
+func (ah *AgentHandler) lockAgent(ctx context.Context, agentID string) (func(), bool) {
+	unlock, err := ah.agentStore.Lock(ctx, agentID)
+	if err != nil {
+		log.Printf("[%s] Failed to lock agent: %v", agentID, err)
+		return nil, false
+	}
+	return unlock, true
+}
+
+// appendToConversation re-reads the stored transcript and retries when another
+// writer got there first, so no message is ever overwritten by a stale copy.
+func (ah *AgentHandler) appendToConversation(agentID string, messages []llminterface.Message) ([]llminterface.Message, error) {
+	for attempt := 0; attempt < maxConversationWriteAttempts; attempt++ {
+		conversation, err := ah.agentStore.GetConversation(agentID)
+		if err != nil {
+			return nil, err
+		}
+
+		updatedConversation := append(conversation.Messages, messages...)
+		if ah.conversationProcessor != nil {
+			updatedConversation = ah.conversationProcessor(updatedConversation)
+			_, err = ah.agentStore.ReplaceConversation(agentID, conversation.Version, updatedConversation)
+		} else {
+			_, err = ah.agentStore.AppendConversation(agentID, conversation.Version, messages...)
+		}
+
+		if errors.Is(err, store.ErrConversationConflict) {
+			log.Printf("[%s] Conversation changed concurrently, retrying (attempt %d)", agentID, attempt+1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updatedConversation, nil
+	}
+
+	return nil, fmt.Errorf("failed to update conversation after %d attempts: %w", maxConversationWriteAttempts, store.ErrConversationConflict)
+}
+
 func (ah *AgentHandler) HandleTaskCreate(ctx context.Context, event events.TaskCreateEvent) {
 	agentCreateEvent := events.AgentCreateEvent{
-		AgentID:      event.AgentID,
-		Task:         event.Task,
-		ToolSchemas:  event.ToolSchemas,
-		Conversation: event.Conversation,
-		SystemPrompt: event.SystemPrompt,
+		AgentID:          event.AgentID,
+		Task:             event.Task,
+		ToolSchemas:      event.ToolSchemas,
+		Conversation:     event.Conversation,
+		SystemPrompt:     event.SystemPrompt,
+		SessionExpiresAt: event.SessionExpiresAt,
+		Limits:           event.Limits,
+		ModelParams:      event.ModelParams,
 	}
 
 	if err := ah.eventBus.Emit(agentCreateEvent); err != nil {
@@ -45,6 +98,12 @@ func (ah *AgentHandler) HandleTaskCreate(ctx context.Context, event events.TaskC
 }
 
 func (ah *AgentHandler) HandleAgentCreate(ctx context.Context, event events.AgentCreateEvent) {
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	log.Printf("[%s] HandleAgentCreate: Starting agent creation", event.AgentID)
 
 	if exists, _ := ah.agentStore.Exists(event.AgentID); exists {
@@ -58,11 +117,15 @@ func (ah *AgentHandler) HandleAgentCreate(ctx context.Context, event events.Agen
 	}
 
 	agentData := &store.AgentData{
-		AgentID:      event.AgentID,
-		Task:         event.Task,
-		SystemPrompt: event.SystemPrompt,
-		ToolSchemas:  event.ToolSchemas,
-		Messages:     event.Conversation,
+		AgentID:          event.AgentID,
+		Task:             event.Task,
+		SystemPrompt:     event.SystemPrompt,
+		ToolSchemas:      event.ToolSchemas,
+		Messages:         event.Conversation,
+		CreatedAt:        time.Now().UTC(),
+		SessionExpiresAt: event.SessionExpiresAt,
+		Limits:           event.Limits,
+		ModelParams:      event.ModelParams,
 	}
 
 	log.Printf("[%s] HandleAgentCreate: Creating agent with data", event.AgentID)
@@ -77,6 +140,10 @@ func (ah *AgentHandler) HandleAgentCreate(ctx context.Context, event events.Agen
 		return
 	}
 
+	if utils.IsSubAgent(event.AgentID) {
+		ah.registerSubAgent(ctx, event.AgentID)
+	}
+
 	startEvent := events.AgentStartEvent{
 		AgentID: event.AgentID,
 	}
@@ -87,8 +154,44 @@ func (ah *AgentHandler) HandleAgentCreate(ctx context.Context, event events.Agen
 	}
 }
 
+// registerSubAgent records a sub-agent on its primary so that the primary's
+// transcript can be returned together with its sub-agents'.
+func (ah *AgentHandler) registerSubAgent(ctx context.Context, subAgentID string) {
+	primaryAgentID, err := utils.GetPrimaryAgentID(subAgentID)
+	if err != nil {
+		return
+	}
+
+	unlock, ok := ah.lockAgent(ctx, primaryAgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	primary, err := ah.agentStore.GetAgent(primaryAgentID)
+	if err != nil {
+		log.Printf("[%s] Failed to register sub-agent %s: %v", primaryAgentID, subAgentID, err)
+		return
+	}
+
+	primary.SubAgentIDs = append(primary.SubAgentIDs, subAgentID)
+	if err := ah.agentStore.UpdateAgent(primary); err != nil {
+		log.Printf("[%s] Failed to register sub-agent %s: %v", primaryAgentID, subAgentID, err)
+	}
+}
+
 func (ah *AgentHandler) HandleAgentStart(ctx context.Context, event events.AgentStartEvent) {
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	agent, err := ah.agentStore.GetAgent(event.AgentID)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Printf("[%s] Agent no longer exists, ignoring %s", event.AgentID, event.Subject())
+		return
+	}
 	if err != nil {
 		log.Printf("[%s] Failed to get agent: %v", event.AgentID, err)
 
@@ -103,39 +206,37 @@ func (ah *AgentHandler) HandleAgentStart(ctx context.Context, event events.Agent
 	taskMsg := llminterface.NewUserMessage(agent.Task)
 	updatedConversation := append(agent.Messages, taskMsg)
 
-	if err := ah.agentStore.SetConversation(event.AgentID, updatedConversation); err != nil {
-		log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
-	}
-
-	messages := []llminterface.Message{}
-	if agent.SystemPrompt != "" {
-		systemMsg := llminterface.NewSystemMessage(agent.SystemPrompt)
-		messages = append(messages, systemMsg)
-	}
-	messages = append(messages, updatedConversation...)
-
-	llmRequest := events.LLMRequestEvent{
-		AgentID:     event.AgentID,
-		Messages:    messages,
-		ToolSchemas: agent.ToolSchemas,
-		RetryCount:  0,
-	}
-
-	if err := ah.eventBus.Emit(llmRequest); err != nil {
-		log.Printf("[%s] Failed to emit LLM request: %v", event.AgentID, err)
+	if _, err := ah.agentStore.AppendConversation(event.AgentID, 0, updatedConversation...); err != nil {
+		log.Printf("[%s] Failed to start conversation: %v", event.AgentID, err)
+		if errors.Is(err, store.ErrConversationConflict) {
+			return
+		}
 
 		errorEvent := events.AgentErrorEvent{
 			AgentID: event.AgentID,
 			Error:   err.Error(),
 		}
 		ah.eventBus.Emit(errorEvent)
+		return
 	}
+
+	ah.requestCompletion(agent, updatedConversation)
 }
 
 func (ah *AgentHandler) HandleLLMResponse(ctx context.Context, event events.LLMResponseEvent) {
-	conversation, err := ah.agentStore.GetConversation(event.AgentID)
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	_, err := ah.appendToConversation(event.AgentID, event.Response)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Printf("[%s] Agent no longer exists, ignoring %s", event.AgentID, event.Subject())
+		return
+	}
 	if err != nil {
-		log.Printf("[%s] Failed to get conversation: %v", event.AgentID, err)
+		log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
 
 		errorEvent := events.AgentErrorEvent{
 			AgentID: event.AgentID,
@@ -145,16 +246,6 @@ func (ah *AgentHandler) HandleLLMResponse(ctx context.Context, event events.LLMR
 		return
 	}
 
-	updatedConversation := append(conversation, event.Response...)
-
-	if ah.conversationProcessor != nil {
-		updatedConversation = ah.conversationProcessor(updatedConversation)
-	}
-
-	if err := ah.agentStore.SetConversation(event.AgentID, updatedConversation); err != nil {
-		log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
-	}
-
 	var toolCalls []events.ToolCall
 	var finalResponse string
 
@@ -173,6 +264,24 @@ func (ah *AgentHandler) HandleLLMResponse(ctx context.Context, event events.LLMR
 		}
 	}
 
+	agent := ah.recordUsage(event.AgentID, store.AgentUsage{LLMCalls: 1, ToolCalls: len(toolCalls)})
+
+	if len(toolCalls) > 0 && agent != nil {
+		if reason := agent.LimitReached(); reason != "" {
+			log.Printf("[%s] Stopping agent: %s", event.AgentID, reason)
+			errorEvent := events.AgentErrorEvent{
+				AgentID: event.AgentID,
+				Error:   reason,
+			}
+			ah.eventBus.Emit(errorEvent)
+			return
+		}
+	}
+
+	if len(toolCalls) > 0 && ah.holdToolCalls(event.AgentID, toolCalls) {
+		return
+	}
+
 	if len(toolCalls) > 0 {
 		toolsRequest := events.ToolsExecRequestEvent{
 			AgentID:   event.AgentID,
@@ -200,7 +309,17 @@ func (ah *AgentHandler) HandleLLMResponse(ctx context.Context, event events.LLMR
 }
 
 func (ah *AgentHandler) HandleToolResult(ctx context.Context, event events.ToolsExecResultsEvent) {
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	agent, err := ah.agentStore.GetAgent(event.AgentID)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Printf("[%s] Agent no longer exists, ignoring %s", event.AgentID, event.Subject())
+		return
+	}
 	if err != nil {
 		log.Printf("[%s] Failed to get agent: %v", event.AgentID, err)
 
@@ -212,55 +331,387 @@ func (ah *AgentHandler) HandleToolResult(ctx context.Context, event events.Tools
 		return
 	}
 
-	toolMessages := make([]llminterface.Message, 0, len(event.ToolResults))
-	for _, result := range event.ToolResults {
-		msg := llminterface.NewToolResultMessage(result.ToolCallID, result.ToolName, result.Result)
-		toolMessages = append(toolMessages, msg)
+	heldForApproval := len(agent.PendingToolCalls) > 0
+	toolMessages := ah.toolResultMessages(agent, event.ToolResults)
+	if heldForApproval {
+		if err := ah.agentStore.UpdateAgent(agent); err != nil {
+			log.Printf("[%s] Failed to clear tool approvals: %v", event.AgentID, err)
+		}
+	}
+
+	updatedConversation, err := ah.appendToConversation(event.AgentID, toolMessages)
+	if err != nil {
+		log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return
+	}
+
+	ah.requestCompletion(agent, updatedConversation)
+}
+
+// holdToolCalls parks the agent when any of its tool calls needs approval or
+// asks the user a question. The whole batch waits so that the results reach
+// the model together.
+func (ah *AgentHandler) holdToolCalls(agentID string, toolCalls []events.ToolCall) bool {
+	agent, err := ah.agentStore.GetAgent(agentID)
+	if err != nil {
+		log.Printf("[%s] Failed to check tool approvals: %v", agentID, err)
+		return false
 	}
 
-	updatedConversation := append(agent.Messages, toolMessages...)
+	needsApproval := make(map[string]bool)
+	for _, schema := range agent.ToolSchemas {
+		if schema.RequiresApproval {
+			needsApproval[schema.Name] = true
+		}
+	}
 
-	if ah.conversationProcessor != nil {
-		updatedConversation = ah.conversationProcessor(updatedConversation)
+	var pending []store.PendingToolCall
+	var approvalCalls []events.ToolCall
+	var questions []events.UserInputRequestEvent
+	for _, toolCall := range toolCalls {
+		call := store.PendingToolCall{
+			ToolCallID: toolCall.ToolCallID,
+			ToolName:   toolCall.ToolName,
+			Arguments:  toolCall.Arguments,
+		}
+		switch {
+		case toolCall.ToolName == AskUserToolName:
+			call.Question, _ = toolCall.Arguments["question"].(string)
+			if call.Question == "" {
+				call.Question = "The agent is waiting for your input."
+			}
+			questions = append(questions, events.UserInputRequestEvent{
+				AgentID:    agentID,
+				ToolCallID: toolCall.ToolCallID,
+				Question:   call.Question,
+			})
+		case needsApproval[toolCall.ToolName]:
+			call.RequiresApproval = true
+			approvalCalls = append(approvalCalls, toolCall)
+		}
+		pending = append(pending, call)
+	}
+	if len(approvalCalls) == 0 && len(questions) == 0 {
+		return false
 	}
 
-	if err := ah.agentStore.SetConversation(event.AgentID, updatedConversation); err != nil {
-		log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
+	agent.PendingToolCalls = pending
+	agent.ToolCallsOnHold = true
+	if err := ah.agentStore.UpdateAgent(agent); err != nil {
+		log.Printf("[%s] Failed to hold tool calls: %v", agentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: agentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return true
+	}
+
+	// The launcher keeps the status of the latest event, so the approval
+	// request goes last to match AgentData.HeldTaskStatus.
+	for _, question := range questions {
+		log.Printf("[%s] Waiting for the user to answer: %s", agentID, question.Question)
+		if err := ah.eventBus.Emit(question); err != nil {
+			log.Printf("[%s] Failed to emit user input request: %v", agentID, err)
+		}
+	}
+	if len(approvalCalls) > 0 {
+		log.Printf("[%s] Waiting for approval of %d tool calls", agentID, len(approvalCalls))
+		approvalEvent := events.ToolApprovalRequestEvent{
+			AgentID:   agentID,
+			ToolCalls: approvalCalls,
+		}
+		if err := ah.eventBus.Emit(approvalEvent); err != nil {
+			log.Printf("[%s] Failed to emit tool approval request: %v", agentID, err)
+		}
+	}
+	return true
+}
+
+func (ah *AgentHandler) HandleToolCallsResolved(ctx context.Context, event events.ToolCallsResolvedEvent) {
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	agent, err := ah.agentStore.GetAgent(event.AgentID)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Printf("[%s] Agent no longer exists, ignoring %s", event.AgentID, event.Subject())
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get agent: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return
+	}
+
+	if !agent.ToolCallsOnHold || !agent.HeldToolCallsResolved() {
+		log.Printf("[%s] No decided tool approvals, ignoring %s", event.AgentID, event.Subject())
+		return
+	}
+
+	if err := ah.applyEditedArguments(agent); err != nil {
+		log.Printf("[%s] Failed to record edited tool arguments: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return
+	}
+
+	var runnable []events.ToolCall
+	for _, call := range agent.PendingToolCalls {
+		if call.IsQuestion() {
+			continue
+		}
+		if !call.RequiresApproval || call.Decision == store.ToolCallApproved {
+			runnable = append(runnable, events.ToolCall{
+				AgentID:    event.AgentID,
+				ToolName:   call.ToolName,
+				ToolCallID: call.ToolCallID,
+				Arguments:  call.Arguments,
+			})
+		}
+	}
+
+	agent.ToolCallsOnHold = false
+	if len(runnable) == 0 {
+		heldResults := ah.toolResultMessages(agent, nil)
+		if err := ah.agentStore.UpdateAgent(agent); err != nil {
+			log.Printf("[%s] Failed to clear tool approvals: %v", event.AgentID, err)
+		}
+
+		updatedConversation, err := ah.appendToConversation(event.AgentID, heldResults)
+		if err != nil {
+			log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
+
+			errorEvent := events.AgentErrorEvent{
+				AgentID: event.AgentID,
+				Error:   err.Error(),
+			}
+			ah.eventBus.Emit(errorEvent)
+			return
+		}
+		ah.requestCompletion(agent, updatedConversation)
+		return
 	}
 
+	if err := ah.agentStore.UpdateAgent(agent); err != nil {
+		log.Printf("[%s] Failed to record tool approvals: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return
+	}
+
+	toolsRequest := events.ToolsExecRequestEvent{
+		AgentID:   event.AgentID,
+		ToolCalls: runnable,
+	}
+	if err := ah.eventBus.Emit(toolsRequest); err != nil {
+		log.Printf("[%s] Failed to emit tools request: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+	}
+}
+
+// applyEditedArguments rewrites the tool call messages whose arguments were
+// edited during approval, so the model sees the call that actually ran.
+func (ah *AgentHandler) applyEditedArguments(agent *store.AgentData) error {
+	edited := make(map[string]map[string]any)
+	for _, call := range agent.PendingToolCalls {
+		if call.Edited {
+			edited[call.ToolCallID] = call.Arguments
+		}
+	}
+	if len(edited) == 0 {
+		return nil
+	}
+
+	conversation, err := ah.agentStore.GetConversation(agent.AgentID)
+	if err != nil {
+		return err
+	}
+	for i, msg := range conversation.Messages {
+		if args, ok := edited[msg.ToolCallID]; ok && msg.Type == llminterface.MessageTypeToolCall {
+			conversation.Messages[i].Arguments = args
+		}
+	}
+	_, err = ah.agentStore.ReplaceConversation(agent.AgentID, conversation.Version, conversation.Messages)
+	return err
+}
+
+// toolResultMessages turns tool results into conversation messages. After
+// calls were held it also adds the answers and the rejections, in the order
+// the model made the calls, and clears the agent's pending calls.
+func (ah *AgentHandler) toolResultMessages(agent *store.AgentData, results []events.ToolResult) []llminterface.Message {
+	messages := make([]llminterface.Message, 0, len(results)+len(agent.PendingToolCalls))
+
+	byID := make(map[string]events.ToolResult, len(results))
+	for _, result := range results {
+		byID[result.ToolCallID] = result
+	}
+	for _, call := range agent.PendingToolCalls {
+		if call.IsQuestion() {
+			messages = append(messages, llminterface.NewToolResultMessage(call.ToolCallID, call.ToolName, call.Answer))
+			continue
+		}
+		if call.Decision == store.ToolCallRejected {
+			result := "Tool call rejected by user"
+			if call.Reason != "" {
+				result += ": " + call.Reason
+			}
+			messages = append(messages, llminterface.NewToolResultMessage(call.ToolCallID, call.ToolName, result))
+			continue
+		}
+		if result, ok := byID[call.ToolCallID]; ok {
+			messages = append(messages, llminterface.NewToolResultMessage(result.ToolCallID, result.ToolName, result.Result))
+			delete(byID, call.ToolCallID)
+		}
+	}
+	for _, result := range results {
+		if _, ok := byID[result.ToolCallID]; ok {
+			messages = append(messages, llminterface.NewToolResultMessage(result.ToolCallID, result.ToolName, result.Result))
+		}
+	}
+
+	agent.PendingToolCalls = nil
+	return messages
+}
+
+// requestCompletion sends the agent's conversation, behind its system prompt,
+// to the LLM runtime.
+func (ah *AgentHandler) requestCompletion(agent *store.AgentData, conversation []llminterface.Message) {
 	messages := []llminterface.Message{}
 	if agent.SystemPrompt != "" {
 		systemMsg := llminterface.NewSystemMessage(agent.SystemPrompt)
 		messages = append(messages, systemMsg)
 	}
-	messages = append(messages, updatedConversation...)
+	messages = append(messages, conversation...)
 
 	llmRequest := events.LLMRequestEvent{
-		AgentID:     event.AgentID,
+		AgentID:     agent.AgentID,
 		Messages:    messages,
 		ToolSchemas: agent.ToolSchemas,
+		ModelParams: agent.ModelParams,
 		RetryCount:  0,
 	}
 
 	if err := ah.eventBus.Emit(llmRequest); err != nil {
-		log.Printf("[%s] Failed to emit LLM request: %v", event.AgentID, err)
+		log.Printf("[%s] Failed to emit LLM request: %v", agent.AgentID, err)
 
 		errorEvent := events.AgentErrorEvent{
-			AgentID: event.AgentID,
+			AgentID: agent.AgentID,
 			Error:   err.Error(),
 		}
 		ah.eventBus.Emit(errorEvent)
 	}
 }
 
+// recordUsage adds usage to the agent and returns the updated agent, or nil
+// when it could not be recorded.
+func (ah *AgentHandler) recordUsage(agentID string, usage store.AgentUsage) *store.AgentData {
+	agent, err := ah.agentStore.GetAgent(agentID)
+	if err != nil {
+		log.Printf("[%s] Failed to record usage: %v", agentID, err)
+		return nil
+	}
+
+	agent.Usage = agent.Usage.Add(usage)
+	if err := ah.agentStore.UpdateAgent(agent); err != nil {
+		log.Printf("[%s] Failed to record usage: %v", agentID, err)
+		return nil
+	}
+	return agent
+}
+
+// archiveAgent copies the agent's final state into the archive before
+// AgentDeletedEvent removes it from the live store. It is a no-op when no
+// archive is configured.
+func (ah *AgentHandler) archiveAgent(ctx context.Context, agentID, status, result, errorMsg string) {
+	if ah.archiveStore == nil {
+		return
+	}
+
+	unlock, ok := ah.lockAgent(ctx, agentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	primaryAgentID := agentID
+	if utils.IsSubAgent(agentID) {
+		primaryAgentID, _ = utils.GetPrimaryAgentID(agentID)
+	}
+
+	archived := &store.ArchivedAgent{
+		AgentID:        agentID,
+		PrimaryAgentID: primaryAgentID,
+		Status:         status,
+		Result:         result,
+		Error:          errorMsg,
+		Messages:       []llminterface.Message{},
+		FinishedAt:     time.Now().UTC(),
+	}
+
+	if agent, err := ah.agentStore.GetAgent(agentID); err == nil {
+		archived.Task = agent.Task
+		archived.SystemPrompt = agent.SystemPrompt
+		archived.Usage = agent.Usage
+		archived.CreatedAt = agent.CreatedAt
+	} else {
+		log.Printf("[%s] Archiving without agent data: %v", agentID, err)
+		archived.CreatedAt = archived.FinishedAt
+	}
+
+	if conversation, err := ah.agentStore.GetConversation(agentID); err == nil {
+		archived.Messages = conversation.Messages
+	} else if !errors.Is(err, store.ErrNotFound) {
+		log.Printf("[%s] Archiving without conversation: %v", agentID, err)
+	}
+
+	if err := ah.archiveStore.ArchiveAgent(archived); err != nil {
+		log.Printf("[%s] Failed to archive agent: %v", agentID, err)
+	}
+}
+
 func (ah *AgentHandler) HandleAgentFinish(ctx context.Context, event events.AgentFinishEvent) {
 	log.Printf("[%s] Agent finished with result: %s", event.AgentID, event.Result)
 
+	ah.archiveAgent(ctx, event.AgentID, store.TaskStatusSucceeded, event.Result, "")
+
 	if utils.IsPrimaryAgent(event.AgentID) {
 		taskFinishEvent := events.TaskFinishEvent(event)
 		ah.eventBus.Emit(taskFinishEvent)
 	}
 
+	if ah.isSession(event.AgentID) {
+		log.Printf("[%s] Keeping session for follow-up messages", event.AgentID)
+		return
+	}
+
 	deletedEvent := events.AgentDeletedEvent{
 		AgentID: event.AgentID,
 	}
@@ -270,11 +721,94 @@ func (ah *AgentHandler) HandleAgentFinish(ctx context.Context, event events.Agen
 func (ah *AgentHandler) HandleAgentError(ctx context.Context, event events.AgentErrorEvent) {
 	log.Printf("[%s] Agent error handled: %s", event.AgentID, event.Error)
 
+	ah.archiveAgent(ctx, event.AgentID, store.TaskStatusFailed, "", event.Error)
+
 	if utils.IsPrimaryAgent(event.AgentID) {
 		taskErrorEvent := events.TaskErrorEvent(event)
 		ah.eventBus.Emit(taskErrorEvent)
 	}
 
+	if ah.isSession(event.AgentID) {
+		log.Printf("[%s] Keeping session for follow-up messages", event.AgentID)
+		return
+	}
+
+	deletedEvent := events.AgentDeletedEvent{
+		AgentID: event.AgentID,
+	}
+	ah.eventBus.Emit(deletedEvent)
+}
+
+func (ah *AgentHandler) HandleTaskCancel(ctx context.Context, event events.TaskCancelEvent) {
+	log.Printf("[%s] Task %s: %s", event.AgentID, event.Status, event.Reason)
+
+	if exists, _ := ah.agentStore.Exists(event.AgentID); !exists {
+		return
+	}
+
+	ah.archiveAgent(ctx, event.AgentID, event.Status, "", event.Reason)
+
+	deletedEvent := events.AgentDeletedEvent{
+		AgentID: event.AgentID,
+	}
+	ah.eventBus.Emit(deletedEvent)
+}
+
+// isSession reports whether the agent outlives its turns. Only primary agents
+// can be sessions; their sub-agents are deleted as usual.
+func (ah *AgentHandler) isSession(agentID string) bool {
+	if !utils.IsPrimaryAgent(agentID) {
+		return false
+	}
+	agent, err := ah.agentStore.GetAgent(agentID)
+	if err != nil {
+		return false
+	}
+	return agent.IsSession()
+}
+
+func (ah *AgentHandler) HandleSessionMessage(ctx context.Context, event events.SessionMessageEvent) {
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	agent, err := ah.agentStore.GetAgent(event.AgentID)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Printf("[%s] Session no longer exists, ignoring %s", event.AgentID, event.Subject())
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get agent: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return
+	}
+
+	userMsg := llminterface.NewUserMessage(event.Content)
+	updatedConversation, err := ah.appendToConversation(event.AgentID, []llminterface.Message{userMsg})
+	if err != nil {
+		log.Printf("[%s] Failed to update conversation: %v", event.AgentID, err)
+
+		errorEvent := events.AgentErrorEvent{
+			AgentID: event.AgentID,
+			Error:   err.Error(),
+		}
+		ah.eventBus.Emit(errorEvent)
+		return
+	}
+
+	ah.requestCompletion(agent, updatedConversation)
+}
+
+func (ah *AgentHandler) HandleSessionClose(ctx context.Context, event events.SessionCloseEvent) {
+	log.Printf("[%s] Session closed: %s", event.AgentID, event.Reason)
+
 	deletedEvent := events.AgentDeletedEvent{
 		AgentID: event.AgentID,
 	}
@@ -282,6 +816,12 @@ func (ah *AgentHandler) HandleAgentError(ctx context.Context, event events.Agent
 }
 
 func (ah *AgentHandler) HandleAgentDeleted(ctx context.Context, event events.AgentDeletedEvent) {
+	unlock, ok := ah.lockAgent(ctx, event.AgentID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	log.Printf("[%s] Agent deleted", event.AgentID)
 	ah.agentStore.Delete(event.AgentID)
 }
@@ -2,30 +2,133 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
+	"sync"
 
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
 	"github.com/cugtyt/agentlauncher-distributed/internal/events"
 	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+const (
+	CreateAgentToolName = "create_agent"
+	AskUserToolName     = "ask_user"
 )
 
 type LauncherHandler struct {
-	taskStore *store.TaskStore
+	taskStore      store.TaskStore
+	onTaskFinished func(task *store.TaskData)
+	httpClient     *http.Client
+	callbacks      sync.WaitGroup
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
-func NewLauncherHandler(taskStore *store.TaskStore) *LauncherHandler {
+func NewLauncherHandler(taskStore store.TaskStore) *LauncherHandler {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &LauncherHandler{
-		taskStore: taskStore,
+		taskStore:  taskStore,
+		httpClient: &http.Client{},
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
-func (h *LauncherHandler) HandleTaskFinish(ctx context.Context, event events.TaskFinishEvent) {
-	if err := h.taskStore.CreateTaskSuccess(event.AgentID, event.Result); err != nil {
-		log.Printf("Failed to update task success for agent %s: %v", event.AgentID, err)
+// OnTaskFinished registers fn to be called with every task that reaches a
+// final status, after the status has been stored.
+func (h *LauncherHandler) OnTaskFinished(fn func(task *store.TaskData)) {
+	h.onTaskFinished = fn
+}
+
+// TaskFinished notifies the task's callback and the OnTaskFinished hook. A
+// nil task, from a transition that did not apply, is ignored.
+func (h *LauncherHandler) TaskFinished(task *store.TaskData) {
+	if task == nil || !store.IsTerminalTaskStatus(task.Status) {
+		return
+	}
+	h.NotifyCallback(task)
+	if h.onTaskFinished != nil {
+		h.onTaskFinished(task)
 	}
 }
 
-func (h *LauncherHandler) HandleTaskError(ctx context.Context, event events.TaskErrorEvent) {
-	if err := h.taskStore.CreateTaskFailed(event.AgentID, event.Error); err != nil {
-		log.Printf("Failed to update task failure for agent %s: %v", event.AgentID, err)
+// Close abandons callback deliveries still in flight, which record them as
+// failed, and waits for them to return.
+func (h *LauncherHandler) Close() {
+	h.cancel()
+	h.callbacks.Wait()
+}
+
+// updateTask records a lifecycle transition of a primary agent's task.
+// Sub-agent events are ignored: their primary is waiting_for_subagent for as
+// long as they run. The events come from separate consumers and can arrive
+// out of order, so a transition from an event older than the one that last
+// updated the task is dropped. The updated task is returned when the
+// transition applied.
+func (h *LauncherHandler) updateTask(ctx context.Context, agentID string, update store.TaskUpdate) *store.TaskData {
+	if !utils.IsPrimaryAgent(agentID) {
+		return nil
+	}
+
+	update.EventSeq = eventbus.EventSequence(ctx)
+	task, err := h.taskStore.UpdateTask(agentID, update)
+	if errors.Is(err, store.ErrTaskFinished) {
+		log.Printf("[%s] Ignoring %s transition for finished task", agentID, update.Status)
+		return nil
+	}
+	if errors.Is(err, store.ErrStaleUpdate) {
+		log.Printf("[%s] Ignoring %s transition from an out-of-date event", agentID, update.Status)
+		return nil
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to move task to %s: %v", agentID, update.Status, err)
+		return nil
+	}
+	return task
+}
+
+func (h *LauncherHandler) HandleAgentStart(ctx context.Context, event events.AgentStartEvent) {
+	h.updateTask(ctx, event.AgentID, store.TaskUpdate{Status: store.TaskStatusRunning})
+}
+
+func (h *LauncherHandler) HandleToolsExecRequest(ctx context.Context, event events.ToolsExecRequestEvent) {
+	status := store.TaskStatusWaitingForTool
+	for _, toolCall := range event.ToolCalls {
+		if toolCall.ToolName == CreateAgentToolName {
+			status = store.TaskStatusWaitingForSubagent
+			break
+		}
 	}
+	h.updateTask(ctx, event.AgentID, store.TaskUpdate{Status: status})
+}
+
+func (h *LauncherHandler) HandleToolApprovalRequest(ctx context.Context, event events.ToolApprovalRequestEvent) {
+	h.updateTask(ctx, event.AgentID, store.TaskUpdate{Status: store.TaskStatusWaitingForApproval})
+}
+
+func (h *LauncherHandler) HandleUserInputRequest(ctx context.Context, event events.UserInputRequestEvent) {
+	h.updateTask(ctx, event.AgentID, store.TaskUpdate{Status: store.TaskStatusWaitingForInput})
+}
+
+func (h *LauncherHandler) HandleToolsExecResults(ctx context.Context, event events.ToolsExecResultsEvent) {
+	h.updateTask(ctx, event.AgentID, store.TaskUpdate{Status: store.TaskStatusRunning})
+}
+
+func (h *LauncherHandler) HandleTaskFinish(ctx context.Context, event events.TaskFinishEvent) {
+	task := h.updateTask(ctx, event.AgentID, store.TaskUpdate{
+		Status: store.TaskStatusSucceeded,
+		Result: event.Result,
+	})
+	h.TaskFinished(task)
+}
+
+func (h *LauncherHandler) HandleTaskError(ctx context.Context, event events.TaskErrorEvent) {
+	task := h.updateTask(ctx, event.AgentID, store.TaskUpdate{
+		Status: store.TaskStatusFailed,
+		Error:  event.Error,
+	})
+	h.TaskFinished(task)
 }
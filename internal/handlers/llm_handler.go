@@ -10,11 +10,11 @@ import (
 )
 
 type LLMHandler struct {
-	eventBus     *eventbus.DistributedEventBus
+	eventBus     eventbus.EventBus
 	llmProcessor llminterface.LLMProcessor
 }
 
-func NewLLMHandler(eb *eventbus.DistributedEventBus, processor llminterface.LLMProcessor) *LLMHandler {
+func NewLLMHandler(eb eventbus.EventBus, processor llminterface.LLMProcessor) *LLMHandler {
 	if processor == nil {
 		panic("LLM processor cannot be nil")
 	}
@@ -59,6 +59,7 @@ func (lh *LLMHandler) HandleLLMRuntimeError(ctx context.Context, event events.LL
 			AgentID:     event.RequestEvent.AgentID,
 			Messages:    event.RequestEvent.Messages,
 			ToolSchemas: event.RequestEvent.ToolSchemas,
+			ModelParams: event.RequestEvent.ModelParams,
 			RetryCount:  event.RequestEvent.RetryCount + 1,
 		}
 		if err := lh.eventBus.Emit(retryEvent); err != nil {
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+const (
+	CallbackTimestampHeader = "X-Agentlauncher-Timestamp"
+	CallbackSignatureHeader = "X-Agentlauncher-Signature"
+
+	callbackMaxAttempts    = 5
+	callbackInitialBackoff = time.Second
+	callbackMaxBackoff     = 30 * time.Second
+	callbackAttemptTimeout = 10 * time.Second
+)
+
+// CallbackPayload is the JSON body posted to a task's callback URL once the
+// task finishes.
+type CallbackPayload struct {
+	AgentID    string     `json:"agent_id"`
+	Task       string     `json:"task"`
+	Owner      string     `json:"owner,omitempty"`
+	Status     string     `json:"status"`
+	Result     string     `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// SignCallback returns the signature header value for a callback body:
+// the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the task's secret.
+func SignCallback(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NotifyCallback delivers the finished task to its callback URL in the
+// background. Tasks without a callback are ignored.
+func (h *LauncherHandler) NotifyCallback(task *store.TaskData) {
+	if task == nil || task.Callback == nil || !store.IsTerminalTaskStatus(task.Status) {
+		return
+	}
+
+	payload := CallbackPayload{
+		AgentID:    task.AgentID,
+		Task:       task.Task,
+		Owner:      task.Owner,
+		Status:     task.Status,
+		Result:     task.Result,
+		Error:      task.Error,
+		CreatedAt:  task.CreatedAt,
+		StartedAt:  task.StartedAt,
+		FinishedAt: task.FinishedAt,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[%s] Failed to marshal callback payload: %v", task.AgentID, err)
+		return
+	}
+
+	callback := *task.Callback
+	h.callbacks.Add(1)
+	go func() {
+		defer h.callbacks.Done()
+		h.deliverCallback(task.AgentID, callback, body)
+	}()
+}
+
+// deliverCallback posts the payload until the receiver accepts it, retrying
+// network errors, 429 and 5xx responses with exponential backoff. Other
+// responses are final. The outcome of every attempt is recorded on the task,
+// and a delivery cut short by Close is recorded as failed.
+func (h *LauncherHandler) deliverCallback(agentID string, callback store.TaskCallback, body []byte) {
+	delivery := store.CallbackDelivery{Status: store.CallbackStatusPending}
+	backoff := callbackInitialBackoff
+
+	for delivery.Attempts < callbackMaxAttempts {
+		delivery.Attempts++
+		retry, err := h.postCallback(callback, body)
+		switch {
+		case err == nil:
+			deliveredAt := time.Now().UTC()
+			delivery.Status = store.CallbackStatusDelivered
+			delivery.LastError = ""
+			delivery.DeliveredAt = &deliveredAt
+		case h.ctx.Err() != nil:
+			delivery.Status = store.CallbackStatusFailed
+			delivery.LastError = fmt.Sprintf("delivery interrupted by shutdown: %v", err)
+		default:
+			delivery.LastError = err.Error()
+			if !retry || delivery.Attempts == callbackMaxAttempts {
+				delivery.Status = store.CallbackStatusFailed
+			}
+		}
+		h.recordCallbackDelivery(agentID, delivery)
+		if delivery.Status != store.CallbackStatusPending {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-h.ctx.Done():
+			delivery.Status = store.CallbackStatusFailed
+			delivery.LastError = fmt.Sprintf("delivery interrupted by shutdown: %s", delivery.LastError)
+			h.recordCallbackDelivery(agentID, delivery)
+			return
+		}
+		backoff = min(backoff*2, callbackMaxBackoff)
+	}
+}
+
+func (h *LauncherHandler) recordCallbackDelivery(agentID string, delivery store.CallbackDelivery) {
+	if err := h.taskStore.UpdateCallbackDelivery(agentID, delivery); err != nil {
+		log.Printf("[%s] Failed to record callback delivery: %v", agentID, err)
+	}
+	if delivery.Status == store.CallbackStatusFailed {
+		log.Printf("[%s] Callback delivery failed after %d attempts: %s", agentID, delivery.Attempts, delivery.LastError)
+	}
+}
+
+// postCallback makes one delivery attempt and reports whether a failure is
+// worth retrying.
+func (h *LauncherHandler) postCallback(callback store.TaskCallback, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, callbackAttemptTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create callback request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(CallbackTimestampHeader, timestamp)
+	if callback.Secret != "" {
+		req.Header.Set(CallbackSignatureHeader, SignCallback(callback.Secret, timestamp, body))
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post callback: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("callback returned %s", resp.Status)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+func TestSignCallback(t *testing.T) {
+	got := SignCallback("secret", "1700000000", []byte(`{"agent_id":"agent:1"}`))
+	want := "sha256=c4b4b1fa5dbdcb3dbdab0f86d5ca2150bdba000c153e091348cf4ae4f95a32fa"
+	if got != want {
+		t.Fatalf("SignCallback = %s, want %s", got, want)
+	}
+}
+
+func TestPostCallback(t *testing.T) {
+	body := []byte(`{"agent_id":"agent:1","status":"succeeded"}`)
+
+	tests := []struct {
+		name      string
+		status    int
+		wantErr   bool
+		wantRetry bool
+	}{
+		{"accepted", http.StatusOK, false, false},
+		{"accepted without content", http.StatusNoContent, false, false},
+		{"bad request", http.StatusBadRequest, true, false},
+		{"not found", http.StatusNotFound, true, false},
+		{"rate limited", http.StatusTooManyRequests, true, true},
+		{"server error", http.StatusInternalServerError, true, true},
+		{"unavailable", http.StatusServiceUnavailable, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ := io.ReadAll(r.Body)
+				timestamp := r.Header.Get(CallbackTimestampHeader)
+				if signature := r.Header.Get(CallbackSignatureHeader); signature != SignCallback("secret", timestamp, received) {
+					t.Errorf("signature %q does not match the body and timestamp %q", signature, timestamp)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			h := NewLauncherHandler(store.NewMemoryTaskStore())
+			defer h.Close()
+
+			retry, err := h.postCallback(store.TaskCallback{URL: server.URL, Secret: "secret"}, body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("postCallback error = %v, want error %v", err, tt.wantErr)
+			}
+			if retry != tt.wantRetry {
+				t.Fatalf("postCallback retry = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		h := NewLauncherHandler(store.NewMemoryTaskStore())
+		defer h.Close()
+
+		retry, err := h.postCallback(store.TaskCallback{URL: server.URL}, body)
+		if err == nil || !retry {
+			t.Fatalf("postCallback = %v, %v; want a retryable error", retry, err)
+		}
+	})
+}
+
+// createCallbackTask stores a task that notifies url when it finishes.
+func createCallbackTask(t *testing.T, taskStore store.TaskStore, url string) string {
+	t.Helper()
+	agentID := utils.CreatePrimaryAgentID()
+	err := taskStore.CreateTask(store.TaskSpec{
+		AgentID:  agentID,
+		Task:     "work",
+		Callback: &store.TaskCallback{URL: url},
+	})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	return agentID
+}
+
+func callbackDelivery(t *testing.T, taskStore store.TaskStore, agentID string) store.CallbackDelivery {
+	t.Helper()
+	task, err := taskStore.GetTask(agentID)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	return task.Callback.CallbackDelivery
+}
+
+func TestDeliverCallback(t *testing.T) {
+	tests := []struct {
+		name string
+		// responses are the statuses of successive attempts.
+		responses    []int
+		wantStatus   string
+		wantAttempts int
+		wantError    string
+	}{
+		{"delivered", []int{http.StatusOK}, store.CallbackStatusDelivered, 1, ""},
+		{"final failure", []int{http.StatusBadRequest}, store.CallbackStatusFailed, 1, "callback returned 400 Bad Request"},
+		{"delivered after a retry", []int{http.StatusServiceUnavailable, http.StatusOK}, store.CallbackStatusDelivered, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				w.WriteHeader(tt.responses[min(n, len(tt.responses))-1])
+			}))
+			defer server.Close()
+			taskStore := store.NewMemoryTaskStore()
+			h := NewLauncherHandler(taskStore)
+			defer h.Close()
+			agentID := createCallbackTask(t, taskStore, server.URL)
+
+			h.deliverCallback(agentID, store.TaskCallback{URL: server.URL}, []byte(`{}`))
+
+			delivery := callbackDelivery(t, taskStore, agentID)
+			if delivery.Status != tt.wantStatus || delivery.Attempts != tt.wantAttempts || delivery.LastError != tt.wantError {
+				t.Fatalf("delivery = %+v, want status %s after %d attempts with error %q", delivery, tt.wantStatus, tt.wantAttempts, tt.wantError)
+			}
+			if (delivery.DeliveredAt != nil) != (tt.wantStatus == store.CallbackStatusDelivered) {
+				t.Fatalf("delivered at %v with status %s", delivery.DeliveredAt, delivery.Status)
+			}
+			if int(attempts.Load()) != tt.wantAttempts {
+				t.Fatalf("receiver got %d attempts, want %d", attempts.Load(), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDeliverCallbackInterruptedByClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// respond answers the first attempt, which is still in flight at
+		// Close when it blocks.
+		respond func(w http.ResponseWriter, r *http.Request)
+	}{
+		{
+			name: "during an attempt",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				// The server only notices the client going away once the
+				// body has been read.
+				io.Copy(io.Discard, r.Body)
+				<-r.Context().Done()
+			},
+		},
+		{
+			name: "while waiting to retry",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempted := make(chan struct{}, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempted <- struct{}{}
+				tt.respond(w, r)
+			}))
+			defer server.Close()
+			taskStore := store.NewMemoryTaskStore()
+			h := NewLauncherHandler(taskStore)
+			agentID := createCallbackTask(t, taskStore, server.URL)
+			task, err := taskStore.UpdateTask(agentID, store.TaskUpdate{Status: store.TaskStatusSucceeded})
+			if err != nil {
+				t.Fatalf("UpdateTask: %v", err)
+			}
+
+			h.NotifyCallback(task)
+			select {
+			case <-attempted:
+			case <-time.After(time.Second):
+				t.Fatal("callback was not posted")
+			}
+			// Let the failed attempt be recorded before closing.
+			time.Sleep(50 * time.Millisecond)
+			h.Close()
+
+			delivery := callbackDelivery(t, taskStore, agentID)
+			if delivery.Status != store.CallbackStatusFailed || delivery.Attempts != 1 {
+				t.Fatalf("delivery = %+v, want failed after 1 attempt", delivery)
+			}
+			if !strings.HasPrefix(delivery.LastError, "delivery interrupted by shutdown") {
+				t.Fatalf("last error %q does not report the shutdown", delivery.LastError)
+			}
+		})
+	}
+}
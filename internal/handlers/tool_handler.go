@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
 	"github.com/cugtyt/agentlauncher-distributed/internal/events"
@@ -16,12 +17,13 @@ type Tool struct {
 }
 
 type ToolHandler struct {
-	eventBus      *eventbus.DistributedEventBus
+	eventBus      eventbus.EventBus
 	tools         map[string]Tool
+	mu            sync.Mutex
 	agentChannels map[string]chan string
 }
 
-func NewToolHandler(eb *eventbus.DistributedEventBus) *ToolHandler {
+func NewToolHandler(eb eventbus.EventBus) *ToolHandler {
 	return &ToolHandler{
 		eventBus:      eb,
 		tools:         make(map[string]Tool),
@@ -38,6 +40,25 @@ func (th *ToolHandler) Register(tool Tool) error {
 	return nil
 }
 
+// RequireApproval marks registered tools so that agents wait for a person to
+// approve their calls. Unknown names are reported and skipped.
+func (th *ToolHandler) RequireApproval(names []string) error {
+	var missing []string
+	for _, name := range names {
+		tool, exists := th.tools[name]
+		if !exists {
+			missing = append(missing, name)
+			continue
+		}
+		tool.RequiresApproval = true
+		th.tools[name] = tool
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tools %v not found", missing)
+	}
+	return nil
+}
+
 func (th *ToolHandler) GetTool(name string) (Tool, error) {
 	tool, exists := th.tools[name]
 	if !exists {
@@ -64,6 +85,18 @@ func (th *ToolHandler) GetAllToolSchemas() []llminterface.ToolSchema {
 }
 
 func (th *ToolHandler) HandleToolExecution(ctx context.Context, event events.ToolsExecRequestEvent) {
+	// A create_agent call waits for its sub-agent, whose own tool calls arrive
+	// through this same subscription, so such requests run in the background.
+	for _, toolCall := range event.ToolCalls {
+		if toolCall.ToolName == CreateAgentToolName {
+			go th.executeTools(ctx, event)
+			return
+		}
+	}
+	th.executeTools(ctx, event)
+}
+
+func (th *ToolHandler) executeTools(ctx context.Context, event events.ToolsExecRequestEvent) {
 	log.Printf("[%s] Executing %d tools", event.AgentID, len(event.ToolCalls))
 
 	results := make([]events.ToolResult, 0, len(event.ToolCalls))
@@ -162,12 +195,22 @@ func (th *ToolHandler) executeTool(ctx context.Context, agentID string, toolCall
 }
 
 func (th *ToolHandler) CreateAgentChannel(agentID string) chan string {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
 	resultChan := make(chan string, 1)
 	th.agentChannels[agentID] = resultChan
 	return resultChan
 }
 
 func (th *ToolHandler) RemoveAgentChannel(agentID string) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	th.removeAgentChannel(agentID)
+}
+
+func (th *ToolHandler) removeAgentChannel(agentID string) {
 	if ch, exists := th.agentChannels[agentID]; exists {
 		close(ch)
 		delete(th.agentChannels, agentID)
@@ -175,11 +218,25 @@ func (th *ToolHandler) RemoveAgentChannel(agentID string) {
 }
 
 func (th *ToolHandler) HandleAgentFinish(agentID string, result string) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
 	if ch, exists := th.agentChannels[agentID]; exists {
 		select {
 		case ch <- result:
 		default:
 		}
-		th.RemoveAgentChannel(agentID)
+		th.removeAgentChannel(agentID)
 	}
 }
+
+// HandleSubAgentFinish passes a sub-agent's result to the create_agent call
+// waiting for it. Every replica sees the event; only the one running the call
+// has a channel for the agent.
+func (th *ToolHandler) HandleSubAgentFinish(ctx context.Context, event events.AgentFinishEvent) {
+	th.HandleAgentFinish(event.AgentID, event.Result)
+}
+
+func (th *ToolHandler) HandleSubAgentError(ctx context.Context, event events.AgentErrorEvent) {
+	th.HandleAgentFinish(event.AgentID, fmt.Sprintf("Error: Sub-agent failed: %s", event.Error))
+}
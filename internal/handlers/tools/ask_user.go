@@ -0,0 +1,31 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+// NewAskUserTool only provides the schema: the agent runtime holds ask_user
+// calls until the client answers them, so the tool runtime never runs it.
+func NewAskUserTool() handlers.Tool {
+	return handlers.Tool{
+		ToolSchema: llminterface.ToolSchema{
+			Name:        handlers.AskUserToolName,
+			Description: "Ask the user a clarifying question and wait for their answer",
+			Parameters: []llminterface.ToolParamSchema{
+				{
+					Type:        "string",
+					Name:        "question",
+					Description: "The question to ask the user",
+					Required:    true,
+				},
+			},
+		},
+		Function: func(ctx context.Context, params map[string]any) (string, error) {
+			return "", fmt.Errorf("%s is answered by the user and cannot be executed", handlers.AskUserToolName)
+		},
+	}
+}
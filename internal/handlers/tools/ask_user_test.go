@@ -0,0 +1,25 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+)
+
+func TestAskUserTool(t *testing.T) {
+	tool := NewAskUserTool()
+
+	if tool.ToolSchema.Name != handlers.AskUserToolName {
+		t.Fatalf("name = %s, want %s", tool.ToolSchema.Name, handlers.AskUserToolName)
+	}
+	if len(tool.ToolSchema.Parameters) != 1 || tool.ToolSchema.Parameters[0].Name != "question" || !tool.ToolSchema.Parameters[0].Required {
+		t.Fatalf("parameters = %+v, want a required question", tool.ToolSchema.Parameters)
+	}
+
+	// The agent runtime answers ask_user calls itself, so one reaching the
+	// tool runtime is an error rather than an empty answer.
+	if _, err := tool.Function(context.Background(), map[string]any{"question": "Which file?"}); err == nil {
+		t.Fatal("expected running ask_user to fail")
+	}
+}
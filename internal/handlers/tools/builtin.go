@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+func NewCalculatorTool() handlers.Tool {
+	return handlers.Tool{
+		ToolSchema: llminterface.ToolSchema{
+			Name:        "calculator",
+			Description: "Perform basic arithmetic operations",
+			Parameters: []llminterface.ToolParamSchema{
+				{
+					Type:        "string",
+					Name:        "operation",
+					Description: "add, subtract, multiply, divide",
+					Required:    true,
+				},
+				{
+					Type:        "number",
+					Name:        "a",
+					Description: "First number",
+					Required:    true,
+				},
+				{
+					Type:        "number",
+					Name:        "b",
+					Description: "Second number",
+					Required:    true,
+				},
+			},
+		},
+		Function: func(ctx context.Context, params map[string]any) (string, error) {
+			operation := params["operation"].(string)
+			a := params["a"].(float64)
+			b := params["b"].(float64)
+
+			switch operation {
+			case "add":
+				return fmt.Sprintf("%.2f", a+b), nil
+			case "subtract":
+				return fmt.Sprintf("%.2f", a-b), nil
+			case "multiply":
+				return fmt.Sprintf("%.2f", a*b), nil
+			case "divide":
+				if b == 0 {
+					return "", fmt.Errorf("division by zero")
+				}
+				return fmt.Sprintf("%.2f", a/b), nil
+			default:
+				return "", fmt.Errorf("unknown operation: %s", operation)
+			}
+		},
+	}
+}
+
+func NewWeatherTool() handlers.Tool {
+	return handlers.Tool{
+		ToolSchema: llminterface.ToolSchema{
+			Name:        "weather",
+			Description: "Get weather information for a city",
+			Parameters: []llminterface.ToolParamSchema{
+				{
+					Type:        "string",
+					Name:        "city",
+					Description: "City name",
+					Required:    true,
+				},
+			},
+		},
+		Function: func(ctx context.Context, params map[string]any) (string, error) {
+			city := params["city"].(string)
+			return fmt.Sprintf("Weather in %s: Sunny, 25°C", city), nil
+		},
+	}
+}
+
+func NewCurrentTimeTool() handlers.Tool {
+	return handlers.Tool{
+		ToolSchema: llminterface.ToolSchema{
+			Name:        "current_time",
+			Description: "Get current time",
+			Parameters:  []llminterface.ToolParamSchema{},
+		},
+		Function: func(ctx context.Context, params map[string]any) (string, error) {
+			return time.Now().Format("2006-01-02 15:04:05"), nil
+		},
+	}
+}
+
+func NewRandomNumberTool() handlers.Tool {
+	return handlers.Tool{
+		ToolSchema: llminterface.ToolSchema{
+			Name:        "random_number",
+			Description: "Generate a random number between min and max",
+			Parameters: []llminterface.ToolParamSchema{
+				{
+					Type:        "number",
+					Name:        "min",
+					Description: "Minimum value",
+					Required:    true,
+				},
+				{
+					Type:        "number",
+					Name:        "max",
+					Description: "Maximum value",
+					Required:    true,
+				},
+			},
+		},
+		Function: func(ctx context.Context, params map[string]any) (string, error) {
+			min := int(params["min"].(float64))
+			max := int(params["max"].(float64))
+			result := min + (time.Now().Nanosecond() % (max - min + 1))
+			return fmt.Sprintf("%d", result), nil
+		},
+	}
+}
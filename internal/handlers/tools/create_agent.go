@@ -13,10 +13,10 @@ import (
 	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
 )
 
-func NewCreateAgentTool(eventBus *eventbus.DistributedEventBus, toolHandler *handlers.ToolHandler) handlers.Tool {
+func NewCreateAgentTool(eventBus eventbus.EventBus, toolHandler *handlers.ToolHandler) handlers.Tool {
 	return handlers.Tool{
 		ToolSchema: llminterface.ToolSchema{
-			Name:        "create_agent",
+			Name:        handlers.CreateAgentToolName,
 			Description: "Create a sub-agent to handle a specific task",
 			Parameters: []llminterface.ToolParamSchema{
 				{
@@ -40,12 +40,16 @@ func NewCreateAgentTool(eventBus *eventbus.DistributedEventBus, toolHandler *han
 				return "", fmt.Errorf("primary agent ID not found in context")
 			}
 
+			if !utils.IsPrimaryAgent(primaryAgentID) {
+				return "", fmt.Errorf("sub-agents cannot create agents")
+			}
+
 			task, ok := params["task"].(string)
 			if !ok || task == "" {
 				return "", fmt.Errorf("task is required")
 			}
 
-			toolsArray, ok := params["tools"].([]string)
+			toolsArray, ok := stringList(params["tools"])
 			if !ok {
 				return "", fmt.Errorf("tools must be an array of strings")
 			}
@@ -98,3 +102,23 @@ func NewCreateAgentTool(eventBus *eventbus.DistributedEventBus, toolHandler *han
 		},
 	}
 }
+
+// stringList accepts the tool names both as decoded from JSON and as built in
+// Go.
+func stringList(value any) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, s)
+		}
+		return list, true
+	}
+	return nil, false
+}
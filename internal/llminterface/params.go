@@ -0,0 +1,16 @@
+package llminterface
+
+// ModelParams tunes the completion requests of one agent. Unset fields leave
+// the LLM runtime's defaults in place.
+type ModelParams struct {
+	Model       string   `json:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+}
+
+// AgentLimits caps the work one agent may do before it is stopped with an
+// error. Zero means unlimited.
+type AgentLimits struct {
+	MaxLLMCalls  int `json:"max_llm_calls,omitempty"`
+	MaxToolCalls int `json:"max_tool_calls,omitempty"`
+}
@@ -8,10 +8,13 @@ type ToolParamSchema struct {
 	Items       map[string]any `json:"items,omitempty"`
 }
 
+// ToolSchema describes a tool to the model. RequiresApproval is never sent to
+// the model; it makes the agent wait for a person to approve each call.
 type ToolSchema struct {
-	Name        string            `json:"name"`
-	Description string            `json:"description"`
-	Parameters  []ToolParamSchema `json:"parameters"`
+	Name             string            `json:"name"`
+	Description      string            `json:"description"`
+	Parameters       []ToolParamSchema `json:"parameters"`
+	RequiresApproval bool              `json:"requires_approval,omitempty"`
 }
 
 type RequestToolList []ToolSchema
@@ -0,0 +1,624 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+const StatusNotFound = "not_found"
+
+var (
+	errToolSchemas           = errors.New("failed to get tool schemas")
+	errInvalidCallback       = errors.New("invalid callback")
+	errInvalidSettings       = errors.New("invalid agent settings")
+	errUnknownProfile        = errors.New("unknown profile")
+	errProfilesNotConfigured = errors.New("profiles are not configured")
+	errInvalidTemplate       = errors.New("invalid template")
+)
+
+type ToolSchemaProvider interface {
+	GetToolSchemas(toolNames []string) ([]llminterface.ToolSchema, error)
+	HealthCheck() error
+}
+
+type AgentLauncher struct {
+	eventBus          eventbus.EventBus
+	handler           *handlers.LauncherHandler
+	taskStore         store.TaskStore
+	archiveStore      store.ArchiveStore
+	agentStore        store.AgentStore
+	scheduleStore     store.ScheduleStore
+	profileStore      store.ProfileStore
+	tools             ToolSchemaProvider
+	taskTimeout       time.Duration
+	sessionTTL        time.Duration
+	idempotencyKeyTTL time.Duration
+	batchConcurrency  int
+	waiters           *taskWaiters
+	stop              chan struct{}
+}
+
+// CreateTaskRequest starts a task. RequireApproval names tools whose calls
+// need approval in this task on top of those the tool runtime marks.
+// CallbackURL is posted the outcome once the task finishes, signed with
+// CallbackSecret when one is given. Profile names an agent profile, at its
+// latest version unless ProfileVersion pins one; any settings the request
+// gives itself override the profile's. When a profile or Variables is given,
+// the system prompt and task are text/template templates over Variables and
+// the built-in Date, Time, Tools and Owner.
+type CreateTaskRequest struct {
+	Task            string                   `json:"task"`
+	SystemPrompt    string                   `json:"system_prompt,omitempty"`
+	Conversation    []llminterface.Message   `json:"conversation,omitempty"`
+	Tools           []string                 `json:"tools,omitempty"`
+	Owner           string                   `json:"owner,omitempty"`
+	RequireApproval []string                 `json:"require_approval,omitempty"`
+	CallbackURL     string                   `json:"callback_url,omitempty"`
+	CallbackSecret  string                   `json:"callback_secret,omitempty"`
+	Profile         string                   `json:"profile,omitempty"`
+	ProfileVersion  int                      `json:"profile_version,omitempty"`
+	Limits          llminterface.AgentLimits `json:"limits,omitzero"`
+	ModelParams     llminterface.ModelParams `json:"model_params,omitzero"`
+	Variables       map[string]any           `json:"variables,omitempty"`
+	batchID         string
+}
+
+type CreateTaskResponse struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+}
+
+type GetResultResponse struct {
+	AgentID    string                  `json:"agent_id"`
+	Status     string                  `json:"status"`
+	Result     string                  `json:"result,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+	Message    string                  `json:"message,omitempty"`
+	Questions  []Question              `json:"questions,omitempty"`
+	Callback   *store.CallbackDelivery `json:"callback,omitempty"`
+	Profile    *store.ProfileRef       `json:"profile,omitempty"`
+	CreatedAt  *time.Time              `json:"created_at,omitempty"`
+	StartedAt  *time.Time              `json:"started_at,omitempty"`
+	FinishedAt *time.Time              `json:"finished_at,omitempty"`
+}
+
+func NewAgentLauncher(eventBus eventbus.EventBus, taskStore store.TaskStore, archiveStore store.ArchiveStore, tools ToolSchemaProvider) *AgentLauncher {
+	al := &AgentLauncher{
+		eventBus:          eventBus,
+		handler:           handlers.NewLauncherHandler(taskStore),
+		taskStore:         taskStore,
+		archiveStore:      archiveStore,
+		tools:             tools,
+		sessionTTL:        DefaultSessionTTL,
+		idempotencyKeyTTL: DefaultIdempotencyKeyTTL,
+		batchConcurrency:  DefaultBatchConcurrency,
+		waiters:           newTaskWaiters(),
+		stop:              make(chan struct{}),
+	}
+	al.handler.OnTaskFinished(al.batchTaskFinished)
+	return al
+}
+
+// SetTaskTimeout makes the launcher time out tasks that have not finished
+// within timeout of being created. Zero disables the timeout.
+func (al *AgentLauncher) SetTaskTimeout(timeout time.Duration) *AgentLauncher {
+	al.taskTimeout = timeout
+	return al
+}
+
+func (al *AgentLauncher) Start() error {
+	err := eventbus.Subscribe(al.eventBus, events.AgentStartEventName, AgentLauncherQueueName, al.handler.HandleAgentStart)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(al.eventBus, events.ToolExecRequestEventName, AgentLauncherQueueName, al.handler.HandleToolsExecRequest)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(al.eventBus, events.ToolApprovalRequestEventName, AgentLauncherQueueName, al.handler.HandleToolApprovalRequest)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(al.eventBus, events.UserInputRequestEventName, AgentLauncherQueueName, al.handler.HandleUserInputRequest)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(al.eventBus, events.ToolExecResultsEventName, AgentLauncherQueueName, al.handler.HandleToolsExecResults)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(al.eventBus, events.TaskFinishEventName, AgentLauncherQueueName, al.handler.HandleTaskFinish)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(al.eventBus, events.TaskErrorEventName, AgentLauncherQueueName, al.handler.HandleTaskError)
+	if err != nil {
+		return err
+	}
+
+	al.observeTaskOutcomes()
+
+	go al.sweepTasks()
+	if al.agentStore != nil {
+		go al.sweepExpiredSessions()
+	}
+	if al.scheduleStore != nil {
+		go al.runSchedules()
+	}
+	return nil
+}
+
+func (al *AgentLauncher) Stop() {
+	close(al.stop)
+	al.handler.Close()
+}
+
+func (al *AgentLauncher) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/tasks", al.tasksHandler)
+	mux.HandleFunc("/tasks/cancel", al.cancelTaskHandler)
+	mux.HandleFunc("POST /tasks/batch", al.createBatchHandler)
+	mux.HandleFunc("GET /batches/{batch_id}", al.getBatchHandler)
+	mux.HandleFunc("GET /tasks/{agent_id}/conversation", al.getConversationHandler)
+	mux.HandleFunc("GET /tasks/{agent_id}/approvals", al.getApprovalsHandler)
+	mux.HandleFunc("POST /tasks/{agent_id}/approvals", al.decideApprovalsHandler)
+	mux.HandleFunc("GET /tasks/{agent_id}/questions", al.getQuestionsHandler)
+	mux.HandleFunc("POST /tasks/{agent_id}/answers", al.answerQuestionHandler)
+	mux.HandleFunc("POST /sessions", al.createSessionHandler)
+	mux.HandleFunc("POST /sessions/{agent_id}/messages", al.sessionMessageHandler)
+	mux.HandleFunc("DELETE /sessions/{agent_id}", al.closeSessionHandler)
+	mux.HandleFunc("/schedules", al.schedulesHandler)
+	mux.HandleFunc("/schedules/{schedule_id}", al.scheduleHandler)
+	mux.HandleFunc("GET /schedules/{schedule_id}/runs", al.scheduleRunsHandler)
+	mux.HandleFunc("/profiles", al.profilesHandler)
+	mux.HandleFunc("/profiles/{name}", al.profileHandler)
+	mux.HandleFunc("GET /profiles/{name}/versions", al.profileVersionsHandler)
+	mux.HandleFunc("GET /profiles/{name}/versions/{version}", al.profileVersionHandler)
+	mux.HandleFunc("/results", al.getResultHandler)
+	mux.HandleFunc("/archive", al.getArchiveHandler)
+	mux.HandleFunc("/health", al.healthHandler)
+}
+
+func (al *AgentLauncher) tasksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		al.listTasksHandler(w, r)
+	default:
+		al.createTaskHandler(w, r)
+	}
+}
+
+func (al *AgentLauncher) listTasksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.TaskFilter{
+		Status: query.Get("status"),
+		Owner:  query.Get("owner"),
+		Query:  query.Get("q"),
+		Cursor: query.Get("cursor"),
+	}
+
+	for param, target := range map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		if v := query.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s must be an RFC 3339 timestamp", param), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	page, err := al.taskStore.ListTasks(filter)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list tasks: %v", err)
+		http.Error(w, "Failed to list tasks", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range page.Tasks {
+		page.Tasks[i] = page.Tasks[i].Redacted()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (al *AgentLauncher) createTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wait, err := parseTaskWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > MaxIdempotencyKeyLength {
+		http.Error(w, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, MaxIdempotencyKeyLength), http.StatusBadRequest)
+		return
+	}
+
+	var req CreateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	agentID := utils.CreatePrimaryAgentID()
+	if idempotencyKey != "" {
+		record, err := al.reserveIdempotencyKey(idempotencyKey, req, agentID)
+		if err != nil {
+			log.Printf("[%s] Failed to reserve idempotency key: %v", agentID, err)
+			http.Error(w, "Failed to create task", http.StatusInternalServerError)
+			return
+		}
+		if record != nil {
+			al.replayTask(w, r, record, req, wait)
+			return
+		}
+	}
+
+	if err := al.launchTask(agentID, req, nil); err != nil {
+		if idempotencyKey != "" {
+			if err := al.taskStore.ReleaseIdempotencyKey(idempotencyKey); err != nil {
+				log.Printf("[%s] Failed to release idempotency key: %v", agentID, err)
+			}
+		}
+		writeLaunchError(w, err)
+		return
+	}
+
+	if wait > 0 {
+		al.writeTaskOutcome(w, r, agentID, wait)
+		return
+	}
+
+	response := CreateTaskResponse{
+		AgentID: agentID,
+		Status:  store.TaskStatusQueued,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// launchTask records a new task and hands it to the agent runtime. A non-nil
+// sessionExpiresAt keeps the agent around for follow-up messages.
+func (al *AgentLauncher) launchTask(agentID string, req CreateTaskRequest, sessionExpiresAt *time.Time) error {
+	callback, err := taskCallback(req)
+	if err != nil {
+		return err
+	}
+	profile, err := al.applyProfile(&req)
+	if err != nil {
+		return err
+	}
+	if err := validateAgentSettings(req.Limits, req.ModelParams); err != nil {
+		return err
+	}
+
+	toolSchemas, err := al.tools.GetToolSchemas(req.Tools)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errToolSchemas, err)
+	}
+	toolNames := make([]string, len(toolSchemas))
+	for i := range toolSchemas {
+		toolNames[i] = toolSchemas[i].Name
+		if slices.Contains(req.RequireApproval, toolSchemas[i].Name) {
+			toolSchemas[i].RequiresApproval = true
+		}
+	}
+	if err := renderPrompts(&req, toolNames, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	taskSpec := store.TaskSpec{
+		AgentID:  agentID,
+		Task:     req.Task,
+		Owner:    req.Owner,
+		BatchID:  req.batchID,
+		Profile:  profile,
+		Callback: callback,
+	}
+	if err := al.taskStore.CreateTask(taskSpec); err != nil {
+		return fmt.Errorf("failed to create task in store: %w", err)
+	}
+
+	taskEvent := events.TaskCreateEvent{
+		AgentID:          agentID,
+		Task:             req.Task,
+		SystemPrompt:     req.SystemPrompt,
+		ToolSchemas:      toolSchemas,
+		Conversation:     req.Conversation,
+		SessionExpiresAt: sessionExpiresAt,
+		Limits:           req.Limits,
+		ModelParams:      req.ModelParams,
+	}
+
+	if err := al.eventBus.Emit(taskEvent); err != nil {
+		al.taskStore.DeleteTask(agentID)
+		return fmt.Errorf("failed to emit task event: %w", err)
+	}
+
+	return nil
+}
+
+func taskCallback(req CreateTaskRequest) (*store.TaskCallback, error) {
+	if req.CallbackURL == "" {
+		if req.CallbackSecret != "" {
+			return nil, fmt.Errorf("%w: callback_secret requires callback_url", errInvalidCallback)
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(req.CallbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: callback_url must be an absolute http or https URL", errInvalidCallback)
+	}
+	return &store.TaskCallback{
+		URL:    req.CallbackURL,
+		Secret: req.CallbackSecret,
+	}, nil
+}
+
+// writeTaskOutcome holds the request until the task finishes. A task still
+// running at the timeout is reported with 202 so the caller can poll /results.
+func (al *AgentLauncher) writeTaskOutcome(w http.ResponseWriter, r *http.Request, agentID string, wait time.Duration) {
+	task, finished := al.waitForTask(r.Context(), agentID, wait)
+	if r.Context().Err() != nil {
+		return
+	}
+	if task == nil {
+		task = &store.TaskData{AgentID: agentID, Status: store.TaskStatusQueued}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !finished {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(al.resultResponse(task))
+}
+
+func writeLaunchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidCallback) || errors.Is(err, errInvalidSettings) ||
+		errors.Is(err, errUnknownProfile) || errors.Is(err, errInvalidTemplate) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errProfilesNotConfigured) {
+		http.Error(w, "Profiles are not configured", http.StatusNotImplemented)
+		return
+	}
+	log.Printf("Failed to launch task: %v", err)
+	if errors.Is(err, errToolSchemas) {
+		http.Error(w, "Failed to get tool schemas", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "Failed to create task", http.StatusInternalServerError)
+}
+
+func (al *AgentLauncher) getResultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		http.Error(w, "agent_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	// An unknown task is reported as failed with 200, as /results always has,
+	// so existing clients see it as a failed task rather than an error.
+	task, err := al.lookupTask(agentID)
+	if err != nil {
+		response := GetResultResponse{
+			AgentID: agentID,
+			Status:  store.TaskStatusFailed,
+			Message: "Task not found",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(al.resultResponse(task))
+}
+
+// lookupTask gets the task from the task store, falling back to the archive
+// for tasks the store no longer holds.
+func (al *AgentLauncher) lookupTask(agentID string) (*store.TaskData, error) {
+	task, err := al.taskStore.GetTask(agentID)
+	if !errors.Is(err, store.ErrNotFound) || al.archiveStore == nil {
+		return task, err
+	}
+
+	archived, archiveErr := al.archiveStore.GetArchivedTask(agentID)
+	if archiveErr != nil {
+		return nil, err
+	}
+	return &store.TaskData{
+		AgentID:    archived.AgentID,
+		Task:       archived.Task,
+		Status:     archived.Status,
+		Result:     archived.Result,
+		Error:      archived.Error,
+		CreatedAt:  archived.CreatedAt,
+		StartedAt:  &archived.CreatedAt,
+		FinishedAt: &archived.FinishedAt,
+	}, nil
+}
+
+func (al *AgentLauncher) resultResponse(task *store.TaskData) GetResultResponse {
+	response := GetResultResponse{
+		AgentID:    task.AgentID,
+		Status:     task.Status,
+		Result:     task.Result,
+		Error:      task.Error,
+		CreatedAt:  &task.CreatedAt,
+		StartedAt:  task.StartedAt,
+		FinishedAt: task.FinishedAt,
+		Profile:    task.Profile,
+	}
+	if task.Callback != nil {
+		response.Callback = &task.Callback.CallbackDelivery
+	}
+	if !store.IsTerminalTaskStatus(task.Status) {
+		response.Message = "Task still in progress"
+	}
+	if task.Status == store.TaskStatusWaitingForInput {
+		response.Message = "Task is waiting for an answer"
+		response.Questions = al.pendingQuestions(task.AgentID)
+	}
+	return response
+}
+
+func (al *AgentLauncher) cancelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		http.Error(w, "agent_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := al.stopTask(agentID, store.TaskStatusCancelled, "cancelled by request")
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrTaskFinished):
+		http.Error(w, fmt.Sprintf("Task already %s", task.Status), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to cancel task %s: %v", agentID, err)
+		http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task.Redacted())
+}
+
+// stopTask moves an active task to a cancelled or timed out state, tells the
+// agent runtime to stop its primary agent and notifies the task's callback.
+func (al *AgentLauncher) stopTask(agentID, status, reason string) (*store.TaskData, error) {
+	task, err := al.taskStore.UpdateTask(agentID, store.TaskUpdate{
+		Status: status,
+		Error:  reason,
+	})
+	if err != nil {
+		return task, err
+	}
+
+	cancelEvent := events.TaskCancelEvent{
+		AgentID: agentID,
+		Status:  status,
+		Reason:  reason,
+	}
+	if err := al.eventBus.Emit(cancelEvent); err != nil {
+		log.Printf("[%s] Failed to emit task cancel event: %v", agentID, err)
+	}
+	al.handler.TaskFinished(task)
+	return task, nil
+}
+
+func (al *AgentLauncher) getArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		http.Error(w, "agent_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	if al.archiveStore == nil {
+		http.Error(w, "Task archive is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	archived, err := al.archiveStore.GetArchivedTask(agentID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Archived task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get archived task %s: %v", agentID, err)
+		http.Error(w, "Failed to get archived task", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archived)
+}
+
+func (al *AgentLauncher) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := al.tools.HealthCheck(); err != nil {
+		http.Error(w, "Tool runtime not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := al.taskStore.HealthCheck(); err != nil {
+		http.Error(w, "Task store not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !al.eventBus.IsConnected() {
+		http.Error(w, "NATS not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,100 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+func TestToolsExecRequestStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		toolNames []string
+		want      string
+	}{
+		{"tool call", []string{"calculator"}, store.TaskStatusWaitingForTool},
+		{"create_agent call", []string{handlers.CreateAgentToolName}, store.TaskStatusWaitingForSubagent},
+		{"create_agent among other calls", []string{"calculator", handlers.CreateAgentToolName}, store.TaskStatusWaitingForSubagent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := eventbus.NewMemoryEventBus()
+			defer bus.Close()
+			taskStore := store.NewMemoryTaskStore()
+			launcher := NewAgentLauncher(bus, taskStore, store.NewMemoryArchiveStore(), nil)
+			if err := launcher.Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer launcher.Stop()
+
+			agentID := utils.CreatePrimaryAgentID()
+			if err := taskStore.CreateTask(store.TaskSpec{AgentID: agentID, Task: "work"}); err != nil {
+				t.Fatalf("CreateTask: %v", err)
+			}
+			if _, err := taskStore.UpdateTask(agentID, store.TaskUpdate{Status: store.TaskStatusRunning}); err != nil {
+				t.Fatalf("UpdateTask: %v", err)
+			}
+
+			var toolCalls []events.ToolCall
+			for _, name := range tt.toolNames {
+				toolCalls = append(toolCalls, events.ToolCall{AgentID: agentID, ToolCallID: "call-" + name, ToolName: name})
+			}
+			if err := bus.Emit(events.ToolsExecRequestEvent{AgentID: agentID, ToolCalls: toolCalls}); err != nil {
+				t.Fatalf("Emit: %v", err)
+			}
+
+			waitForTaskStatus(t, taskStore, agentID, tt.want)
+		})
+	}
+}
+
+// waitForTaskStatus waits for the launcher to move the task to want.
+func waitForTaskStatus(t *testing.T, taskStore store.TaskStore, agentID, want string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		task, err := taskStore.GetTask(agentID)
+		if err != nil {
+			t.Fatalf("GetTask: %v", err)
+		}
+		if task.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %s, want %s", task.Status, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetResultUnknownTask(t *testing.T) {
+	bus := eventbus.NewMemoryEventBus()
+	defer bus.Close()
+	launcher := NewAgentLauncher(bus, store.NewMemoryTaskStore(), store.NewMemoryArchiveStore(), nil)
+	defer launcher.Stop()
+	mux := http.NewServeMux()
+	launcher.RegisterRoutes(mux)
+
+	agentID := utils.CreatePrimaryAgentID()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/results?agent_id="+agentID, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var response GetResultResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if response.AgentID != agentID || response.Status != store.TaskStatusFailed {
+		t.Fatalf("response = %+v, want a failed result for %s", response, agentID)
+	}
+}
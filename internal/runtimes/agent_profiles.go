@@ -0,0 +1,328 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ProfileRequest creates a profile or, on PUT, replaces it with a new
+// version. Name is only read on create; PUT takes it from the path.
+type ProfileRequest struct {
+	Name            string                   `json:"name,omitempty"`
+	Description     string                   `json:"description,omitempty"`
+	SystemPrompt    string                   `json:"system_prompt,omitempty"`
+	Tools           []string                 `json:"tools,omitempty"`
+	RequireApproval []string                 `json:"require_approval,omitempty"`
+	Limits          llminterface.AgentLimits `json:"limits,omitzero"`
+	ModelParams     llminterface.ModelParams `json:"model_params,omitzero"`
+}
+
+type ProfilesResponse struct {
+	Profiles []store.ProfileData `json:"profiles"`
+}
+
+type ProfileVersionsResponse struct {
+	Name     string              `json:"name"`
+	Versions []store.ProfileData `json:"versions"`
+}
+
+// SetProfileStore enables agent profiles, which tasks can name instead of
+// repeating their system prompt, tools and settings.
+func (al *AgentLauncher) SetProfileStore(profileStore store.ProfileStore) *AgentLauncher {
+	al.profileStore = profileStore
+	return al
+}
+
+// applyProfile fills in the settings the request leaves unset from the
+// profile it names, and returns the version used. Requests without a profile
+// are left alone.
+func (al *AgentLauncher) applyProfile(req *CreateTaskRequest) (*store.ProfileRef, error) {
+	if req.Profile == "" {
+		if req.ProfileVersion != 0 {
+			return nil, fmt.Errorf("%w: profile_version requires profile", errInvalidSettings)
+		}
+		return nil, nil
+	}
+	if req.ProfileVersion < 0 {
+		return nil, fmt.Errorf("%w: profile_version must be a positive integer", errInvalidSettings)
+	}
+	if al.profileStore == nil {
+		return nil, errProfilesNotConfigured
+	}
+
+	profile, err := al.profileStore.GetProfile(req.Profile, req.ProfileVersion)
+	if errors.Is(err, store.ErrNotFound) {
+		if req.ProfileVersion > 0 {
+			return nil, fmt.Errorf("%w: %q has no version %d", errUnknownProfile, req.Profile, req.ProfileVersion)
+		}
+		return nil, fmt.Errorf("%w: %q", errUnknownProfile, req.Profile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	if req.SystemPrompt == "" {
+		req.SystemPrompt = profile.SystemPrompt
+	}
+	if req.Tools == nil {
+		req.Tools = profile.Tools
+	}
+	if req.RequireApproval == nil {
+		req.RequireApproval = profile.RequireApproval
+	}
+	if req.Limits.MaxLLMCalls == 0 {
+		req.Limits.MaxLLMCalls = profile.Limits.MaxLLMCalls
+	}
+	if req.Limits.MaxToolCalls == 0 {
+		req.Limits.MaxToolCalls = profile.Limits.MaxToolCalls
+	}
+	if req.ModelParams.Model == "" {
+		req.ModelParams.Model = profile.ModelParams.Model
+	}
+	if req.ModelParams.Temperature == nil {
+		req.ModelParams.Temperature = profile.ModelParams.Temperature
+	}
+	if req.ModelParams.MaxTokens == 0 {
+		req.ModelParams.MaxTokens = profile.ModelParams.MaxTokens
+	}
+
+	return &store.ProfileRef{Name: profile.Name, Version: profile.Version}, nil
+}
+
+// validateTaskRequest checks what launchTask would reject, for requests that
+// are stored now and launched later.
+func (al *AgentLauncher) validateTaskRequest(req CreateTaskRequest) error {
+	if _, err := taskCallback(req); err != nil {
+		return err
+	}
+	if _, err := al.applyProfile(&req); err != nil {
+		return err
+	}
+	if err := validateAgentSettings(req.Limits, req.ModelParams); err != nil {
+		return err
+	}
+	// The tools are not looked up here, but that only changes what the
+	// templates render, not whether they can be.
+	return renderPrompts(&req, req.Tools, time.Now().UTC())
+}
+
+func validateAgentSettings(limits llminterface.AgentLimits, params llminterface.ModelParams) error {
+	if limits.MaxLLMCalls < 0 || limits.MaxToolCalls < 0 {
+		return fmt.Errorf("%w: limits must not be negative", errInvalidSettings)
+	}
+	if params.Temperature != nil && (*params.Temperature < 0 || *params.Temperature > 2) {
+		return fmt.Errorf("%w: temperature must be between 0 and 2", errInvalidSettings)
+	}
+	if params.MaxTokens < 0 {
+		return fmt.Errorf("%w: max_tokens must not be negative", errInvalidSettings)
+	}
+	return nil
+}
+
+func (al *AgentLauncher) profilesHandler(w http.ResponseWriter, r *http.Request) {
+	if al.profileStore == nil {
+		http.Error(w, "Profiles are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		al.listProfilesHandler(w, r)
+	case http.MethodPost:
+		al.createProfileHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (al *AgentLauncher) profileHandler(w http.ResponseWriter, r *http.Request) {
+	if al.profileStore == nil {
+		http.Error(w, "Profiles are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		al.writeProfile(w, r.PathValue("name"), 0)
+	case http.MethodPut:
+		al.updateProfileHandler(w, r)
+	case http.MethodDelete:
+		al.deleteProfileHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (al *AgentLauncher) listProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	profiles, err := al.profileStore.ListProfiles()
+	if err != nil {
+		log.Printf("Failed to list profiles: %v", err)
+		http.Error(w, "Failed to list profiles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProfilesResponse{Profiles: profiles})
+}
+
+func (al *AgentLauncher) createProfileHandler(w http.ResponseWriter, r *http.Request) {
+	profile, ok := decodeProfile(w, r)
+	if !ok {
+		return
+	}
+	if !profileNamePattern.MatchString(profile.Name) {
+		http.Error(w, "name must be 1-64 letters, digits, '.', '_' or '-'", http.StatusBadRequest)
+		return
+	}
+
+	err := al.profileStore.CreateProfile(profile)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		http.Error(w, "Profile already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create profile %s: %v", profile.Name, err)
+		http.Error(w, "Failed to create profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(profile)
+}
+
+// updateProfileHandler stores the request as the profile's next version.
+// Tasks already launched keep the version they were launched with.
+func (al *AgentLauncher) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	profile, ok := decodeProfile(w, r)
+	if !ok {
+		return
+	}
+	profile.Name = r.PathValue("name")
+
+	err := al.profileStore.UpdateProfile(profile)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update profile %s: %v", profile.Name, err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// deleteProfileHandler removes every version of the profile. Tasks keep their
+// reference to it, but new tasks can no longer name it.
+func (al *AgentLauncher) deleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	err := al.profileStore.DeleteProfile(name)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete profile %s: %v", name, err)
+		http.Error(w, "Failed to delete profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (al *AgentLauncher) profileVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if al.profileStore == nil {
+		http.Error(w, "Profiles are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	name := r.PathValue("name")
+	versions, err := al.profileStore.ListProfileVersions(name)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list versions of profile %s: %v", name, err)
+		http.Error(w, "Failed to list profile versions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProfileVersionsResponse{Name: name, Versions: versions})
+}
+
+func (al *AgentLauncher) profileVersionHandler(w http.ResponseWriter, r *http.Request) {
+	if al.profileStore == nil {
+		http.Error(w, "Profiles are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || version < 1 {
+		http.Error(w, "version must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	al.writeProfile(w, r.PathValue("name"), version)
+}
+
+func (al *AgentLauncher) writeProfile(w http.ResponseWriter, name string, version int) {
+	profile, err := al.profileStore.GetProfile(name, version)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get profile %s: %v", name, err)
+		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// decodeProfile reads a ProfileRequest, writing a 400 response when it is
+// invalid.
+func decodeProfile(w http.ResponseWriter, r *http.Request) (*store.ProfileData, bool) {
+	var req ProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := validateAgentSettings(req.Limits, req.ModelParams); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	// Variables come with each task, so only the syntax can be checked here.
+	if _, err := parsePrompt("system_prompt", req.SystemPrompt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &store.ProfileData{
+		Name:            req.Name,
+		Description:     req.Description,
+		SystemPrompt:    req.SystemPrompt,
+		Tools:           req.Tools,
+		RequireApproval: req.RequireApproval,
+		Limits:          req.Limits,
+		ModelParams:     req.ModelParams,
+		CreatedAt:       time.Now().UTC(),
+	}, true
+}
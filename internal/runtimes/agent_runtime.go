@@ -0,0 +1,81 @@
+package runtimes
+
+import (
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+type AgentRuntime struct {
+	eventBus eventbus.EventBus
+	handler  *handlers.AgentHandler
+}
+
+func NewAgentRuntime(eventBus eventbus.EventBus, agentStore store.AgentStore, archiveStore store.ArchiveStore) *AgentRuntime {
+	return &AgentRuntime{
+		eventBus: eventBus,
+		handler:  handlers.NewAgentHandler(eventBus, agentStore).SetArchiveStore(archiveStore),
+	}
+}
+
+func (ar *AgentRuntime) Start() error {
+	err := eventbus.Subscribe(ar.eventBus, events.TaskCreateEventName, AgentRuntimeQueueName, ar.handler.HandleTaskCreate)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.AgentCreateEventName, AgentRuntimeQueueName, ar.handler.HandleAgentCreate)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.AgentStartEventName, AgentRuntimeQueueName, ar.handler.HandleAgentStart)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.LLMResponseEventName, AgentRuntimeQueueName, ar.handler.HandleLLMResponse)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.ToolExecResultsEventName, AgentRuntimeQueueName, ar.handler.HandleToolResult)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.ToolCallsResolvedEventName, AgentRuntimeQueueName, ar.handler.HandleToolCallsResolved)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.AgentFinishEventName, AgentRuntimeQueueName, ar.handler.HandleAgentFinish)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.AgentErrorEventName, AgentRuntimeQueueName, ar.handler.HandleAgentError)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.TaskCancelEventName, AgentRuntimeQueueName, ar.handler.HandleTaskCancel)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.SessionMessageEventName, AgentRuntimeQueueName, ar.handler.HandleSessionMessage)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.SessionCloseEventName, AgentRuntimeQueueName, ar.handler.HandleSessionClose)
+	if err != nil {
+		return err
+	}
+
+	err = eventbus.Subscribe(ar.eventBus, events.AgentDeletedEventName, AgentRuntimeQueueName, ar.handler.HandleAgentDeleted)
+
+	return err
+}
@@ -1,5 +1,7 @@
 package runtimes
 
+import "github.com/cugtyt/agentlauncher-distributed/internal/events"
+
 const (
 	AgentRuntimeQueueName   = "agent-runtime"
 	LLMRuntimeQueueName     = "llm-runtime"
@@ -7,3 +9,40 @@ const (
 	MessageRuntimeQueueName = "message-runtime"
 	AgentLauncherQueueName  = "agent-launcher"
 )
+
+// QueueGroups lists the events each runtime's queue group consumes, as
+// subscribed in the runtimes' Start methods. Processes provision all of them
+// at startup so that interest retention keeps events published before the
+// runtime that consumes them is up.
+var QueueGroups = map[string][]string{
+	AgentRuntimeQueueName: {
+		events.TaskCreateEventName,
+		events.AgentCreateEventName,
+		events.AgentStartEventName,
+		events.LLMResponseEventName,
+		events.ToolExecResultsEventName,
+		events.ToolCallsResolvedEventName,
+		events.AgentFinishEventName,
+		events.AgentErrorEventName,
+		events.TaskCancelEventName,
+		events.SessionMessageEventName,
+		events.SessionCloseEventName,
+		events.AgentDeletedEventName,
+	},
+	LLMRuntimeQueueName: {
+		events.LLMRequestEventName,
+		events.LLMErrorEventName,
+	},
+	ToolRuntimeQueueName: {
+		events.ToolExecRequestEventName,
+	},
+	AgentLauncherQueueName: {
+		events.AgentStartEventName,
+		events.ToolExecRequestEventName,
+		events.ToolApprovalRequestEventName,
+		events.UserInputRequestEventName,
+		events.ToolExecResultsEventName,
+		events.TaskFinishEventName,
+		events.TaskErrorEventName,
+	},
+}
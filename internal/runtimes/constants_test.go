@@ -0,0 +1,53 @@
+package runtimes
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+// recordingEventBus records the queue-group subscriptions made through it.
+type recordingEventBus struct {
+	*eventbus.MemoryEventBus
+	queues map[string][]string
+}
+
+func (b *recordingEventBus) Subscribe(eventName, queue string, handler eventbus.MessageHandler) error {
+	b.queues[queue] = append(b.queues[queue], eventName)
+	return b.MemoryEventBus.Subscribe(eventName, queue, handler)
+}
+
+func TestQueueGroupsMatchRuntimeSubscriptions(t *testing.T) {
+	bus := &recordingEventBus{MemoryEventBus: eventbus.NewMemoryEventBus(), queues: map[string][]string{}}
+	defer bus.Close()
+
+	toolRuntime := NewToolRuntime(bus)
+	launcher := NewAgentLauncher(bus, store.NewMemoryTaskStore(), store.NewMemoryArchiveStore(), toolRuntime)
+	defer launcher.Stop()
+
+	starts := map[string]func() error{
+		"agent runtime":  NewAgentRuntime(bus, store.NewMemoryAgentStore(), store.NewMemoryArchiveStore()).Start,
+		"LLM runtime":    NewLLMRuntime(bus, StubLLMProcessor).Start,
+		"tool runtime":   toolRuntime.Start,
+		"agent launcher": launcher.Start,
+	}
+	for name, start := range starts {
+		if err := start(); err != nil {
+			t.Fatalf("starting %s: %v", name, err)
+		}
+	}
+
+	if len(bus.queues) != len(QueueGroups) {
+		t.Errorf("runtimes subscribe %d queue groups, QueueGroups lists %d", len(bus.queues), len(QueueGroups))
+	}
+	for queue, subscribed := range bus.queues {
+		listed := slices.Clone(QueueGroups[queue])
+		slices.Sort(listed)
+		slices.Sort(subscribed)
+		if !slices.Equal(listed, subscribed) {
+			t.Errorf("queue %s subscribes to %v, QueueGroups lists %v", queue, subscribed, listed)
+		}
+	}
+}
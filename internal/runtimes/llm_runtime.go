@@ -0,0 +1,39 @@
+package runtimes
+
+import (
+	"log"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+type LLMRuntime struct {
+	eventBus eventbus.EventBus
+	handler  *handlers.LLMHandler
+}
+
+func StubLLMProcessor(messages []llminterface.Message, tools llminterface.RequestToolList, agentID string, eb eventbus.EventBus) ([]llminterface.Message, error) {
+	log.Printf("[%s] Processing %d messages with %d tools", agentID, len(messages), len(tools))
+
+	response := []llminterface.Message{
+		llminterface.NewAssistantMessage("Hello from LLM processor"),
+	}
+	return response, nil
+}
+
+func NewLLMRuntime(eventBus eventbus.EventBus, processor llminterface.LLMProcessor) *LLMRuntime {
+	return &LLMRuntime{
+		eventBus: eventBus,
+		handler:  handlers.NewLLMHandler(eventBus, processor),
+	}
+}
+
+func (lr *LLMRuntime) Start() error {
+	if err := eventbus.Subscribe(lr.eventBus, events.LLMRequestEventName, LLMRuntimeQueueName, lr.handler.HandleLLMRequest); err != nil {
+		return err
+	}
+
+	return eventbus.Subscribe(lr.eventBus, events.LLMErrorEventName, LLMRuntimeQueueName, lr.handler.HandleLLMRuntimeError)
+}
@@ -0,0 +1,80 @@
+package runtimes
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Built-in template variables. They sit next to the request's own variables,
+// which may not reuse their names.
+const (
+	TemplateVarDate  = "Date"
+	TemplateVarTime  = "Time"
+	TemplateVarTools = "Tools"
+	TemplateVarOwner = "Owner"
+)
+
+var promptTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// usesTemplates reports whether the request's system prompt and task are
+// templates. Plain requests keep their text as is, so that existing prompts
+// containing "{{" are not reinterpreted.
+func usesTemplates(req CreateTaskRequest) bool {
+	return req.Profile != "" || req.Variables != nil
+}
+
+// renderPrompts executes the system prompt and task as text/template
+// templates over the request's variables and the built-ins. A variable the
+// templates use but the request does not supply is an error.
+func renderPrompts(req *CreateTaskRequest, tools []string, now time.Time) error {
+	if !usesTemplates(*req) {
+		return nil
+	}
+
+	data := map[string]any{
+		TemplateVarDate:  now.Format(time.DateOnly),
+		TemplateVarTime:  now.Format(time.RFC3339),
+		TemplateVarTools: tools,
+		TemplateVarOwner: req.Owner,
+	}
+	for name, value := range req.Variables {
+		if _, ok := data[name]; ok {
+			return fmt.Errorf("%w: variable %q is built in", errInvalidTemplate, name)
+		}
+		data[name] = value
+	}
+
+	var err error
+	if req.SystemPrompt, err = renderPrompt("system_prompt", req.SystemPrompt, data); err != nil {
+		return err
+	}
+	if req.Task, err = renderPrompt("task", req.Task, data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parsePrompt(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(promptTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidTemplate, err)
+	}
+	return tmpl, nil
+}
+
+func renderPrompt(name, text string, data map[string]any) (string, error) {
+	tmpl, err := parsePrompt(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidTemplate, err)
+	}
+	return rendered.String(), nil
+}
@@ -0,0 +1,117 @@
+package runtimes
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRenderPrompts(t *testing.T) {
+	now := time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+	tools := []string{"calculator", "weather"}
+
+	tests := []struct {
+		name   string
+		req    CreateTaskRequest
+		system string
+		task   string
+		err    error
+	}{
+		{
+			name:   "plain request is left alone",
+			req:    CreateTaskRequest{SystemPrompt: "Reply in {{braces}}", Task: "{{.Missing}}"},
+			system: "Reply in {{braces}}",
+			task:   "{{.Missing}}",
+		},
+		{
+			name: "variables",
+			req: CreateTaskRequest{
+				SystemPrompt: "You help {{.Team}}.",
+				Task:         "Summarise {{.Report}} for {{.Team}}",
+				Variables:    map[string]any{"Team": "finance", "Report": "Q1"},
+			},
+			system: "You help finance.",
+			task:   "Summarise Q1 for finance",
+		},
+		{
+			name: "built-ins",
+			req: CreateTaskRequest{
+				SystemPrompt: "Today is {{.Date}} ({{.Time}}). Tools: {{join .Tools \", \"}}.",
+				Task:         "Report for {{.Owner}}",
+				Owner:        "alice",
+				Variables:    map[string]any{},
+			},
+			system: "Today is 2025-03-01 (2025-03-01T09:30:00Z). Tools: calculator, weather.",
+			task:   "Report for alice",
+		},
+		{
+			name: "profile without variables renders built-ins",
+			req: CreateTaskRequest{
+				SystemPrompt: "Date: {{.Date}}",
+				Task:         "plain task",
+				Profile:      "analyst",
+			},
+			system: "Date: 2025-03-01",
+			task:   "plain task",
+		},
+		{
+			name: "missing variable in the task",
+			req: CreateTaskRequest{
+				Task:      "Summarise {{.Report}}",
+				Variables: map[string]any{"Team": "finance"},
+			},
+			err: errInvalidTemplate,
+		},
+		{
+			name: "missing variable in the system prompt",
+			req: CreateTaskRequest{
+				SystemPrompt: "You help {{.Team}}.",
+				Task:         "go",
+				Variables:    map[string]any{"Report": "Q1"},
+			},
+			err: errInvalidTemplate,
+		},
+		{
+			name: "variable shadowing a built-in",
+			req: CreateTaskRequest{
+				Task:      "{{.Date}}",
+				Variables: map[string]any{"Date": "yesterday"},
+			},
+			err: errInvalidTemplate,
+		},
+		{
+			name: "unparsable template",
+			req: CreateTaskRequest{
+				Task:      "Summarise {{.Report",
+				Variables: map[string]any{"Report": "Q1"},
+			},
+			err: errInvalidTemplate,
+		},
+		{
+			name: "unknown function",
+			req: CreateTaskRequest{
+				Task:      "{{upper .Report}}",
+				Variables: map[string]any{"Report": "Q1"},
+			},
+			err: errInvalidTemplate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := renderPrompts(&req, tools, now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if req.SystemPrompt != tt.system {
+				t.Errorf("system prompt rendered to %q, want %q", req.SystemPrompt, tt.system)
+			}
+			if req.Task != tt.task {
+				t.Errorf("task rendered to %q, want %q", req.Task, tt.task)
+			}
+		})
+	}
+}
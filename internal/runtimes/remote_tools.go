@@ -0,0 +1,65 @@
+package runtimes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+type RemoteToolSchemaProvider struct {
+	baseURL string
+}
+
+func NewRemoteToolSchemaProvider(toolRuntimeURL string) *RemoteToolSchemaProvider {
+	return &RemoteToolSchemaProvider{
+		baseURL: toolRuntimeURL,
+	}
+}
+
+func (rp *RemoteToolSchemaProvider) GetToolSchemas(toolNames []string) ([]llminterface.ToolSchema, error) {
+	reqBody := struct {
+		Tools []string `json:"tools"`
+	}{
+		Tools: toolNames,
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := http.Post(rp.baseURL+"/schemas", "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tool runtime: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tool runtime returned status %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Schemas []llminterface.ToolSchema `json:"schemas"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return response.Schemas, nil
+}
+
+func (rp *RemoteToolSchemaProvider) HealthCheck() error {
+	resp, err := http.Get(rp.baseURL + "/health")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tool runtime returned status %d", resp.StatusCode)
+	}
+	return nil
+}
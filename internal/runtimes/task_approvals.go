@@ -0,0 +1,211 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+const (
+	ApprovalDecisionApprove = "approve"
+	ApprovalDecisionReject  = "reject"
+)
+
+// ApprovalDecision approves or rejects one pending tool call. Arguments, when
+// set on an approval, replace the arguments the model chose.
+type ApprovalDecision struct {
+	ToolCallID string         `json:"tool_call_id"`
+	Decision   string         `json:"decision"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+}
+
+type ApprovalDecisionsRequest struct {
+	Decisions []ApprovalDecision `json:"decisions"`
+}
+
+type ApprovalsResponse struct {
+	AgentID   string                  `json:"agent_id"`
+	Approvals []store.PendingToolCall `json:"approvals"`
+	Resolved  bool                    `json:"resolved,omitempty"`
+}
+
+type heldCallError struct {
+	status  int
+	message string
+}
+
+func (e *heldCallError) Error() string { return e.message }
+
+func (al *AgentLauncher) getApprovalsHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Tool approvals are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	agentID := r.PathValue("agent_id")
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get agent: %v", agentID, err)
+		http.Error(w, "Failed to get approvals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvalsResponse(agent))
+}
+
+func (al *AgentLauncher) decideApprovalsHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Tool approvals are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	agentID := r.PathValue("agent_id")
+
+	var req ApprovalDecisionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Decisions) == 0 {
+		http.Error(w, "decisions is required", http.StatusBadRequest)
+		return
+	}
+	for _, decision := range req.Decisions {
+		switch decision.Decision {
+		case ApprovalDecisionApprove:
+		case ApprovalDecisionReject:
+			if decision.Arguments != nil {
+				http.Error(w, "arguments can only be given when approving", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, fmt.Sprintf("decision must be %q or %q", ApprovalDecisionApprove, ApprovalDecisionReject), http.StatusBadRequest)
+			return
+		}
+	}
+
+	response, err := al.decideApprovals(r, agentID, req.Decisions)
+	var heldCallErr *heldCallError
+	switch {
+	case errors.As(err, &heldCallErr):
+		http.Error(w, heldCallErr.message, heldCallErr.status)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("[%s] Failed to record tool approvals: %v", agentID, err)
+		http.Error(w, "Failed to record approvals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// decideApprovals records the decisions all at once or not at all. When the
+// last pending call is decided the task runs again and the agent runtime is
+// told to carry on.
+func (al *AgentLauncher) decideApprovals(r *http.Request, agentID string, decisions []ApprovalDecision) (*ApprovalsResponse, error) {
+	unlock, err := al.agentStore.Lock(r.Context(), agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !agent.ToolCallsOnHold {
+		return nil, &heldCallError{http.StatusConflict, "No tool calls are waiting for approval"}
+	}
+
+	for _, decision := range decisions {
+		i := slices.IndexFunc(agent.PendingToolCalls, func(call store.PendingToolCall) bool {
+			return call.ToolCallID == decision.ToolCallID && call.RequiresApproval
+		})
+		if i < 0 {
+			return nil, &heldCallError{http.StatusBadRequest, fmt.Sprintf("Unknown tool call %s", decision.ToolCallID)}
+		}
+		call := &agent.PendingToolCalls[i]
+		if call.Decision != "" {
+			return nil, &heldCallError{http.StatusConflict, fmt.Sprintf("Tool call %s already %s", call.ToolCallID, call.Decision)}
+		}
+
+		call.Reason = decision.Reason
+		if decision.Decision == ApprovalDecisionReject {
+			call.Decision = store.ToolCallRejected
+			continue
+		}
+		call.Decision = store.ToolCallApproved
+		if decision.Arguments != nil {
+			call.Arguments = decision.Arguments
+			call.Edited = true
+		}
+	}
+
+	if err := al.agentStore.UpdateAgent(agent); err != nil {
+		return nil, err
+	}
+
+	response := approvalsResponse(agent)
+	response.Resolved, err = al.resumeHeldToolCalls(agent)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// resumeHeldToolCalls moves the task on to what its agent still waits for,
+// and lets the agent carry on once its last held call has been decided or
+// answered. The caller holds the agent lock.
+func (al *AgentLauncher) resumeHeldToolCalls(agent *store.AgentData) (bool, error) {
+	status := agent.HeldTaskStatus()
+	if utils.IsPrimaryAgent(agent.AgentID) {
+		if _, err := al.taskStore.UpdateTask(agent.AgentID, store.TaskUpdate{Status: status}); err != nil {
+			log.Printf("[%s] Failed to move task to %s: %v", agent.AgentID, status, err)
+		}
+	}
+	if status != store.TaskStatusRunning {
+		return false, nil
+	}
+
+	resolvedEvent := events.ToolCallsResolvedEvent{
+		AgentID: agent.AgentID,
+	}
+	if err := al.eventBus.Emit(resolvedEvent); err != nil {
+		return false, fmt.Errorf("failed to emit tool calls resolved event: %w", err)
+	}
+	return true, nil
+}
+
+func approvalsResponse(agent *store.AgentData) *ApprovalsResponse {
+	response := &ApprovalsResponse{
+		AgentID:   agent.AgentID,
+		Approvals: []store.PendingToolCall{},
+	}
+	if !agent.ToolCallsOnHold {
+		return response
+	}
+	for _, call := range agent.PendingToolCalls {
+		if call.RequiresApproval {
+			response.Approvals = append(response.Approvals, call)
+		}
+	}
+	return response
+}
@@ -0,0 +1,285 @@
+package runtimes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+// heldCallsTest runs the agent runtime and the launcher on a memory bus and
+// records the requests they send to the tool and LLM runtimes.
+type heldCallsTest struct {
+	bus          eventbus.EventBus
+	mux          *http.ServeMux
+	agentStore   store.AgentStore
+	taskStore    store.TaskStore
+	toolRequests chan events.ToolsExecRequestEvent
+	llmRequests  chan events.LLMRequestEvent
+}
+
+func newHeldCallsTest(t *testing.T) *heldCallsTest {
+	t.Helper()
+	ht := &heldCallsTest{
+		bus:          eventbus.NewMemoryEventBus(),
+		mux:          http.NewServeMux(),
+		agentStore:   store.NewMemoryAgentStore(),
+		taskStore:    store.NewMemoryTaskStore(),
+		toolRequests: make(chan events.ToolsExecRequestEvent, 4),
+		llmRequests:  make(chan events.LLMRequestEvent, 4),
+	}
+	t.Cleanup(func() { ht.bus.Close() })
+
+	archiveStore := store.NewMemoryArchiveStore()
+	if err := NewAgentRuntime(ht.bus, ht.agentStore, archiveStore).Start(); err != nil {
+		t.Fatalf("Start agent runtime: %v", err)
+	}
+	launcher := NewAgentLauncher(ht.bus, ht.taskStore, archiveStore, nil).SetAgentStore(ht.agentStore)
+	if err := launcher.Start(); err != nil {
+		t.Fatalf("Start launcher: %v", err)
+	}
+	t.Cleanup(launcher.Stop)
+	launcher.RegisterRoutes(ht.mux)
+
+	err := eventbus.SubscribeBroadcast(ht.bus, events.ToolExecRequestEventName, "", func(ctx context.Context, e events.ToolsExecRequestEvent) {
+		ht.toolRequests <- e
+	})
+	if err != nil {
+		t.Fatalf("SubscribeBroadcast: %v", err)
+	}
+	err = eventbus.SubscribeBroadcast(ht.bus, events.LLMRequestEventName, "", func(ctx context.Context, e events.LLMRequestEvent) {
+		ht.llmRequests <- e
+	})
+	if err != nil {
+		t.Fatalf("SubscribeBroadcast: %v", err)
+	}
+	return ht
+}
+
+// holdToolCalls starts a task whose model asks for toolCalls, and waits for
+// the agent runtime to hold them and the task to move to status.
+func (ht *heldCallsTest) holdToolCalls(t *testing.T, status string, toolCalls ...llminterface.Message) string {
+	t.Helper()
+	agentID := utils.CreatePrimaryAgentID()
+	if err := ht.taskStore.CreateTask(store.TaskSpec{AgentID: agentID, Task: "tidy up"}); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	if _, err := ht.taskStore.UpdateTask(agentID, store.TaskUpdate{Status: store.TaskStatusRunning}); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	err := ht.agentStore.CreateAgent(&store.AgentData{
+		AgentID: agentID,
+		Task:    "tidy up",
+		ToolSchemas: []llminterface.ToolSchema{
+			{Name: "delete_file", RequiresApproval: true},
+			{Name: "calculator"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateAgent: %v", err)
+	}
+	if _, err := ht.agentStore.AppendConversation(agentID, 0, llminterface.NewUserMessage("tidy up")); err != nil {
+		t.Fatalf("AppendConversation: %v", err)
+	}
+
+	if err := ht.bus.Emit(events.LLMResponseEvent{AgentID: agentID, Response: toolCalls}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	waitForTaskStatus(t, ht.taskStore, agentID, status)
+	return agentID
+}
+
+// decide posts decisions to the approvals endpoint and returns the status
+// code and, on success, the response.
+func (ht *heldCallsTest) decide(t *testing.T, agentID string, decisions ...ApprovalDecision) (int, ApprovalsResponse) {
+	t.Helper()
+	body, err := json.Marshal(ApprovalDecisionsRequest{Decisions: decisions})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	ht.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+agentID+"/approvals", bytes.NewReader(body)))
+
+	var response ApprovalsResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+	}
+	return rec.Code, response
+}
+
+func (ht *heldCallsTest) nextToolRequest(t *testing.T) events.ToolsExecRequestEvent {
+	t.Helper()
+	select {
+	case e := <-ht.toolRequests:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("tool calls were not sent to the tool runtime")
+		return events.ToolsExecRequestEvent{}
+	}
+}
+
+func (ht *heldCallsTest) expectNoToolRequest(t *testing.T) {
+	t.Helper()
+	select {
+	case e := <-ht.toolRequests:
+		t.Fatalf("tool calls %+v sent while still held", e.ToolCalls)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestToolApprovals(t *testing.T) {
+	deleteCall := llminterface.NewToolCallMessage("call-1", "delete_file", map[string]any{"path": "a.txt"})
+	calculatorCall := llminterface.NewToolCallMessage("call-2", "calculator", map[string]any{"expression": "1+1"})
+
+	tests := []struct {
+		name     string
+		decision ApprovalDecision
+		// want are the arguments of the calls sent to the tool runtime.
+		want map[string]map[string]any
+	}{
+		{
+			name:     "approve",
+			decision: ApprovalDecision{ToolCallID: "call-1", Decision: ApprovalDecisionApprove},
+			want: map[string]map[string]any{
+				"call-1": {"path": "a.txt"},
+				"call-2": {"expression": "1+1"},
+			},
+		},
+		{
+			name:     "approve with edited arguments",
+			decision: ApprovalDecision{ToolCallID: "call-1", Decision: ApprovalDecisionApprove, Arguments: map[string]any{"path": "b.txt"}},
+			want: map[string]map[string]any{
+				"call-1": {"path": "b.txt"},
+				"call-2": {"expression": "1+1"},
+			},
+		},
+		{
+			name:     "reject",
+			decision: ApprovalDecision{ToolCallID: "call-1", Decision: ApprovalDecisionReject, Reason: "keep it"},
+			want: map[string]map[string]any{
+				"call-2": {"expression": "1+1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ht := newHeldCallsTest(t)
+			agentID := ht.holdToolCalls(t, store.TaskStatusWaitingForApproval, deleteCall, calculatorCall)
+			ht.expectNoToolRequest(t)
+
+			code, response := ht.decide(t, agentID, tt.decision)
+			if code != http.StatusOK {
+				t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+			}
+			if !response.Resolved {
+				t.Fatal("response not resolved after the only held call was decided")
+			}
+
+			request := ht.nextToolRequest(t)
+			got := make(map[string]map[string]any)
+			for _, call := range request.ToolCalls {
+				got[call.ToolCallID] = call.Arguments
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("tool calls = %v, want %v", got, tt.want)
+			}
+			waitForTaskStatus(t, ht.taskStore, agentID, store.TaskStatusWaitingForTool)
+
+			// The model sees the call that ran and, for a rejection, why
+			// the call did not.
+			conversation, err := ht.agentStore.GetConversation(agentID)
+			if err != nil {
+				t.Fatalf("GetConversation: %v", err)
+			}
+			for _, msg := range conversation.Messages {
+				if want, ok := tt.want[msg.ToolCallID]; ok && msg.Type == llminterface.MessageTypeToolCall && !reflect.DeepEqual(msg.Arguments, want) {
+					t.Errorf("conversation has %s with %v, want %v", msg.ToolCallID, msg.Arguments, want)
+				}
+			}
+
+			err = ht.bus.Emit(events.ToolsExecResultsEvent{
+				AgentID:     agentID,
+				ToolResults: []events.ToolResult{{AgentID: agentID, ToolName: "calculator", ToolCallID: "call-2", Result: "2"}},
+			})
+			if err != nil {
+				t.Fatalf("Emit: %v", err)
+			}
+			select {
+			case e := <-ht.llmRequests:
+				if tt.decision.Decision != ApprovalDecisionReject {
+					return
+				}
+				for _, msg := range e.Messages {
+					if msg.Type == llminterface.MessageTypeToolResult && msg.ToolCallID == "call-1" {
+						if msg.Result != "Tool call rejected by user: keep it" {
+							t.Fatalf("rejected call result %q", msg.Result)
+						}
+						return
+					}
+				}
+				t.Fatalf("LLM request %+v has no result for the rejected call", e.Messages)
+			case <-time.After(time.Second):
+				t.Fatal("agent did not carry on after the tool results")
+			}
+		})
+	}
+}
+
+func TestToolApprovalsResumeOnceResolved(t *testing.T) {
+	ht := newHeldCallsTest(t)
+	agentID := ht.holdToolCalls(t, store.TaskStatusWaitingForApproval,
+		llminterface.NewToolCallMessage("call-1", "delete_file", map[string]any{"path": "a.txt"}),
+		llminterface.NewToolCallMessage("call-2", "delete_file", map[string]any{"path": "b.txt"}),
+	)
+
+	code, response := ht.decide(t, agentID, ApprovalDecision{ToolCallID: "call-1", Decision: ApprovalDecisionApprove})
+	if code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+	if response.Resolved {
+		t.Fatal("response resolved while call-2 still waits for a decision")
+	}
+	ht.expectNoToolRequest(t)
+	waitForTaskStatus(t, ht.taskStore, agentID, store.TaskStatusWaitingForApproval)
+
+	for _, tt := range []struct {
+		decision ApprovalDecision
+		want     int
+	}{
+		{ApprovalDecision{ToolCallID: "call-1", Decision: ApprovalDecisionReject}, http.StatusConflict},
+		{ApprovalDecision{ToolCallID: "call-3", Decision: ApprovalDecisionApprove}, http.StatusBadRequest},
+	} {
+		if code, _ := ht.decide(t, agentID, tt.decision); code != tt.want {
+			t.Errorf("deciding %s: status code = %d, want %d", tt.decision.ToolCallID, code, tt.want)
+		}
+	}
+
+	code, response = ht.decide(t, agentID, ApprovalDecision{ToolCallID: "call-2", Decision: ApprovalDecisionReject})
+	if code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+	if !response.Resolved {
+		t.Fatal("response not resolved after every held call was decided")
+	}
+	request := ht.nextToolRequest(t)
+	if len(request.ToolCalls) != 1 || request.ToolCalls[0].ToolCallID != "call-1" {
+		t.Fatalf("tool calls = %+v, want only call-1", request.ToolCalls)
+	}
+
+	if code, _ := ht.decide(t, agentID, ApprovalDecision{ToolCallID: "call-2", Decision: ApprovalDecisionApprove}); code != http.StatusConflict {
+		t.Errorf("deciding after the calls were released: status code = %d, want %d", code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,311 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+const (
+	DefaultBatchConcurrency = 10
+	MaxBatchSize            = 1000
+
+	BatchStatusRunning   = "running"
+	BatchStatusCompleted = "completed"
+
+	// BatchItemStatusPending marks items still waiting for a slot under the
+	// batch's concurrency limit; they have no task yet.
+	BatchItemStatusPending = "pending"
+)
+
+// CreateBatchRequest submits many tasks at once. Owner applies to tasks that
+// name none. At most Concurrency of them run at a time, and never more than
+// the launcher's batch concurrency.
+type CreateBatchRequest struct {
+	Tasks       []CreateTaskRequest `json:"tasks"`
+	Owner       string              `json:"owner,omitempty"`
+	Concurrency int                 `json:"concurrency,omitempty"`
+}
+
+type BatchItemResult struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+	Result  string `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	BatchID     string            `json:"batch_id"`
+	Status      string            `json:"status"`
+	Owner       string            `json:"owner,omitempty"`
+	Concurrency int               `json:"concurrency"`
+	Total       int               `json:"total"`
+	Counts      map[string]int    `json:"counts"`
+	Items       []BatchItemResult `json:"items"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// BatchConcurrencyFromEnv reads BATCH_CONCURRENCY, defaulting to
+// DefaultBatchConcurrency.
+func BatchConcurrencyFromEnv() (int, error) {
+	v := os.Getenv("BATCH_CONCURRENCY")
+	if v == "" {
+		return DefaultBatchConcurrency, nil
+	}
+	concurrency, err := strconv.Atoi(v)
+	if err != nil || concurrency < 1 {
+		return 0, fmt.Errorf("invalid BATCH_CONCURRENCY %q", v)
+	}
+	return concurrency, nil
+}
+
+// SetBatchConcurrency caps how many tasks of one batch run at a time, so that
+// large batches leave room for interactive traffic.
+func (al *AgentLauncher) SetBatchConcurrency(concurrency int) *AgentLauncher {
+	al.batchConcurrency = concurrency
+	return al
+}
+
+func (al *AgentLauncher) createBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tasks) == 0 {
+		http.Error(w, "tasks is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tasks) > MaxBatchSize {
+		http.Error(w, fmt.Sprintf("a batch holds at most %d tasks", MaxBatchSize), http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency < 0 {
+		http.Error(w, "concurrency must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	concurrency := al.batchConcurrency
+	if req.Concurrency > 0 {
+		concurrency = min(req.Concurrency, concurrency)
+	}
+
+	batch := &store.BatchData{
+		BatchID:     utils.CreateBatchID(),
+		Owner:       req.Owner,
+		Concurrency: concurrency,
+		Items:       make([]store.BatchItem, len(req.Tasks)),
+		Launched:    min(concurrency, len(req.Tasks)),
+		CreatedAt:   time.Now().UTC(),
+	}
+	for i, taskReq := range req.Tasks {
+		if taskReq.Owner == "" {
+			taskReq.Owner = req.Owner
+		}
+		if err := al.validateTaskRequest(taskReq); err != nil {
+			http.Error(w, fmt.Sprintf("tasks[%d]: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(taskReq)
+		if err != nil {
+			log.Printf("[%s] Failed to marshal batch item: %v", batch.BatchID, err)
+			http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+			return
+		}
+		batch.Items[i] = store.BatchItem{
+			AgentID: utils.CreatePrimaryAgentID(),
+			Request: data,
+		}
+	}
+
+	if err := al.taskStore.CreateBatch(batch); err != nil {
+		log.Printf("[%s] Failed to create batch: %v", batch.BatchID, err)
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range batch.Launched {
+		if !al.launchBatchItem(batch, i) {
+			al.dispatchBatchItem(batch.BatchID)
+		}
+	}
+
+	al.writeBatch(w, batch.BatchID)
+}
+
+func (al *AgentLauncher) getBatchHandler(w http.ResponseWriter, r *http.Request) {
+	al.writeBatch(w, r.PathValue("batch_id"))
+}
+
+// writeBatch reports the progress of every item and counts them by status.
+func (al *AgentLauncher) writeBatch(w http.ResponseWriter, batchID string) {
+	batch, err := al.taskStore.GetBatch(batchID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get batch: %v", batchID, err)
+		http.Error(w, "Failed to get batch", http.StatusInternalServerError)
+		return
+	}
+
+	response := BatchResponse{
+		BatchID:     batch.BatchID,
+		Status:      BatchStatusCompleted,
+		Owner:       batch.Owner,
+		Concurrency: batch.Concurrency,
+		Total:       len(batch.Items),
+		Counts:      make(map[string]int),
+		Items:       make([]BatchItemResult, len(batch.Items)),
+		CreatedAt:   batch.CreatedAt,
+	}
+	for i, item := range batch.Items {
+		result := BatchItemResult{AgentID: item.AgentID, Status: BatchItemStatusPending}
+		if i < batch.Launched {
+			task, err := al.lookupTask(item.AgentID)
+			switch {
+			case err == nil:
+				result.Status = task.Status
+				result.Result = task.Result
+				result.Error = task.Error
+			case errors.Is(err, store.ErrNotFound):
+				result.Status = StatusNotFound
+			default:
+				log.Printf("[%s] Failed to get task: %v", item.AgentID, err)
+				http.Error(w, "Failed to get batch", http.StatusInternalServerError)
+				return
+			}
+		}
+		if result.Status != StatusNotFound && !store.IsTerminalTaskStatus(result.Status) {
+			response.Status = BatchStatusRunning
+		}
+		response.Items[i] = result
+		response.Counts[result.Status]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// batchTaskFinished gives the slot of a finished batch task to the next
+// waiting item.
+func (al *AgentLauncher) batchTaskFinished(task *store.TaskData) {
+	if task.BatchID != "" {
+		al.dispatchBatchItem(task.BatchID)
+	}
+}
+
+// dispatchBatchItem launches the next waiting item of the batch, moving on to
+// the one after it when an item fails to launch.
+func (al *AgentLauncher) dispatchBatchItem(batchID string) {
+	batch, err := al.taskStore.GetBatch(batchID)
+	if err != nil {
+		log.Printf("[%s] Failed to get batch: %v", batchID, err)
+		return
+	}
+
+	for {
+		index, err := al.taskStore.ClaimBatchItem(batchID)
+		if err != nil {
+			log.Printf("[%s] Failed to claim batch item: %v", batchID, err)
+			return
+		}
+		if index < 0 || al.launchBatchItem(batch, index) {
+			return
+		}
+	}
+}
+
+// redispatchStalledBatches fills the free slots of batches that have fewer
+// running items than their concurrency allows, as happens when the launcher
+// handling a finished item crashes or fails to claim the next one. A batch
+// whose progress moves while it is being checked is left to the next sweep,
+// so that a slot being refilled concurrently is not filled twice, and so is a
+// batch created within the last minute, whose first items may still be
+// launching.
+func (al *AgentLauncher) redispatchStalledBatches() {
+	batchIDs, err := al.taskStore.ActiveBatches()
+	if err != nil {
+		log.Printf("Failed to list active batches: %v", err)
+		return
+	}
+
+	for _, batchID := range batchIDs {
+		batch, err := al.taskStore.GetBatch(batchID)
+		if err != nil {
+			log.Printf("[%s] Failed to get batch: %v", batchID, err)
+			continue
+		}
+		if time.Since(batch.CreatedAt) < time.Minute {
+			continue
+		}
+
+		running := 0
+		for _, item := range batch.Items[:batch.Launched] {
+			task, err := al.taskStore.GetTask(item.AgentID)
+			if err == nil && !store.IsTerminalTaskStatus(task.Status) {
+				running++
+			}
+		}
+
+		current, err := al.taskStore.GetBatch(batchID)
+		if err != nil || current.Launched != batch.Launched {
+			continue
+		}
+		if free := batch.Concurrency - running; free > 0 {
+			log.Printf("[%s] Restarting %d stalled batch slots", batchID, free)
+			for range free {
+				al.dispatchBatchItem(batchID)
+			}
+		}
+	}
+}
+
+// launchBatchItem launches one claimed item. An item that cannot be launched
+// is recorded as a failed task so that the batch still completes.
+func (al *AgentLauncher) launchBatchItem(batch *store.BatchData, index int) bool {
+	item := batch.Items[index]
+
+	var req CreateTaskRequest
+	err := json.Unmarshal(item.Request, &req)
+	if err == nil {
+		req.batchID = batch.BatchID
+		err = al.launchTask(item.AgentID, req, nil)
+	}
+	if err == nil {
+		return true
+	}
+	log.Printf("[%s] Failed to launch batch item: %v", item.AgentID, err)
+
+	callback, _ := taskCallback(req)
+	err = al.taskStore.CreateTask(store.TaskSpec{
+		AgentID:  item.AgentID,
+		Task:     req.Task,
+		Owner:    req.Owner,
+		BatchID:  batch.BatchID,
+		Callback: callback,
+	})
+	if err != nil {
+		log.Printf("[%s] Failed to record failed batch item: %v", item.AgentID, err)
+		return false
+	}
+	task, err := al.taskStore.UpdateTask(item.AgentID, store.TaskUpdate{
+		Status: store.TaskStatusFailed,
+		Error:  "failed to launch task",
+	})
+	if err != nil {
+		log.Printf("[%s] Failed to record failed batch item: %v", item.AgentID, err)
+		return false
+	}
+	al.handler.NotifyCallback(task)
+	return false
+}
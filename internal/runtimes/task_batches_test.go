@@ -0,0 +1,80 @@
+package runtimes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+func TestRedispatchStalledBatches(t *testing.T) {
+	bus := eventbus.NewMemoryEventBus()
+	defer bus.Close()
+	taskStore := store.NewMemoryTaskStore()
+	launcher := NewAgentLauncher(bus, taskStore, store.NewMemoryArchiveStore(), NewToolRuntime(bus))
+	defer launcher.Stop()
+
+	newBatch := func(createdAt time.Time) *store.BatchData {
+		batch := &store.BatchData{
+			BatchID:     utils.CreateBatchID(),
+			Concurrency: 2,
+			Items:       make([]store.BatchItem, 4),
+			Launched:    2,
+			CreatedAt:   createdAt,
+		}
+		for i := range batch.Items {
+			batch.Items[i] = store.BatchItem{
+				AgentID: utils.CreatePrimaryAgentID(),
+				Request: []byte(`{"task":"report"}`),
+			}
+		}
+		if err := taskStore.CreateBatch(batch); err != nil {
+			t.Fatalf("CreateBatch: %v", err)
+		}
+		// The first item finished without its slot being handed on; the
+		// second is still running.
+		for i, status := range []string{store.TaskStatusSucceeded, store.TaskStatusRunning} {
+			spec := store.TaskSpec{AgentID: batch.Items[i].AgentID, Task: "report", BatchID: batch.BatchID}
+			if err := taskStore.CreateTask(spec); err != nil {
+				t.Fatalf("CreateTask: %v", err)
+			}
+			if _, err := taskStore.UpdateTask(spec.AgentID, store.TaskUpdate{Status: status}); err != nil {
+				t.Fatalf("UpdateTask: %v", err)
+			}
+		}
+		return batch
+	}
+	stalled := newBatch(time.Now().UTC().Add(-time.Hour))
+	recent := newBatch(time.Now().UTC())
+
+	launcher.redispatchStalledBatches()
+	launcher.redispatchStalledBatches()
+
+	tests := []struct {
+		batch    *store.BatchData
+		launched int
+	}{
+		{stalled, 3},
+		{recent, 2},
+	}
+	for _, tt := range tests {
+		batch, err := taskStore.GetBatch(tt.batch.BatchID)
+		if err != nil {
+			t.Fatalf("GetBatch: %v", err)
+		}
+		if batch.Launched != tt.launched {
+			t.Errorf("batch %s launched %d items, want %d", batch.BatchID, batch.Launched, tt.launched)
+		}
+		for i, item := range batch.Items {
+			exists, err := taskStore.TaskExists(item.AgentID)
+			if err != nil {
+				t.Fatalf("TaskExists: %v", err)
+			}
+			if exists != (i < tt.launched) {
+				t.Errorf("item %d of batch %s has task %v, want %v", i, batch.BatchID, exists, i < tt.launched)
+			}
+		}
+	}
+}
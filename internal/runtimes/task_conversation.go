@@ -0,0 +1,138 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+const (
+	ConversationSourceLive    = "live"
+	ConversationSourceArchive = "archive"
+)
+
+type ConversationResponse struct {
+	AgentID   string                          `json:"agent_id"`
+	Source    string                          `json:"source"`
+	Messages  []llminterface.Message          `json:"messages"`
+	SubAgents map[string]ConversationResponse `json:"sub_agents,omitempty"`
+}
+
+// SetAgentStore lets the launcher read transcripts of agents that are still
+// running. Without it only archived transcripts are available.
+func (al *AgentLauncher) SetAgentStore(agentStore store.AgentStore) *AgentLauncher {
+	al.agentStore = agentStore
+	return al
+}
+
+func (al *AgentLauncher) getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	agentID := r.PathValue("agent_id")
+
+	includeSubAgents := false
+	if v := r.URL.Query().Get("include_subagents"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "include_subagents must be a boolean", http.StatusBadRequest)
+			return
+		}
+		includeSubAgents = include
+	}
+
+	response, err := al.loadConversation(agentID, includeSubAgents)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get conversation: %v", agentID, err)
+		http.Error(w, "Failed to get conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// loadConversation prefers the live transcript and falls back to the archive
+// once the agent has finished. Sub-agents are resolved the same way, one by
+// one, since some may still be running while others are already archived.
+func (al *AgentLauncher) loadConversation(agentID string, includeSubAgents bool) (*ConversationResponse, error) {
+	var archived *store.ArchivedTask
+	if al.archiveStore != nil {
+		task, err := al.archiveStore.GetArchivedTask(agentID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		archived = task
+	}
+
+	response, subAgentIDs, err := al.liveConversation(agentID)
+	if errors.Is(err, store.ErrNotFound) && archived != nil {
+		response = &ConversationResponse{
+			AgentID:  agentID,
+			Source:   ConversationSourceArchive,
+			Messages: archived.Agent.Messages,
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !includeSubAgents {
+		return response, nil
+	}
+
+	response.SubAgents = make(map[string]ConversationResponse)
+	if archived != nil {
+		for _, subAgent := range archived.SubAgents {
+			response.SubAgents[subAgent.AgentID] = ConversationResponse{
+				AgentID:  subAgent.AgentID,
+				Source:   ConversationSourceArchive,
+				Messages: subAgent.Messages,
+			}
+		}
+	}
+	for _, subAgentID := range subAgentIDs {
+		if _, ok := response.SubAgents[subAgentID]; ok {
+			continue
+		}
+		subAgent, _, err := al.liveConversation(subAgentID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		response.SubAgents[subAgentID] = *subAgent
+	}
+
+	return response, nil
+}
+
+func (al *AgentLauncher) liveConversation(agentID string) (*ConversationResponse, []string, error) {
+	if al.agentStore == nil {
+		return nil, nil, store.ErrNotFound
+	}
+
+	conversation, err := al.agentStore.GetConversation(agentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var subAgentIDs []string
+	if agent, err := al.agentStore.GetAgent(agentID); err == nil {
+		subAgentIDs = agent.SubAgentIDs
+	}
+
+	return &ConversationResponse{
+		AgentID:  agentID,
+		Source:   ConversationSourceLive,
+		Messages: conversation.Messages,
+	}, subAgentIDs, nil
+}
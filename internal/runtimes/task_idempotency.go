@@ -0,0 +1,111 @@
+package runtimes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+	MaxIdempotencyKeyLength  = 255
+)
+
+// IdempotencyKeyTTLFromEnv reads IDEMPOTENCY_KEY_TTL as a Go duration,
+// defaulting to DefaultIdempotencyKeyTTL.
+func IdempotencyKeyTTLFromEnv() (time.Duration, error) {
+	v := os.Getenv("IDEMPOTENCY_KEY_TTL")
+	if v == "" {
+		return DefaultIdempotencyKeyTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid IDEMPOTENCY_KEY_TTL %q", v)
+	}
+	return ttl, nil
+}
+
+// SetIdempotencyKeyTTL sets how long an Idempotency-Key keeps pointing at the
+// task it created.
+func (al *AgentLauncher) SetIdempotencyKeyTTL(ttl time.Duration) *AgentLauncher {
+	al.idempotencyKeyTTL = ttl
+	return al
+}
+
+// reserveIdempotencyKey claims key for agentID. When an earlier request holds
+// the key, its record is returned and nothing should be launched.
+func (al *AgentLauncher) reserveIdempotencyKey(key string, req CreateTaskRequest, agentID string) (*store.IdempotencyRecord, error) {
+	requestHash, err := hashTaskRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	return al.taskStore.ReserveIdempotencyKey(store.IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		AgentID:     agentID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(al.idempotencyKeyTTL),
+	})
+}
+
+func hashTaskRequest(req CreateTaskRequest) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal task request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// replayTask answers a retried request with the task the first request
+// created, as long as the retry carries the same body.
+func (al *AgentLauncher) replayTask(w http.ResponseWriter, r *http.Request, record *store.IdempotencyRecord, req CreateTaskRequest, wait time.Duration) {
+	requestHash, err := hashTaskRequest(req)
+	if err != nil {
+		log.Printf("[%s] Failed to replay task: %v", record.AgentID, err)
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+	if requestHash != record.RequestHash {
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	task, err := al.lookupTask(record.AgentID)
+	if errors.Is(err, store.ErrNotFound) {
+		// The first request has reserved the key but not created the task yet.
+		http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get task: %v", record.AgentID, err)
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	if wait > 0 {
+		al.writeTaskOutcome(w, r, task.AgentID, wait)
+		return
+	}
+
+	response := CreateTaskResponse{
+		AgentID: task.AgentID,
+		Status:  task.Status,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,187 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+// Question is something an agent asked through the ask_user tool and is
+// waiting to have answered.
+type Question struct {
+	ToolCallID string `json:"tool_call_id"`
+	Question   string `json:"question"`
+}
+
+type QuestionsResponse struct {
+	AgentID   string     `json:"agent_id"`
+	Questions []Question `json:"questions"`
+}
+
+// AnswerRequest answers one question. ToolCallID may be left out while only a
+// single question is open.
+type AnswerRequest struct {
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Answer     string `json:"answer"`
+}
+
+type AnswerResponse struct {
+	AgentID    string `json:"agent_id"`
+	ToolCallID string `json:"tool_call_id"`
+	Resumed    bool   `json:"resumed"`
+}
+
+func (al *AgentLauncher) getQuestionsHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Questions are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	agentID := r.PathValue("agent_id")
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get agent: %v", agentID, err)
+		http.Error(w, "Failed to get questions", http.StatusInternalServerError)
+		return
+	}
+
+	response := QuestionsResponse{
+		AgentID:   agentID,
+		Questions: openQuestions(agent),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (al *AgentLauncher) answerQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Questions are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	agentID := r.PathValue("agent_id")
+
+	var req AnswerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Answer == "" {
+		http.Error(w, "answer is required", http.StatusBadRequest)
+		return
+	}
+
+	response, err := al.answerQuestion(r, agentID, req)
+	var heldCallErr *heldCallError
+	switch {
+	case errors.As(err, &heldCallErr):
+		http.Error(w, heldCallErr.message, heldCallErr.status)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("[%s] Failed to record answer: %v", agentID, err)
+		http.Error(w, "Failed to record answer", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (al *AgentLauncher) answerQuestion(r *http.Request, agentID string, req AnswerRequest) (*AnswerResponse, error) {
+	unlock, err := al.agentStore.Lock(r.Context(), agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	open := openQuestions(agent)
+	if len(open) == 0 {
+		return nil, &heldCallError{http.StatusConflict, "No questions are waiting for an answer"}
+	}
+
+	toolCallID := req.ToolCallID
+	if toolCallID == "" {
+		if len(open) > 1 {
+			return nil, &heldCallError{http.StatusBadRequest, "tool_call_id is required while several questions are open"}
+		}
+		toolCallID = open[0].ToolCallID
+	}
+
+	answered := false
+	for i := range agent.PendingToolCalls {
+		call := &agent.PendingToolCalls[i]
+		if call.ToolCallID != toolCallID || !call.IsQuestion() {
+			continue
+		}
+		if call.Answer != "" {
+			return nil, &heldCallError{http.StatusConflict, fmt.Sprintf("Question %s already answered", toolCallID)}
+		}
+		call.Answer = req.Answer
+		answered = true
+		break
+	}
+	if !answered {
+		return nil, &heldCallError{http.StatusBadRequest, fmt.Sprintf("Unknown question %s", toolCallID)}
+	}
+
+	if err := al.agentStore.UpdateAgent(agent); err != nil {
+		return nil, err
+	}
+
+	resumed, err := al.resumeHeldToolCalls(agent)
+	if err != nil {
+		return nil, err
+	}
+	return &AnswerResponse{
+		AgentID:    agentID,
+		ToolCallID: toolCallID,
+		Resumed:    resumed,
+	}, nil
+}
+
+// pendingQuestions lists the open questions of a task for its result, if the
+// launcher can see live agents.
+func (al *AgentLauncher) pendingQuestions(agentID string) []Question {
+	if al.agentStore == nil {
+		return nil
+	}
+	agent, err := al.agentStore.GetAgent(agentID)
+	if err != nil {
+		return nil
+	}
+	return openQuestions(agent)
+}
+
+func openQuestions(agent *store.AgentData) []Question {
+	questions := []Question{}
+	if !agent.ToolCallsOnHold {
+		return questions
+	}
+	for _, call := range agent.PendingToolCalls {
+		if call.IsQuestion() && call.Answer == "" {
+			questions = append(questions, Question{
+				ToolCallID: call.ToolCallID,
+				Question:   call.Question,
+			})
+		}
+	}
+	return questions
+}
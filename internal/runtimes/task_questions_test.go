@@ -0,0 +1,192 @@
+package runtimes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+// answer posts req to the answers endpoint and returns the status code and,
+// on success, the response.
+func (ht *heldCallsTest) answer(t *testing.T, agentID string, req AnswerRequest) (int, AnswerResponse) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	ht.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+agentID+"/answers", bytes.NewReader(body)))
+
+	var response AnswerResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+	}
+	return rec.Code, response
+}
+
+func (ht *heldCallsTest) openQuestions(t *testing.T, agentID string) []Question {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ht.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+agentID+"/questions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var response QuestionsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return response.Questions
+}
+
+func (ht *heldCallsTest) nextLLMRequest(t *testing.T) events.LLMRequestEvent {
+	t.Helper()
+	select {
+	case e := <-ht.llmRequests:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("agent did not carry on")
+		return events.LLMRequestEvent{}
+	}
+}
+
+// toolResults lists the tool call ID and result of each tool result in
+// messages.
+func toolResults(messages []llminterface.Message) [][2]string {
+	var results [][2]string
+	for _, msg := range messages {
+		if msg.Type == llminterface.MessageTypeToolResult {
+			results = append(results, [2]string{msg.ToolCallID, msg.Result})
+		}
+	}
+	return results
+}
+
+func askUser(toolCallID, question string) llminterface.Message {
+	return llminterface.NewToolCallMessage(toolCallID, handlers.AskUserToolName, map[string]any{"question": question})
+}
+
+func TestAskUserAnswerBecomesToolResult(t *testing.T) {
+	ht := newHeldCallsTest(t)
+	agentID := ht.holdToolCalls(t, store.TaskStatusWaitingForInput,
+		askUser("call-1", "Which file?"),
+		llminterface.NewToolCallMessage("call-2", "calculator", map[string]any{"expression": "1+1"}),
+	)
+	ht.expectNoToolRequest(t)
+
+	want := []Question{{ToolCallID: "call-1", Question: "Which file?"}}
+	if got := ht.openQuestions(t, agentID); !reflect.DeepEqual(got, want) {
+		t.Fatalf("questions = %+v, want %+v", got, want)
+	}
+
+	// The only open question can be answered without naming it.
+	code, response := ht.answer(t, agentID, AnswerRequest{Answer: "notes.txt"})
+	if code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+	if response.ToolCallID != "call-1" || !response.Resumed {
+		t.Fatalf("response = %+v, want call-1 answered and the agent resumed", response)
+	}
+
+	request := ht.nextToolRequest(t)
+	if len(request.ToolCalls) != 1 || request.ToolCalls[0].ToolCallID != "call-2" {
+		t.Fatalf("tool calls = %+v, want only call-2", request.ToolCalls)
+	}
+	waitForTaskStatus(t, ht.taskStore, agentID, store.TaskStatusWaitingForTool)
+
+	err := ht.bus.Emit(events.ToolsExecResultsEvent{
+		AgentID:     agentID,
+		ToolResults: []events.ToolResult{{AgentID: agentID, ToolName: "calculator", ToolCallID: "call-2", Result: "2"}},
+	})
+	if err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	wantResults := [][2]string{{"call-1", "notes.txt"}, {"call-2", "2"}}
+	if got := toolResults(ht.nextLLMRequest(t).Messages); !reflect.DeepEqual(got, wantResults) {
+		t.Fatalf("tool results = %v, want %v", got, wantResults)
+	}
+}
+
+func TestAskUserAnswers(t *testing.T) {
+	ht := newHeldCallsTest(t)
+	agentID := ht.holdToolCalls(t, store.TaskStatusWaitingForInput,
+		askUser("call-1", "Which file?"),
+		askUser("call-2", "Which folder?"),
+	)
+
+	if code, _ := ht.answer(t, agentID, AnswerRequest{Answer: "notes.txt"}); code != http.StatusBadRequest {
+		t.Errorf("answering without tool_call_id while two questions are open: status code = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	code, response := ht.answer(t, agentID, AnswerRequest{ToolCallID: "call-1", Answer: "notes.txt"})
+	if code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+	if response.Resumed {
+		t.Fatal("agent resumed while call-2 is still open")
+	}
+	waitForTaskStatus(t, ht.taskStore, agentID, store.TaskStatusWaitingForInput)
+
+	for _, tt := range []struct {
+		name string
+		req  AnswerRequest
+		want int
+	}{
+		{"already answered", AnswerRequest{ToolCallID: "call-1", Answer: "other.txt"}, http.StatusConflict},
+		{"unknown question", AnswerRequest{ToolCallID: "call-3", Answer: "other.txt"}, http.StatusBadRequest},
+		{"no answer", AnswerRequest{ToolCallID: "call-2"}, http.StatusBadRequest},
+	} {
+		if code, _ := ht.answer(t, agentID, tt.req); code != tt.want {
+			t.Errorf("%s: status code = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	code, response = ht.answer(t, agentID, AnswerRequest{ToolCallID: "call-2", Answer: "docs"})
+	if code != http.StatusOK || !response.Resumed {
+		t.Fatalf("answering the last question: status code %d, response %+v", code, response)
+	}
+
+	// With nothing left to run the answers go straight back to the model.
+	wantResults := [][2]string{{"call-1", "notes.txt"}, {"call-2", "docs"}}
+	if got := toolResults(ht.nextLLMRequest(t).Messages); !reflect.DeepEqual(got, wantResults) {
+		t.Fatalf("tool results = %v, want %v", got, wantResults)
+	}
+	waitForTaskStatus(t, ht.taskStore, agentID, store.TaskStatusRunning)
+
+	if code, _ := ht.answer(t, agentID, AnswerRequest{ToolCallID: "call-2", Answer: "again"}); code != http.StatusConflict {
+		t.Errorf("answering after the agent resumed: status code = %d, want %d", code, http.StatusConflict)
+	}
+}
+
+func TestAskUserWaitsForApprovalFirst(t *testing.T) {
+	ht := newHeldCallsTest(t)
+	agentID := ht.holdToolCalls(t, store.TaskStatusWaitingForApproval,
+		askUser("call-1", "Which file?"),
+		llminterface.NewToolCallMessage("call-2", "delete_file", map[string]any{"path": "a.txt"}),
+	)
+
+	code, _ := ht.decide(t, agentID, ApprovalDecision{ToolCallID: "call-2", Decision: ApprovalDecisionApprove})
+	if code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+	waitForTaskStatus(t, ht.taskStore, agentID, store.TaskStatusWaitingForInput)
+	ht.expectNoToolRequest(t)
+
+	if code, response := ht.answer(t, agentID, AnswerRequest{Answer: "a.txt"}); code != http.StatusOK || !response.Resumed {
+		t.Fatalf("answering: status code %d, response %+v", code, response)
+	}
+	request := ht.nextToolRequest(t)
+	if len(request.ToolCalls) != 1 || request.ToolCalls[0].ToolCallID != "call-2" {
+		t.Fatalf("tool calls = %+v, want only call-2", request.ToolCalls)
+	}
+}
@@ -0,0 +1,509 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+const (
+	// MinScheduleInterval is the shortest interval a schedule may run at,
+	// matching the one minute resolution of cron expressions.
+	MinScheduleInterval = time.Minute
+
+	DefaultScheduleRunsLimit = 20
+
+	scheduleCheckInterval = 5 * time.Second
+	scheduleRunRetryAfter = time.Minute
+)
+
+// ScheduleRequest creates or replaces a schedule. Exactly one of Cron and
+// Interval is required; cron expressions are read in Timezone, UTC by
+// default.
+type ScheduleRequest struct {
+	Name     string            `json:"name,omitempty"`
+	Cron     string            `json:"cron,omitempty"`
+	Interval string            `json:"interval,omitempty"`
+	Timezone string            `json:"timezone,omitempty"`
+	Task     CreateTaskRequest `json:"task"`
+	Enabled  *bool             `json:"enabled,omitempty"`
+}
+
+type ScheduleResponse struct {
+	ScheduleID string            `json:"schedule_id"`
+	Name       string            `json:"name,omitempty"`
+	Cron       string            `json:"cron,omitempty"`
+	Interval   string            `json:"interval,omitempty"`
+	Timezone   string            `json:"timezone,omitempty"`
+	Task       CreateTaskRequest `json:"task"`
+	Enabled    bool              `json:"enabled"`
+	NextRunAt  *time.Time        `json:"next_run_at,omitempty"`
+	LastRunAt  *time.Time        `json:"last_run_at,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+type SchedulesResponse struct {
+	Schedules []ScheduleResponse `json:"schedules"`
+}
+
+// ScheduleRunResponse is a past run with the current state of its task.
+type ScheduleRunResponse struct {
+	store.ScheduleRun
+	Status string `json:"status"`
+	Result string `json:"result,omitempty"`
+}
+
+type ScheduleRunsResponse struct {
+	ScheduleID string                `json:"schedule_id"`
+	Runs       []ScheduleRunResponse `json:"runs"`
+}
+
+// SetScheduleStore enables scheduled tasks. Every launcher replica checks the
+// schedules; the store makes sure each run fires on only one of them.
+func (al *AgentLauncher) SetScheduleStore(scheduleStore store.ScheduleStore) *AgentLauncher {
+	al.scheduleStore = scheduleStore
+	return al
+}
+
+// scheduleSpec is the parsed timing of a schedule.
+type scheduleSpec struct {
+	cron     *utils.CronSchedule
+	interval time.Duration
+	location *time.Location
+}
+
+func parseScheduleSpec(cron, interval, timezone string) (*scheduleSpec, error) {
+	if (cron == "") == (interval == "") {
+		return nil, fmt.Errorf("exactly one of cron and interval is required")
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
+
+	spec := &scheduleSpec{location: location}
+	if interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed < MinScheduleInterval {
+			return nil, fmt.Errorf("interval must be a duration of at least %s", MinScheduleInterval)
+		}
+		spec.interval = parsed.Truncate(time.Second)
+		return spec, nil
+	}
+
+	parsed, err := utils.ParseCron(cron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression: %w", err)
+	}
+	spec.cron = parsed
+	if spec.next(time.Time{}, time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", cron)
+	}
+	return spec, nil
+}
+
+// next returns the first run after now. Intervals keep the phase of the
+// previous run, if any; runs missed while no launcher was up are skipped.
+func (s *scheduleSpec) next(previous, now time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.Next(now.In(s.location)).UTC()
+	}
+	if previous.IsZero() || previous.After(now) {
+		return now.Add(s.interval).Truncate(time.Second).UTC()
+	}
+	missed := now.Sub(previous) / s.interval
+	return previous.Add((missed + 1) * s.interval).UTC()
+}
+
+func (al *AgentLauncher) schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	if al.scheduleStore == nil {
+		http.Error(w, "Schedules are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		al.listSchedulesHandler(w, r)
+	case http.MethodPost:
+		al.createScheduleHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (al *AgentLauncher) scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if al.scheduleStore == nil {
+		http.Error(w, "Schedules are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		al.getScheduleHandler(w, r)
+	case http.MethodPut:
+		al.updateScheduleHandler(w, r)
+	case http.MethodDelete:
+		al.deleteScheduleHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (al *AgentLauncher) listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	schedules, err := al.scheduleStore.ListSchedules()
+	if err != nil {
+		log.Printf("Failed to list schedules: %v", err)
+		http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+		return
+	}
+
+	response := SchedulesResponse{
+		Schedules: make([]ScheduleResponse, 0, len(schedules)),
+	}
+	for i := range schedules {
+		response.Schedules = append(response.Schedules, scheduleResponse(&schedules[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (al *AgentLauncher) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	schedule := &store.ScheduleData{
+		ScheduleID: utils.CreateScheduleID(),
+		CreatedAt:  now,
+	}
+	if !al.decodeSchedule(w, r, schedule, now) {
+		return
+	}
+
+	if err := al.scheduleStore.CreateSchedule(schedule); err != nil {
+		log.Printf("[%s] Failed to create schedule: %v", schedule.ScheduleID, err)
+		http.Error(w, "Failed to create schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(scheduleResponse(schedule))
+}
+
+func (al *AgentLauncher) getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := al.getSchedule(w, r.PathValue("schedule_id"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduleResponse(schedule))
+}
+
+// updateScheduleHandler replaces the schedule and works out its next run
+// afresh.
+func (al *AgentLauncher) updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := al.getSchedule(w, r.PathValue("schedule_id"))
+	if !ok {
+		return
+	}
+	if !al.decodeSchedule(w, r, schedule, time.Now().UTC()) {
+		return
+	}
+
+	err := al.scheduleStore.UpdateSchedule(schedule)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to update schedule: %v", schedule.ScheduleID, err)
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduleResponse(schedule))
+}
+
+func (al *AgentLauncher) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	scheduleID := r.PathValue("schedule_id")
+
+	err := al.scheduleStore.DeleteSchedule(scheduleID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to delete schedule: %v", scheduleID, err)
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (al *AgentLauncher) scheduleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if al.scheduleStore == nil {
+		http.Error(w, "Schedules are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	scheduleID := r.PathValue("schedule_id")
+
+	limit := DefaultScheduleRunsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, store.MaxScheduleRuns)
+	}
+
+	if _, ok := al.getSchedule(w, scheduleID); !ok {
+		return
+	}
+	runs, err := al.scheduleStore.ListScheduleRuns(scheduleID, limit)
+	if err != nil {
+		log.Printf("[%s] Failed to list schedule runs: %v", scheduleID, err)
+		http.Error(w, "Failed to list schedule runs", http.StatusInternalServerError)
+		return
+	}
+
+	response := ScheduleRunsResponse{
+		ScheduleID: scheduleID,
+		Runs:       make([]ScheduleRunResponse, 0, len(runs)),
+	}
+	for _, run := range runs {
+		runResponse := ScheduleRunResponse{ScheduleRun: run, Status: store.TaskStatusFailed}
+		if run.Pending {
+			runResponse.Status = store.TaskStatusQueued
+		} else if run.Error == "" {
+			task, err := al.lookupTask(run.AgentID)
+			if err == nil {
+				runResponse.Status = task.Status
+				runResponse.Result = task.Result
+				runResponse.Error = task.Error
+			} else {
+				runResponse.Status = StatusNotFound
+			}
+		}
+		response.Runs = append(response.Runs, runResponse)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (al *AgentLauncher) getSchedule(w http.ResponseWriter, scheduleID string) (*store.ScheduleData, bool) {
+	schedule, err := al.scheduleStore.GetSchedule(scheduleID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get schedule: %v", scheduleID, err)
+		http.Error(w, "Failed to get schedule", http.StatusInternalServerError)
+		return nil, false
+	}
+	return schedule, true
+}
+
+// decodeSchedule reads a ScheduleRequest into schedule and sets its next run,
+// writing a 400 response when the request is invalid.
+func (al *AgentLauncher) decodeSchedule(w http.ResponseWriter, r *http.Request, schedule *store.ScheduleData, now time.Time) bool {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	if req.Task.Task == "" {
+		http.Error(w, "task.task is required", http.StatusBadRequest)
+		return false
+	}
+	if err := al.validateTaskRequest(req.Task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	spec, err := parseScheduleSpec(req.Cron, req.Interval, req.Timezone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	task, err := json.Marshal(req.Task)
+	if err != nil {
+		http.Error(w, "Invalid task", http.StatusBadRequest)
+		return false
+	}
+
+	schedule.Name = req.Name
+	schedule.Cron = req.Cron
+	schedule.Interval = req.Interval
+	schedule.Timezone = req.Timezone
+	schedule.Task = task
+	schedule.Enabled = req.Enabled == nil || *req.Enabled
+	schedule.NextRunAt = spec.next(time.Time{}, now)
+	schedule.UpdatedAt = now
+	return true
+}
+
+// scheduleResponse leaves out the task's callback secret.
+func scheduleResponse(schedule *store.ScheduleData) ScheduleResponse {
+	response := ScheduleResponse{
+		ScheduleID: schedule.ScheduleID,
+		Name:       schedule.Name,
+		Cron:       schedule.Cron,
+		Interval:   schedule.Interval,
+		Timezone:   schedule.Timezone,
+		Enabled:    schedule.Enabled,
+		LastRunAt:  schedule.LastRunAt,
+		CreatedAt:  schedule.CreatedAt,
+		UpdatedAt:  schedule.UpdatedAt,
+	}
+	json.Unmarshal(schedule.Task, &response.Task)
+	response.Task.CallbackSecret = ""
+	if schedule.Enabled {
+		response.NextRunAt = &schedule.NextRunAt
+	}
+	return response
+}
+
+func (al *AgentLauncher) runSchedules() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-al.stop:
+			return
+		case <-ticker.C:
+			al.fireDueSchedules()
+			al.retryPendingScheduleRuns()
+		}
+	}
+}
+
+// fireDueSchedules claims a run of every enabled schedule whose next run has
+// come, and launches its task in the background. A run missed entirely, e.g.
+// while no launcher was up, fires once.
+func (al *AgentLauncher) fireDueSchedules() {
+	schedules, err := al.scheduleStore.ListSchedules()
+	if err != nil {
+		log.Printf("Failed to list schedules: %v", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range schedules {
+		schedule := &schedules[i]
+		if !schedule.Enabled || schedule.NextRunAt.After(now) {
+			continue
+		}
+
+		spec, err := parseScheduleSpec(schedule.Cron, schedule.Interval, schedule.Timezone)
+		if err != nil {
+			log.Printf("[%s] Invalid schedule: %v", schedule.ScheduleID, err)
+			continue
+		}
+
+		// The run is recorded as pending with the claim, so that a launcher
+		// stopping before the task is launched leaves it to be retried
+		// rather than lost.
+		run := store.ScheduleRun{
+			ScheduleID:  schedule.ScheduleID,
+			AgentID:     utils.CreatePrimaryAgentID(),
+			ScheduledAt: schedule.NextRunAt,
+			StartedAt:   now,
+			Pending:     true,
+		}
+		claimed, err := al.scheduleStore.ClaimScheduleRun(run, spec.next(schedule.NextRunAt, now))
+		if err != nil {
+			log.Printf("[%s] Failed to claim schedule run: %v", schedule.ScheduleID, err)
+			continue
+		}
+		if claimed {
+			go al.launchScheduleRun(run, schedule.Task)
+		}
+	}
+}
+
+// retryPendingScheduleRuns takes over runs left pending for longer than a
+// launch takes, by a launcher that stopped or failed to record the outcome.
+// A run whose task was created before that happened is only marked as
+// launched.
+func (al *AgentLauncher) retryPendingScheduleRuns() {
+	now := time.Now().UTC()
+	runs, err := al.scheduleStore.PendingScheduleRuns(now.Add(-scheduleRunRetryAfter))
+	if err != nil {
+		log.Printf("Failed to list pending schedule runs: %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		startedAt := run.StartedAt
+		run.StartedAt = now
+		claimed, err := al.scheduleStore.UpdateScheduleRun(run, startedAt)
+		if err != nil {
+			log.Printf("[%s] Failed to claim pending schedule run %s: %v", run.ScheduleID, run.AgentID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		exists, err := al.taskStore.TaskExists(run.AgentID)
+		if err != nil {
+			log.Printf("[%s] Failed to check scheduled task %s: %v", run.ScheduleID, run.AgentID, err)
+			continue
+		}
+		if exists {
+			al.finishScheduleRun(run, nil)
+			continue
+		}
+
+		schedule, err := al.scheduleStore.GetSchedule(run.ScheduleID)
+		if err != nil {
+			al.finishScheduleRun(run, fmt.Errorf("failed to get schedule: %w", err))
+			continue
+		}
+		log.Printf("[%s] Retrying pending run %s", run.ScheduleID, run.AgentID)
+		go al.launchScheduleRun(run, schedule.Task)
+	}
+}
+
+func (al *AgentLauncher) launchScheduleRun(run store.ScheduleRun, task json.RawMessage) {
+	var req CreateTaskRequest
+	err := json.Unmarshal(task, &req)
+	if err == nil {
+		err = al.launchTask(run.AgentID, req, nil)
+	}
+	al.finishScheduleRun(run, err)
+}
+
+func (al *AgentLauncher) finishScheduleRun(run store.ScheduleRun, err error) {
+	startedAt := run.StartedAt
+	run.Pending = false
+	if err != nil {
+		log.Printf("[%s] Failed to launch scheduled task: %v", run.ScheduleID, err)
+		run.Error = err.Error()
+	} else {
+		log.Printf("[%s] Launched scheduled task %s", run.ScheduleID, run.AgentID)
+	}
+
+	if _, err := al.scheduleStore.UpdateScheduleRun(run, startedAt); err != nil {
+		log.Printf("[%s] Failed to record schedule run: %v", run.ScheduleID, err)
+	}
+}
@@ -0,0 +1,132 @@
+package runtimes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+func newScheduleTestLauncher(t *testing.T) (*AgentLauncher, store.TaskStore, store.ScheduleStore) {
+	t.Helper()
+
+	bus := eventbus.NewMemoryEventBus()
+	t.Cleanup(func() { bus.Close() })
+	taskStore := store.NewMemoryTaskStore()
+	scheduleStore := store.NewMemoryScheduleStore()
+	launcher := NewAgentLauncher(bus, taskStore, store.NewMemoryArchiveStore(), NewToolRuntime(bus)).
+		SetScheduleStore(scheduleStore)
+	t.Cleanup(launcher.Stop)
+	return launcher, taskStore, scheduleStore
+}
+
+func createDueSchedule(t *testing.T, scheduleStore store.ScheduleStore, scheduleID string, due time.Time) {
+	t.Helper()
+
+	err := scheduleStore.CreateSchedule(&store.ScheduleData{
+		ScheduleID: scheduleID,
+		Interval:   "1h",
+		Task:       []byte(`{"task":"report"}`),
+		Enabled:    true,
+		NextRunAt:  due,
+		CreatedAt:  due,
+		UpdatedAt:  due,
+	})
+	if err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+}
+
+// waitForLaunchedRun waits for the schedule's latest run to stop being
+// pending and checks that its task was launched.
+func waitForLaunchedRun(t *testing.T, taskStore store.TaskStore, scheduleStore store.ScheduleStore, scheduleID string) store.ScheduleRun {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		runs, err := scheduleStore.ListScheduleRuns(scheduleID, 1)
+		if err != nil {
+			t.Fatalf("ListScheduleRuns: %v", err)
+		}
+		if len(runs) == 1 && !runs[0].Pending {
+			run := runs[0]
+			if run.Error != "" {
+				t.Fatalf("run failed: %s", run.Error)
+			}
+			if exists, err := taskStore.TaskExists(run.AgentID); err != nil || !exists {
+				t.Fatalf("task %s of the run was not created (err %v)", run.AgentID, err)
+			}
+			return run
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run of %s still pending: %+v", scheduleID, runs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFireDueSchedulesRecordsRunBeforeLaunch(t *testing.T) {
+	launcher, taskStore, scheduleStore := newScheduleTestLauncher(t)
+
+	due := time.Now().UTC().Add(-time.Second).Truncate(time.Second)
+	createDueSchedule(t, scheduleStore, "schedule-1", due)
+
+	launcher.fireDueSchedules()
+
+	run := waitForLaunchedRun(t, taskStore, scheduleStore, "schedule-1")
+	if !run.ScheduledAt.Equal(due) {
+		t.Errorf("run scheduled at %s, want %s", run.ScheduledAt, due)
+	}
+	schedule, err := scheduleStore.GetSchedule("schedule-1")
+	if err != nil {
+		t.Fatalf("GetSchedule: %v", err)
+	}
+	if !schedule.NextRunAt.After(due) {
+		t.Errorf("next run %s was not moved past %s", schedule.NextRunAt, due)
+	}
+}
+
+func TestRetryPendingScheduleRuns(t *testing.T) {
+	launcher, taskStore, scheduleStore := newScheduleTestLauncher(t)
+
+	// A launcher that claimed these runs and stopped before launching them.
+	now := time.Now().UTC()
+	stale := store.ScheduleRun{
+		ScheduleID:  "stale",
+		AgentID:     utils.CreatePrimaryAgentID(),
+		ScheduledAt: now.Add(-time.Hour).Truncate(time.Second),
+		StartedAt:   now.Add(-2 * scheduleRunRetryAfter),
+		Pending:     true,
+	}
+	recent := store.ScheduleRun{
+		ScheduleID:  "recent",
+		AgentID:     utils.CreatePrimaryAgentID(),
+		ScheduledAt: now.Add(-time.Hour).Truncate(time.Second),
+		StartedAt:   now,
+		Pending:     true,
+	}
+	for _, run := range []store.ScheduleRun{stale, recent} {
+		createDueSchedule(t, scheduleStore, run.ScheduleID, run.ScheduledAt)
+		claimed, err := scheduleStore.ClaimScheduleRun(run, now.Add(time.Hour))
+		if err != nil || !claimed {
+			t.Fatalf("ClaimScheduleRun(%s) = %v, %v", run.ScheduleID, claimed, err)
+		}
+	}
+
+	launcher.retryPendingScheduleRuns()
+
+	run := waitForLaunchedRun(t, taskStore, scheduleStore, "stale")
+	if run.AgentID != stale.AgentID {
+		t.Errorf("retried run launched %s, want the claimed %s", run.AgentID, stale.AgentID)
+	}
+
+	runs, err := scheduleStore.ListScheduleRuns("recent", 1)
+	if err != nil {
+		t.Fatalf("ListScheduleRuns: %v", err)
+	}
+	if len(runs) != 1 || !runs[0].Pending {
+		t.Errorf("run pending for less than %s was retried: %+v", scheduleRunRetryAfter, runs)
+	}
+}
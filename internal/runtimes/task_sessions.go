@@ -0,0 +1,304 @@
+package runtimes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+// DefaultSessionTTL is how long a session may sit idle before it is closed.
+// Redis drops live agent data after 12 hours without writes, so longer TTLs
+// are capped by that in practice.
+const DefaultSessionTTL = time.Hour
+
+type SessionMessageRequest struct {
+	Content string `json:"content"`
+}
+
+type SessionResponse struct {
+	AgentID   string     `json:"agent_id"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// SessionTTLFromEnv reads SESSION_TTL as a Go duration, defaulting to
+// DefaultSessionTTL.
+func SessionTTLFromEnv() (time.Duration, error) {
+	v := os.Getenv("SESSION_TTL")
+	if v == "" {
+		return DefaultSessionTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid SESSION_TTL %q", v)
+	}
+	return ttl, nil
+}
+
+// SetSessionTTL sets how long a session may go without a message before
+// further messages are refused and it is closed.
+func (al *AgentLauncher) SetSessionTTL(ttl time.Duration) *AgentLauncher {
+	al.sessionTTL = ttl
+	return al
+}
+
+func (al *AgentLauncher) sessionExpiry() *time.Time {
+	expiresAt := time.Now().UTC().Add(al.sessionTTL)
+	return &expiresAt
+}
+
+func (al *AgentLauncher) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Sessions are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	var req CreateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := al.sessionExpiry()
+	agentID := utils.CreatePrimaryAgentID()
+	if err := al.launchTask(agentID, req, expiresAt); err != nil {
+		writeLaunchError(w, err)
+		return
+	}
+
+	response := SessionResponse{
+		AgentID:   agentID,
+		Status:    store.TaskStatusQueued,
+		ExpiresAt: expiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (al *AgentLauncher) sessionMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Sessions are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	agentID := r.PathValue("agent_id")
+
+	var req SessionMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Content == "" {
+		http.Error(w, "content is required", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, status, err := al.continueSession(r, agentID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errSessionExpired):
+		http.Error(w, "Session expired", http.StatusGone)
+		return
+	case errors.Is(err, store.ErrTaskActive):
+		http.Error(w, "Session is still working on the previous message", http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrTaskFinished):
+		http.Error(w, fmt.Sprintf("Session task was %s", status), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[%s] Failed to continue session: %v", agentID, err)
+		http.Error(w, "Failed to continue session", http.StatusInternalServerError)
+		return
+	}
+
+	messageEvent := events.SessionMessageEvent{
+		AgentID: agentID,
+		Content: req.Content,
+	}
+	if err := al.eventBus.Emit(messageEvent); err != nil {
+		log.Printf("[%s] Failed to emit session message event: %v", agentID, err)
+		al.taskStore.UpdateTask(agentID, store.TaskUpdate{
+			Status: store.TaskStatusFailed,
+			Error:  "failed to deliver session message",
+		})
+		http.Error(w, "Failed to continue session", http.StatusInternalServerError)
+		return
+	}
+
+	response := SessionResponse{
+		AgentID:   agentID,
+		Status:    store.TaskStatusRunning,
+		ExpiresAt: expiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+var errSessionExpired = errors.New("session expired")
+
+// continueSession reopens the session's task for another turn and pushes its
+// expiry back. Only one turn runs at a time; an expired session is closed.
+func (al *AgentLauncher) continueSession(r *http.Request, agentID string) (*time.Time, string, error) {
+	unlock, err := al.agentStore.Lock(r.Context(), agentID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !agent.IsSession() {
+		return nil, "", store.ErrNotFound
+	}
+	if time.Now().After(*agent.SessionExpiresAt) {
+		al.closeSession(agentID, "session expired")
+		return nil, "", errSessionExpired
+	}
+
+	task, err := al.taskStore.UpdateTask(agentID, store.TaskUpdate{
+		Status: store.TaskStatusRunning,
+		Reopen: true,
+	})
+	if err != nil {
+		status := ""
+		if task != nil {
+			status = task.Status
+		}
+		return nil, status, err
+	}
+
+	agent.SessionExpiresAt = al.sessionExpiry()
+	if err := al.agentStore.UpdateAgent(agent); err != nil {
+		return nil, "", err
+	}
+	return agent.SessionExpiresAt, task.Status, nil
+}
+
+func (al *AgentLauncher) closeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if al.agentStore == nil {
+		http.Error(w, "Sessions are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	agentID := r.PathValue("agent_id")
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if err == nil && !agent.IsSession() {
+		err = store.ErrNotFound
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to get session: %v", agentID, err)
+		http.Error(w, "Failed to close session", http.StatusInternalServerError)
+		return
+	}
+
+	task, err := al.closeSession(agentID, "closed by request")
+	if err != nil {
+		log.Printf("[%s] Failed to close session: %v", agentID, err)
+		http.Error(w, "Failed to close session", http.StatusInternalServerError)
+		return
+	}
+
+	response := SessionResponse{
+		AgentID: agentID,
+		Status:  task.Status,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (al *AgentLauncher) sweepExpiredSessions() {
+	interval := min(max(al.sessionTTL/4, time.Second), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-al.stop:
+			return
+		case <-ticker.C:
+			al.closeExpiredSessions()
+		}
+	}
+}
+
+// closeExpiredSessions closes the sessions that went without a message for
+// longer than their TTL. Each is checked again under the agent lock, so a
+// message that just extended the session keeps it open.
+func (al *AgentLauncher) closeExpiredSessions() {
+	agentIDs, err := al.agentStore.ExpiredSessions(time.Now())
+	if err != nil {
+		log.Printf("Failed to list expired sessions: %v", err)
+		return
+	}
+
+	for _, agentID := range agentIDs {
+		al.closeExpiredSession(agentID)
+	}
+}
+
+func (al *AgentLauncher) closeExpiredSession(agentID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	unlock, err := al.agentStore.Lock(ctx, agentID)
+	if err != nil {
+		log.Printf("[%s] Failed to lock expired session: %v", agentID, err)
+		return
+	}
+	defer unlock()
+
+	agent, err := al.agentStore.GetAgent(agentID)
+	if err != nil || !agent.IsSession() || time.Now().Before(*agent.SessionExpiresAt) {
+		return
+	}
+	if _, err := al.closeSession(agentID, "session expired"); err != nil {
+		log.Printf("[%s] Failed to close expired session: %v", agentID, err)
+		return
+	}
+	log.Printf("[%s] Session expired", agentID)
+}
+
+// closeSession cancels a turn that is still running, which also releases the
+// agent, or otherwise releases the idle agent directly.
+func (al *AgentLauncher) closeSession(agentID, reason string) (*store.TaskData, error) {
+	task, err := al.stopTask(agentID, store.TaskStatusCancelled, reason)
+	if err == nil {
+		return task, nil
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		task = &store.TaskData{AgentID: agentID, Status: StatusNotFound}
+	} else if !errors.Is(err, store.ErrTaskFinished) {
+		return nil, err
+	}
+
+	closeEvent := events.SessionCloseEvent{
+		AgentID: agentID,
+		Reason:  reason,
+	}
+	if err := al.eventBus.Emit(closeEvent); err != nil {
+		return nil, fmt.Errorf("failed to emit session close event: %w", err)
+	}
+	return task, nil
+}
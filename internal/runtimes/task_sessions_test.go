@@ -0,0 +1,66 @@
+package runtimes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+func TestCloseExpiredSessions(t *testing.T) {
+	bus := eventbus.NewMemoryEventBus()
+	defer bus.Close()
+	agentStore := store.NewMemoryAgentStore()
+	taskStore := store.NewMemoryTaskStore()
+	launcher := NewAgentLauncher(bus, taskStore, store.NewMemoryArchiveStore(), nil).SetAgentStore(agentStore)
+	defer launcher.Stop()
+
+	closed := make(chan events.SessionCloseEvent, 2)
+	err := eventbus.SubscribeBroadcast(bus, events.SessionCloseEventName, "", func(ctx context.Context, e events.SessionCloseEvent) {
+		closed <- e
+	})
+	if err != nil {
+		t.Fatalf("SubscribeBroadcast: %v", err)
+	}
+
+	now := time.Now().UTC()
+	sessions := map[string]time.Time{
+		utils.CreatePrimaryAgentID(): now.Add(-time.Minute),
+		utils.CreatePrimaryAgentID(): now.Add(time.Hour),
+	}
+	var expired string
+	for agentID, expiresAt := range sessions {
+		if expiresAt.Before(now) {
+			expired = agentID
+		}
+		if err := taskStore.CreateTask(store.TaskSpec{AgentID: agentID, Task: "chat"}); err != nil {
+			t.Fatalf("CreateTask: %v", err)
+		}
+		if _, err := taskStore.UpdateTask(agentID, store.TaskUpdate{Status: store.TaskStatusSucceeded}); err != nil {
+			t.Fatalf("UpdateTask: %v", err)
+		}
+		if err := agentStore.CreateAgent(&store.AgentData{AgentID: agentID, SessionExpiresAt: &expiresAt}); err != nil {
+			t.Fatalf("CreateAgent: %v", err)
+		}
+	}
+
+	launcher.closeExpiredSessions()
+
+	select {
+	case e := <-closed:
+		if e.AgentID != expired {
+			t.Fatalf("closed session %s, want %s", e.AgentID, expired)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expired session was not closed")
+	}
+	select {
+	case e := <-closed:
+		t.Fatalf("session %s closed before it expired", e.AgentID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
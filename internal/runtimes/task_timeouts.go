@@ -0,0 +1,83 @@
+package runtimes
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+// TaskTimeoutFromEnv reads TASK_TIMEOUT as a Go duration; unset or zero
+// leaves tasks without a timeout.
+func TaskTimeoutFromEnv() (time.Duration, error) {
+	v := os.Getenv("TASK_TIMEOUT")
+	if v == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid TASK_TIMEOUT %q", v)
+	}
+	return timeout, nil
+}
+
+// sweepTasks times out overdue tasks and restarts stalled batches.
+func (al *AgentLauncher) sweepTasks() {
+	interval := time.Minute
+	if al.taskTimeout > 0 {
+		interval = min(max(al.taskTimeout/4, time.Second), interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-al.stop:
+			return
+		case <-ticker.C:
+			if al.taskTimeout > 0 {
+				al.timeOutTasks()
+			}
+			al.redispatchStalledBatches()
+		}
+	}
+}
+
+// timeOutTasks stops every active task started, or for a task still queued
+// created, more than taskTimeout ago. Several launcher replicas may sweep at once; only the one whose transition
+// wins emits the cancel event.
+func (al *AgentLauncher) timeOutTasks() {
+	deadline := time.Now().Add(-al.taskTimeout)
+	reason := fmt.Sprintf("task did not finish within %s", al.taskTimeout)
+
+	for _, status := range store.ActiveTaskStatuses {
+		filter := store.TaskFilter{
+			Status:        status,
+			CreatedBefore: deadline,
+			Limit:         store.MaxTaskLimit,
+		}
+		for {
+			page, err := al.taskStore.ListTasks(filter)
+			if err != nil {
+				log.Printf("Failed to list %s tasks for timeout: %v", status, err)
+				break
+			}
+
+			for _, task := range page.Tasks {
+				if task.StartedAt != nil && task.StartedAt.After(deadline) {
+					continue
+				}
+				if _, err := al.stopTask(task.AgentID, store.TaskStatusTimedOut, reason); err == nil {
+					log.Printf("[%s] Task timed out", task.AgentID)
+				}
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			filter.Cursor = page.NextCursor
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package runtimes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+const (
+	DefaultTaskWait = 30 * time.Second
+	MaxTaskWait     = 5 * time.Minute
+
+	// taskWaitPollInterval bounds how late a waiter notices a finished task
+	// when it misses the event, e.g. without broadcast subscriptions.
+	taskWaitPollInterval = time.Second
+)
+
+// taskWaiters wakes HTTP requests held open by wait=true. Every launcher
+// replica observes the task outcome events itself, because the request may be
+// held by any of them.
+type taskWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]chan store.TaskUpdate
+}
+
+func newTaskWaiters() *taskWaiters {
+	return &taskWaiters{
+		waiters: make(map[string][]chan store.TaskUpdate),
+	}
+}
+
+func (tw *taskWaiters) add(agentID string) chan store.TaskUpdate {
+	ch := make(chan store.TaskUpdate, 1)
+	tw.mu.Lock()
+	tw.waiters[agentID] = append(tw.waiters[agentID], ch)
+	tw.mu.Unlock()
+	return ch
+}
+
+func (tw *taskWaiters) remove(agentID string, ch chan store.TaskUpdate) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	waiters := tw.waiters[agentID]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(tw.waiters, agentID)
+	} else {
+		tw.waiters[agentID] = waiters
+	}
+}
+
+func (tw *taskWaiters) notify(agentID string, outcome store.TaskUpdate) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	for _, ch := range tw.waiters[agentID] {
+		select {
+		case ch <- outcome:
+		default:
+		}
+	}
+}
+
+// observeTaskOutcomes feeds the waiters. Without broadcast support, waits
+// still finish through polling, only later.
+func (al *AgentLauncher) observeTaskOutcomes() {
+	err := eventbus.SubscribeBroadcast(al.eventBus, events.TaskFinishEventName, "", func(ctx context.Context, event events.TaskFinishEvent) {
+		al.waiters.notify(event.AgentID, store.TaskUpdate{Status: store.TaskStatusSucceeded, Result: event.Result})
+	})
+	if err == nil {
+		err = eventbus.SubscribeBroadcast(al.eventBus, events.TaskErrorEventName, "", func(ctx context.Context, event events.TaskErrorEvent) {
+			al.waiters.notify(event.AgentID, store.TaskUpdate{Status: store.TaskStatusFailed, Error: event.Error})
+		})
+	}
+	if err == nil {
+		err = eventbus.SubscribeBroadcast(al.eventBus, events.TaskCancelEventName, "", func(ctx context.Context, event events.TaskCancelEvent) {
+			al.waiters.notify(event.AgentID, store.TaskUpdate{Status: event.Status, Error: event.Reason})
+		})
+	}
+	if err != nil {
+		log.Printf("Synchronous task waits fall back to polling: %v", err)
+	}
+}
+
+// parseTaskWait reads the wait and timeout query parameters of POST /tasks.
+// It returns zero when the caller does not want to wait.
+func parseTaskWait(r *http.Request) (time.Duration, error) {
+	query := r.URL.Query()
+
+	wait := false
+	if v := query.Get("wait"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return 0, fmt.Errorf("wait must be a boolean")
+		}
+		wait = parsed
+	}
+	if !wait {
+		return 0, nil
+	}
+
+	timeout := DefaultTaskWait
+	if v := query.Get("timeout"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("timeout must be a positive duration such as 30s")
+		}
+		timeout = min(parsed, MaxTaskWait)
+	}
+	return timeout, nil
+}
+
+// waitForTask blocks until the task finishes, the timeout elapses or the
+// client goes away, and reports whether the task finished.
+func (al *AgentLauncher) waitForTask(ctx context.Context, agentID string, timeout time.Duration) (*store.TaskData, bool) {
+	outcomes := al.waiters.add(agentID)
+	defer al.waiters.remove(agentID, outcomes)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(taskWaitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		task, err := al.taskStore.GetTask(agentID)
+		if err == nil && store.IsTerminalTaskStatus(task.Status) {
+			return task, true
+		}
+
+		select {
+		case outcome := <-outcomes:
+			// The launcher handler may not have stored the outcome yet.
+			if task, err = al.taskStore.GetTask(agentID); err != nil {
+				task = &store.TaskData{AgentID: agentID}
+			}
+			if !store.IsTerminalTaskStatus(task.Status) {
+				finishedAt := time.Now().UTC()
+				task.Status = outcome.Status
+				task.Result = outcome.Result
+				task.Error = outcome.Error
+				task.FinishedAt = &finishedAt
+			}
+			return task, true
+		case <-ticker.C:
+		case <-timer.C:
+			// Report the task as it is now rather than at the last poll.
+			if latest, err := al.taskStore.GetTask(agentID); err == nil {
+				return latest, store.IsTerminalTaskStatus(latest.Status)
+			}
+			return task, false
+		case <-ctx.Done():
+			return task, false
+		}
+	}
+}
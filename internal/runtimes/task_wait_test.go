@@ -0,0 +1,123 @@
+package runtimes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/store"
+)
+
+func TestParseTaskWait(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"wait=false", 0, false},
+		{"timeout=10s", 0, false},
+		{"wait=true", DefaultTaskWait, false},
+		{"wait=1&timeout=2s", 2 * time.Second, false},
+		{"wait=true&timeout=1h", MaxTaskWait, false},
+		{"wait=maybe", 0, true},
+		{"wait=true&timeout=0s", 0, true},
+		{"wait=true&timeout=-5s", 0, true},
+		{"wait=true&timeout=30", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseTaskWait(httptest.NewRequest(http.MethodPost, "/tasks?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTaskWait error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("parseTaskWait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTaskWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		// run plays the agent runtime for the created task.
+		run        func(bus eventbus.EventBus, agentID string) error
+		wantCode   int
+		wantStatus string
+		wantResult string
+	}{
+		{
+			name:    "finishes before the timeout",
+			timeout: "5s",
+			run: func(bus eventbus.EventBus, agentID string) error {
+				return bus.Emit(events.TaskFinishEvent{AgentID: agentID, Result: "done"})
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: store.TaskStatusSucceeded,
+			wantResult: "done",
+		},
+		{
+			name:    "still running at the timeout",
+			timeout: "300ms",
+			run: func(bus eventbus.EventBus, agentID string) error {
+				return bus.Emit(events.AgentStartEvent{AgentID: agentID})
+			},
+			wantCode:   http.StatusAccepted,
+			wantStatus: store.TaskStatusRunning,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := eventbus.NewMemoryEventBus()
+			defer bus.Close()
+			launcher := NewAgentLauncher(bus, store.NewMemoryTaskStore(), store.NewMemoryArchiveStore(), NewToolRuntime(bus))
+			if err := launcher.Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer launcher.Stop()
+			mux := http.NewServeMux()
+			launcher.RegisterRoutes(mux)
+
+			created := make(chan string, 1)
+			err := eventbus.Subscribe(bus, events.TaskCreateEventName, AgentRuntimeQueueName, func(ctx context.Context, e events.TaskCreateEvent) {
+				created <- e.AgentID
+				if err := tt.run(bus, e.AgentID); err != nil {
+					t.Errorf("Emit: %v", err)
+				}
+			})
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+
+			started := time.Now()
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks?wait=true&timeout="+tt.timeout, strings.NewReader(`{"task":"work"}`)))
+			elapsed := time.Since(started)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var response GetResultResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if agentID := <-created; response.AgentID != agentID {
+				t.Fatalf("response for %s, want %s", response.AgentID, agentID)
+			}
+			if response.Status != tt.wantStatus || response.Result != tt.wantResult {
+				t.Fatalf("response = %+v, want status %s with result %q", response, tt.wantStatus, tt.wantResult)
+			}
+			if tt.wantCode == http.StatusOK && elapsed >= time.Second {
+				t.Fatalf("finished task was returned after %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers/tools"
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+type ToolRuntime struct {
+	eventBus eventbus.EventBus
+	handler  *handlers.ToolHandler
+}
+
+func NewToolRuntime(eventBus eventbus.EventBus) *ToolRuntime {
+	handler := handlers.NewToolHandler(eventBus)
+
+	handler.Register(tools.NewCalculatorTool())
+	handler.Register(tools.NewWeatherTool())
+	handler.Register(tools.NewCurrentTimeTool())
+	handler.Register(tools.NewRandomNumberTool())
+	handler.Register(tools.NewAskUserTool())
+	handler.Register(tools.NewCreateAgentTool(eventBus, handler))
+
+	return &ToolRuntime{
+		eventBus: eventBus,
+		handler:  handler,
+	}
+}
+
+// ToolsRequiringApprovalFromEnv reads TOOLS_REQUIRING_APPROVAL, a comma
+// separated list of tool names.
+func ToolsRequiringApprovalFromEnv() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("TOOLS_REQUIRING_APPROVAL"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (tr *ToolRuntime) RequireApproval(toolNames []string) error {
+	return tr.handler.RequireApproval(toolNames)
+}
+
+func (tr *ToolRuntime) Start() error {
+	if err := eventbus.Subscribe(tr.eventBus, events.ToolExecRequestEventName, ToolRuntimeQueueName, tr.handler.HandleToolExecution); err != nil {
+		return err
+	}
+
+	// A create_agent call waits in the replica that runs it, so every replica
+	// watches for sub-agents finishing.
+	if err := eventbus.SubscribeBroadcast(tr.eventBus, events.AgentFinishEventName, "", tr.handler.HandleSubAgentFinish); err != nil {
+		return err
+	}
+	return eventbus.SubscribeBroadcast(tr.eventBus, events.AgentErrorEventName, "", tr.handler.HandleSubAgentError)
+}
+
+func (tr *ToolRuntime) GetToolSchemas(toolNames []string) ([]llminterface.ToolSchema, error) {
+	if toolNames == nil {
+		return tr.handler.GetAllToolSchemas(), nil
+	}
+
+	var schemas []llminterface.ToolSchema
+	for _, toolName := range toolNames {
+		if tool, err := tr.handler.GetTool(toolName); err == nil {
+			schemas = append(schemas, tool.ToolSchema)
+		}
+	}
+	return schemas, nil
+}
+
+func (tr *ToolRuntime) HealthCheck() error {
+	return nil
+}
+
+func (tr *ToolRuntime) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/schemas", tr.getSchemasHandler)
+	mux.HandleFunc("/health", tr.healthHandler)
+}
+
+func (tr *ToolRuntime) getSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Tools []string `json:"tools"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	schemas, _ := tr.GetToolSchemas(req.Tools)
+
+	response := struct {
+		Schemas []llminterface.ToolSchema `json:"schemas"`
+	}{
+		Schemas: schemas,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (tr *ToolRuntime) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
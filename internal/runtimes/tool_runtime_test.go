@@ -0,0 +1,109 @@
+package runtimes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/eventbus"
+	"github.com/cugtyt/agentlauncher-distributed/internal/events"
+	"github.com/cugtyt/agentlauncher-distributed/internal/handlers"
+	"github.com/cugtyt/agentlauncher-distributed/internal/utils"
+)
+
+func TestCreateAgentTool(t *testing.T) {
+	tests := []struct {
+		name string
+		// agentID makes the call, a primary agent unless set.
+		agentID string
+		// finish ends the sub-agent the way the agent runtime would.
+		finish func(subAgentID string) eventbus.Event
+		want   string
+	}{
+		{
+			name: "returns the sub-agent's result",
+			finish: func(subAgentID string) eventbus.Event {
+				return events.AgentFinishEvent{AgentID: subAgentID, Result: "42"}
+			},
+			want: "42",
+		},
+		{
+			name: "reports a failed sub-agent",
+			finish: func(subAgentID string) eventbus.Event {
+				return events.AgentErrorEvent{AgentID: subAgentID, Error: "model unavailable"}
+			},
+			want: "Error: Sub-agent failed: model unavailable",
+		},
+		{
+			name:    "refuses nested sub-agents",
+			agentID: utils.CreateSubAgentID(utils.CreatePrimaryAgentID()),
+			want:    "Error: Tool execution failed: sub-agents cannot create agents",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := eventbus.NewMemoryEventBus()
+			defer bus.Close()
+			if err := NewToolRuntime(bus).Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+
+			agentID := tt.agentID
+			if agentID == "" {
+				agentID = utils.CreatePrimaryAgentID()
+			}
+
+			created := make(chan events.AgentCreateEvent, 1)
+			err := eventbus.Subscribe(bus, events.AgentCreateEventName, AgentRuntimeQueueName, func(ctx context.Context, e events.AgentCreateEvent) {
+				created <- e
+				if err := bus.Emit(tt.finish(e.AgentID)); err != nil {
+					t.Errorf("Emit: %v", err)
+				}
+			})
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			results := make(chan events.ToolsExecResultsEvent, 1)
+			err = eventbus.SubscribeBroadcast(bus, events.ToolExecResultsEventName, "", func(ctx context.Context, e events.ToolsExecResultsEvent) {
+				results <- e
+			})
+			if err != nil {
+				t.Fatalf("SubscribeBroadcast: %v", err)
+			}
+
+			err = bus.Emit(events.ToolsExecRequestEvent{
+				AgentID: agentID,
+				ToolCalls: []events.ToolCall{{
+					AgentID:    agentID,
+					ToolName:   handlers.CreateAgentToolName,
+					ToolCallID: "call-1",
+					Arguments:  map[string]any{"task": "add 40 and 2", "tools": []any{"calculator"}},
+				}},
+			})
+			if err != nil {
+				t.Fatalf("Emit: %v", err)
+			}
+
+			select {
+			case e := <-results:
+				if len(e.ToolResults) != 1 || e.ToolResults[0].Result != tt.want {
+					t.Fatalf("tool results %+v, want one with %q", e.ToolResults, tt.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("create_agent call did not return")
+			}
+
+			if tt.finish == nil {
+				return
+			}
+			e := <-created
+			if primary, err := utils.GetPrimaryAgentID(e.AgentID); err != nil || primary != agentID {
+				t.Errorf("created agent %s, want a sub-agent of %s", e.AgentID, agentID)
+			}
+			if len(e.ToolSchemas) != 1 || e.ToolSchemas[0].Name != "calculator" {
+				t.Errorf("sub-agent tools %+v, want the calculator", e.ToolSchemas)
+			}
+		})
+	}
+}
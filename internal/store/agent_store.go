@@ -1,125 +1,155 @@
 package store
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
 )
 
-type AgentData struct {
-	AgentID      string                    `json:"agent_id"`
-	Task         string                    `json:"task"`
-	SystemPrompt string                    `json:"system_prompt"`
-	ToolSchemas  []llminterface.ToolSchema `json:"tool_schemas"`
-	Messages     []llminterface.Message    `json:"messages"`
-}
+const (
+	agentLockTTL = 30 * time.Second
+	agentDataTTL = 12 * time.Hour
+)
 
-type AgentStore struct {
-	redis *RedisClient
+// AgentData with a SessionExpiresAt belongs to a session: it is kept after
+// each turn so that follow-up messages can continue the conversation.
+type AgentData struct {
+	AgentID          string                    `json:"agent_id"`
+	Task             string                    `json:"task"`
+	SystemPrompt     string                    `json:"system_prompt"`
+	ToolSchemas      []llminterface.ToolSchema `json:"tool_schemas"`
+	Messages         []llminterface.Message    `json:"messages"`
+	CreatedAt        time.Time                 `json:"created_at"`
+	Usage            AgentUsage                `json:"usage"`
+	SubAgentIDs      []string                  `json:"sub_agent_ids,omitempty"`
+	SessionExpiresAt *time.Time                `json:"session_expires_at,omitempty"`
+	PendingToolCalls []PendingToolCall         `json:"pending_tool_calls,omitempty"`
+	ToolCallsOnHold  bool                      `json:"tool_calls_on_hold,omitempty"`
+	Limits           llminterface.AgentLimits  `json:"limits"`
+	ModelParams      llminterface.ModelParams  `json:"model_params"`
 }
 
-func (as *AgentStore) agentDataKey(agentID string) string {
-	return fmt.Sprintf("%s:data", agentID)
+func (a *AgentData) IsSession() bool {
+	return a.SessionExpiresAt != nil
 }
 
-func (as *AgentStore) agentConversationKey(agentID string) string {
-	return fmt.Sprintf("%s:conversation", agentID)
-}
+const (
+	ToolCallApproved = "approved"
+	ToolCallRejected = "rejected"
+)
 
-func NewAgentStore(redisURL string) (*AgentStore, error) {
-	redisClient, err := NewRedisClient(redisURL)
-	if err != nil {
-		return nil, err
-	}
-	return &AgentStore{
-		redis: redisClient,
-	}, nil
+// PendingToolCall is a tool call held back while the agent waits for a
+// person, either to approve it or, for a Question, to answer it. Calls that
+// need neither wait alongside the others so that the whole batch runs
+// together.
+type PendingToolCall struct {
+	ToolCallID       string         `json:"tool_call_id"`
+	ToolName         string         `json:"tool_name"`
+	Arguments        map[string]any `json:"arguments"`
+	RequiresApproval bool           `json:"requires_approval"`
+	Decision         string         `json:"decision,omitempty"`
+	Reason           string         `json:"reason,omitempty"`
+	Edited           bool           `json:"edited,omitempty"`
+	Question         string         `json:"question,omitempty"`
+	Answer           string         `json:"answer,omitempty"`
 }
 
-func (as *AgentStore) SetAgentData(agentID string, agentData *AgentData) error {
-	data, err := json.Marshal(agentData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal agent data: %w", err)
-	}
-
-	if err := as.redis.Set(as.agentDataKey(agentID), string(data), 12*time.Hour); err != nil {
-		return fmt.Errorf("failed to store agent data: %w", err)
-	}
-
-	return nil
+func (c *PendingToolCall) IsQuestion() bool {
+	return c.Question != ""
 }
 
-func (as *AgentStore) GetAgentData(agentID string) (*AgentData, error) {
-	data, err := as.redis.Get(as.agentDataKey(agentID))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get agent data: %w", err)
-	}
-
-	var agent AgentData
-	if err := json.Unmarshal([]byte(data), &agent); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal agent data: %w", err)
+// HeldToolCallsResolved reports whether every held call that needs approval
+// has been approved or rejected and every question has been answered.
+func (a *AgentData) HeldToolCallsResolved() bool {
+	for _, call := range a.PendingToolCalls {
+		if call.RequiresApproval && call.Decision == "" {
+			return false
+		}
+		if call.IsQuestion() && call.Answer == "" {
+			return false
+		}
 	}
-
-	return &agent, nil
+	return true
 }
 
-func (as *AgentStore) SetConversation(agentID string, messages []llminterface.Message) error {
-	conversationKey := as.agentConversationKey(agentID)
-
-	// Convert []Message to JSON
-	messagesJSON, err := json.Marshal(messages)
-	if err != nil {
-		return fmt.Errorf("failed to marshal messages: %w", err)
+// HeldTaskStatus is the status of the task while the agent holds its tool
+// calls: waiting for approval while any call awaits a decision, then waiting
+// for input while any question is unanswered, and running once every held
+// call is resolved.
+func (a *AgentData) HeldTaskStatus() string {
+	status := TaskStatusRunning
+	for _, call := range a.PendingToolCalls {
+		if call.RequiresApproval && call.Decision == "" {
+			return TaskStatusWaitingForApproval
+		}
+		if call.IsQuestion() && call.Answer == "" {
+			status = TaskStatusWaitingForInput
+		}
 	}
-
-	return as.redis.Set(conversationKey, messagesJSON, 0)
+	return status
 }
 
-func (as *AgentStore) GetConversation(agentID string) ([]llminterface.Message, error) {
-	conversationKey := as.agentConversationKey(agentID)
-	result, err := as.redis.Get(conversationKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get conversation: %w", err)
+// LimitReached reports which of the agent's limits keeps it from acting on
+// its latest tool calls, or "" when none does.
+func (a *AgentData) LimitReached() string {
+	if a.Limits.MaxLLMCalls > 0 && a.Usage.LLMCalls >= a.Limits.MaxLLMCalls {
+		return fmt.Sprintf("agent reached its limit of %d LLM calls", a.Limits.MaxLLMCalls)
 	}
-
-	var messages []llminterface.Message
-	if err := json.Unmarshal([]byte(result), &messages); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal messages: %w", err)
+	if a.Limits.MaxToolCalls > 0 && a.Usage.ToolCalls > a.Limits.MaxToolCalls {
+		return fmt.Sprintf("agent exceeded its limit of %d tool calls", a.Limits.MaxToolCalls)
 	}
-
-	return messages, nil
+	return ""
 }
 
-func (as *AgentStore) Exists(agentID string) (bool, error) {
-	exists, err := as.redis.Exists(as.agentDataKey(agentID))
-	if err != nil {
-		return false, fmt.Errorf("failed to check if agent exists: %w", err)
-	}
-	return exists > 0, nil
+type AgentUsage struct {
+	LLMCalls  int `json:"llm_calls"`
+	ToolCalls int `json:"tool_calls"`
 }
 
-func (as *AgentStore) Delete(agentID string) error {
-	if err := as.redis.Del(as.agentDataKey(agentID)); err != nil {
-		return fmt.Errorf("failed to delete agent data: %w", err)
-	}
-
-	if err := as.redis.Del(as.agentConversationKey(agentID)); err != nil {
-		return fmt.Errorf("failed to delete agent conversation: %w", err)
+func (u AgentUsage) Add(other AgentUsage) AgentUsage {
+	return AgentUsage{
+		LLMCalls:  u.LLMCalls + other.LLMCalls,
+		ToolCalls: u.ToolCalls + other.ToolCalls,
 	}
+}
 
-	return nil
+type Conversation struct {
+	Messages []llminterface.Message `json:"messages"`
+	Version  int64                  `json:"version"`
 }
 
-func (as *AgentStore) CreateAgent(agentData *AgentData) error {
-	return as.SetAgentData(agentData.AgentID, agentData)
+type ConversationStore interface {
+	GetConversation(agentID string) (*Conversation, error)
+	GetMessages(agentID string, start, stop int64) ([]llminterface.Message, error)
+	AppendConversation(agentID string, version int64, messages ...llminterface.Message) (int64, error)
+	ReplaceConversation(agentID string, version int64, messages []llminterface.Message) (int64, error)
 }
 
-func (as *AgentStore) GetAgent(agentID string) (*AgentData, error) {
-	return as.GetAgentData(agentID)
+type AgentStore interface {
+	ConversationStore
+	CreateAgent(agentData *AgentData) error
+	GetAgent(agentID string) (*AgentData, error)
+	UpdateAgent(agentData *AgentData) error
+	Exists(agentID string) (bool, error)
+	Delete(agentID string) error
+	Lock(ctx context.Context, agentID string) (func(), error)
+	// ExpiredSessions lists the agents whose session expired before now.
+	ExpiredSessions(now time.Time) ([]string, error)
+	Close() error
 }
 
-func (as *AgentStore) Close() error {
-	return as.redis.Close()
+// messageRange resolves GetMessages bounds the way Redis LRANGE does: both are
+// inclusive and negative indexes count from the end. ok is false when the
+// range is empty.
+func messageRange(length, start, stop int64) (from, to int64, ok bool) {
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	stop = min(stop, length-1)
+	return start, stop, start <= stop
 }
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+func testMessages(prefix string, n int) []llminterface.Message {
+	messages := make([]llminterface.Message, n)
+	for i := range messages {
+		messages[i] = llminterface.NewUserMessage(fmt.Sprintf("%s %d", prefix, i))
+	}
+	return messages
+}
+
+func TestConversationWritesCheckVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		agentStore := openStore(t, cfg, OpenAgentStore)
+		agentID := testID("agent")
+
+		if _, err := agentStore.GetConversation(agentID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetConversation before any write: got %v, want ErrNotFound", err)
+		}
+
+		first := testMessages("first", 2)
+		second := testMessages("second", 1)
+		replaced := testMessages("replaced", 3)
+		steps := []struct {
+			name     string
+			replace  bool
+			version  int64
+			messages []llminterface.Message
+			want     int64
+			err      error
+		}{
+			{"append creates the conversation", false, 0, first, 1, nil},
+			{"append from version 0 once it exists", false, 0, second, 0, ErrConversationConflict},
+			{"append from the current version", false, 1, second, 2, nil},
+			{"append from a stale version", false, 1, second, 0, ErrConversationConflict},
+			{"replace from a stale version", true, 1, replaced, 0, ErrConversationConflict},
+			{"replace from the current version", true, 2, replaced, 3, nil},
+			{"append no messages", false, 3, nil, 4, nil},
+		}
+		var want []llminterface.Message
+		for _, step := range steps {
+			var version int64
+			var err error
+			if step.replace {
+				version, err = agentStore.ReplaceConversation(agentID, step.version, step.messages)
+			} else {
+				version, err = agentStore.AppendConversation(agentID, step.version, step.messages...)
+			}
+			if !errors.Is(err, step.err) || version != step.want {
+				t.Fatalf("%s: got version %d, error %v; want %d, %v", step.name, version, err, step.want, step.err)
+			}
+			if err == nil {
+				if step.replace {
+					want = nil
+				}
+				want = append(want, step.messages...)
+			}
+
+			conversation, err := agentStore.GetConversation(agentID)
+			if err != nil {
+				t.Fatalf("%s: GetConversation: %v", step.name, err)
+			}
+			if !reflect.DeepEqual(conversation.Messages, want) {
+				t.Fatalf("%s: conversation holds %v, want %v", step.name, conversation.Messages, want)
+			}
+		}
+	})
+}
+
+func TestConversationWritesRaceOnVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		agentStore := openStore(t, cfg, OpenAgentStore)
+		agentID := testID("agent")
+
+		if _, err := agentStore.AppendConversation(agentID, 0, testMessages("start", 1)...); err != nil {
+			t.Fatalf("AppendConversation: %v", err)
+		}
+
+		const writers = 8
+		var wg sync.WaitGroup
+		results := make(chan error, writers)
+		for i := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := agentStore.AppendConversation(agentID, 1, testMessages(fmt.Sprintf("writer %d", i), 2)...)
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for err := range results {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, ErrConversationConflict):
+				t.Fatalf("AppendConversation: %v", err)
+			}
+		}
+		if succeeded != 1 {
+			t.Fatalf("%d writers appended from the same version, want 1", succeeded)
+		}
+
+		conversation, err := agentStore.GetConversation(agentID)
+		if err != nil {
+			t.Fatalf("GetConversation: %v", err)
+		}
+		if conversation.Version != 2 || len(conversation.Messages) != 3 {
+			t.Fatalf("conversation at version %d with %d messages, want 2 with 3",
+				conversation.Version, len(conversation.Messages))
+		}
+	})
+}
+
+// The Redis scripts push messages in chunks to stay under Lua's unpack limit.
+func TestConversationWritesManyMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		agentStore := openStore(t, cfg, OpenAgentStore)
+		agentID := testID("agent")
+
+		appended := testMessages("appended", 2500)
+		if _, err := agentStore.AppendConversation(agentID, 0, appended...); err != nil {
+			t.Fatalf("AppendConversation: %v", err)
+		}
+		replaced := testMessages("replaced", 1001)
+		if _, err := agentStore.ReplaceConversation(agentID, 1, replaced); err != nil {
+			t.Fatalf("ReplaceConversation: %v", err)
+		}
+
+		conversation, err := agentStore.GetConversation(agentID)
+		if err != nil {
+			t.Fatalf("GetConversation: %v", err)
+		}
+		if !reflect.DeepEqual(conversation.Messages, replaced) {
+			t.Fatalf("conversation holds %d messages, want the %d replaced ones", len(conversation.Messages), len(replaced))
+		}
+	})
+}
+
+// GetMessages bounds follow Redis LRANGE, which every backend mirrors.
+func TestGetMessagesRanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		agentStore := openStore(t, cfg, OpenAgentStore)
+		agentID := testID("agent")
+
+		messages := testMessages("message", 5)
+		if _, err := agentStore.AppendConversation(agentID, 0, messages...); err != nil {
+			t.Fatalf("AppendConversation: %v", err)
+		}
+
+		tests := []struct {
+			name        string
+			start, stop int64
+			want        []llminterface.Message
+		}{
+			{"everything", 0, -1, messages},
+			{"inner range", 1, 2, messages[1:3]},
+			{"single message", 3, 3, messages[3:4]},
+			{"from the end", -2, -1, messages[3:]},
+			{"stop past the end", 3, 10, messages[3:]},
+			{"start before the beginning", -10, 1, messages[:2]},
+			{"start after stop", 4, 2, []llminterface.Message{}},
+			{"start past the end", 5, 10, []llminterface.Message{}},
+		}
+		for _, tt := range tests {
+			got, err := agentStore.GetMessages(agentID, tt.start, tt.stop)
+			if err != nil {
+				t.Fatalf("%s: GetMessages: %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: GetMessages(%d, %d) = %v, want %v", tt.name, tt.start, tt.stop, got, tt.want)
+			}
+		}
+
+		got, err := agentStore.GetMessages(testID("agent"), 0, -1)
+		if err != nil {
+			t.Fatalf("GetMessages of a missing conversation: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("missing conversation has messages %v", got)
+		}
+	})
+}
+
+func TestDeleteRemovesConversation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		agentStore := openStore(t, cfg, OpenAgentStore)
+		agentID := testID("agent")
+
+		if err := agentStore.CreateAgent(&AgentData{AgentID: agentID}); err != nil {
+			t.Fatalf("CreateAgent: %v", err)
+		}
+		if _, err := agentStore.AppendConversation(agentID, 0, testMessages("message", 2)...); err != nil {
+			t.Fatalf("AppendConversation: %v", err)
+		}
+		if err := agentStore.Delete(agentID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+
+		if _, err := agentStore.GetConversation(agentID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetConversation after Delete: got %v, want ErrNotFound", err)
+		}
+		// A new conversation under the same ID starts again from version 0.
+		if version, err := agentStore.AppendConversation(agentID, 0, testMessages("again", 1)...); err != nil || version != 1 {
+			t.Errorf("AppendConversation after Delete: got version %d, error %v; want 1", version, err)
+		}
+	})
+}
+
+func TestHeldTaskStatus(t *testing.T) {
+	approval := PendingToolCall{ToolCallID: "call-1", ToolName: "delete_file", RequiresApproval: true}
+	question := PendingToolCall{ToolCallID: "call-2", ToolName: "ask_user", Question: "Which file?"}
+	plain := PendingToolCall{ToolCallID: "call-3", ToolName: "calculator"}
+	approved := approval
+	approved.Decision = ToolCallApproved
+	answered := question
+	answered.Answer = "notes.txt"
+
+	tests := []struct {
+		name  string
+		calls []PendingToolCall
+		want  string
+	}{
+		{"nothing held", nil, TaskStatusRunning},
+		{"approval pending", []PendingToolCall{approval, plain}, TaskStatusWaitingForApproval},
+		{"question open", []PendingToolCall{question, plain}, TaskStatusWaitingForInput},
+		{"approval before question", []PendingToolCall{question, approval}, TaskStatusWaitingForApproval},
+		{"question after approval", []PendingToolCall{question, approved}, TaskStatusWaitingForInput},
+		{"approval after answer", []PendingToolCall{answered, approval}, TaskStatusWaitingForApproval},
+		{"all resolved", []PendingToolCall{answered, approved, plain}, TaskStatusRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &AgentData{PendingToolCalls: tt.calls}
+			if got := agent.HeldTaskStatus(); got != tt.want {
+				t.Fatalf("HeldTaskStatus = %s, want %s", got, tt.want)
+			}
+			if resolved := agent.HeldToolCallsResolved(); resolved != (tt.want == TaskStatusRunning) {
+				t.Fatalf("HeldToolCallsResolved = %v with status %s", resolved, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisMigratesLegacyConversation(t *testing.T) {
+	url := os.Getenv("STORE_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("STORE_TEST_REDIS_URL is not set")
+	}
+	agentStore := openStore(t, Config{Backend: BackendRedis, RedisURL: url}, OpenAgentStore).(*RedisAgentStore)
+	agentID := testID("agent")
+
+	legacy := testMessages("legacy", 3)
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := agentStore.redis.Set(agentStore.agentConversationKey(agentID), string(data), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	conversation, err := agentStore.GetConversation(agentID)
+	if err != nil {
+		t.Fatalf("GetConversation: %v", err)
+	}
+	if conversation.Version != 1 || !reflect.DeepEqual(conversation.Messages, legacy) {
+		t.Fatalf("GetConversation = version %d with %v, want version 1 with %v", conversation.Version, conversation.Messages, legacy)
+	}
+	if exists, err := agentStore.redis.Exists(agentStore.agentConversationKey(agentID)); err != nil || exists != 0 {
+		t.Fatalf("legacy key still exists (err %v)", err)
+	}
+
+	more := testMessages("more", 1)
+	version, err := agentStore.AppendConversation(agentID, conversation.Version, more...)
+	if err != nil {
+		t.Fatalf("AppendConversation after migration: %v", err)
+	}
+	conversation, err = agentStore.GetConversation(agentID)
+	if err != nil {
+		t.Fatalf("GetConversation: %v", err)
+	}
+	if want := append(legacy, more...); conversation.Version != version || !reflect.DeepEqual(conversation.Messages, want) {
+		t.Fatalf("GetConversation = version %d with %v, want version %d with %v", conversation.Version, conversation.Messages, version, want)
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+// ArchivedAgent is the final state of one agent, written when it finishes or
+// fails and before its live data is deleted.
+type ArchivedAgent struct {
+	AgentID        string                 `json:"agent_id"`
+	PrimaryAgentID string                 `json:"primary_agent_id"`
+	Task           string                 `json:"task"`
+	SystemPrompt   string                 `json:"system_prompt,omitempty"`
+	Status         string                 `json:"status"` // a terminal task status
+	Result         string                 `json:"result,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	Messages       []llminterface.Message `json:"messages"`
+	Usage          AgentUsage             `json:"usage"`
+	CreatedAt      time.Time              `json:"created_at"`
+	FinishedAt     time.Time              `json:"finished_at"`
+}
+
+// ArchivedTask is a primary agent together with every sub-agent it spawned.
+type ArchivedTask struct {
+	AgentID    string          `json:"agent_id"`
+	Task       string          `json:"task"`
+	Status     string          `json:"status"`
+	Result     string          `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	DurationMS int64           `json:"duration_ms"`
+	Usage      AgentUsage      `json:"usage"`
+	Agent      ArchivedAgent   `json:"agent"`
+	SubAgents  []ArchivedAgent `json:"sub_agents"`
+}
+
+type ArchiveStore interface {
+	ArchiveAgent(agent *ArchivedAgent) error
+	GetArchivedTask(agentID string) (*ArchivedTask, error)
+	Close() error
+}
+
+func assembleArchivedTask(agentID string, agents []ArchivedAgent) (*ArchivedTask, error) {
+	var task *ArchivedTask
+	var subAgents []ArchivedAgent
+	usage := AgentUsage{}
+
+	for _, agent := range agents {
+		usage = usage.Add(agent.Usage)
+		if agent.AgentID != agentID {
+			subAgents = append(subAgents, agent)
+			continue
+		}
+		task = &ArchivedTask{
+			AgentID:    agent.AgentID,
+			Task:       agent.Task,
+			Status:     agent.Status,
+			Result:     agent.Result,
+			Error:      agent.Error,
+			CreatedAt:  agent.CreatedAt,
+			FinishedAt: agent.FinishedAt,
+			DurationMS: agent.FinishedAt.Sub(agent.CreatedAt).Milliseconds(),
+			Agent:      agent,
+		}
+	}
+
+	if task == nil {
+		return nil, fmt.Errorf("failed to get archived task: %w", ErrNotFound)
+	}
+
+	sort.Slice(subAgents, func(i, j int) bool {
+		return subAgents[i].CreatedAt.Before(subAgents[j].CreatedAt)
+	})
+	task.SubAgents = subAgents
+	if task.SubAgents == nil {
+		task.SubAgents = []ArchivedAgent{}
+	}
+	task.Usage = usage
+	return task, nil
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Backend string
+
+const (
+	BackendRedis    Backend = "redis"
+	BackendPostgres Backend = "postgres"
+	BackendMemory   Backend = "memory"
+	BackendFile     Backend = "file"
+	BackendNone     Backend = "none"
+)
+
+type Config struct {
+	Backend     Backend
+	RedisURL    string
+	PostgresURL string
+	Archive     Backend
+	ArchiveDir  string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Backend: BackendRedis,
+		Archive: BackendNone,
+	}
+}
+
+// ConfigFromEnv reads STORE_BACKEND and the connection URL it needs. Without
+// STORE_BACKEND the Redis backend is used, as it was before backends were
+// selectable. ARCHIVE_BACKEND picks where finished tasks are kept once their
+// live data expires; archiving is off unless it is set.
+func ConfigFromEnv() (Config, error) {
+	return ConfigFromEnvWithDefaults(DefaultConfig())
+}
+
+func ConfigFromEnvWithDefaults(cfg Config) (Config, error) {
+	cfg.RedisURL = os.Getenv("REDIS_URL")
+	cfg.PostgresURL = os.Getenv("POSTGRES_URL")
+	cfg.ArchiveDir = os.Getenv("ARCHIVE_DIR")
+
+	if v := os.Getenv("STORE_BACKEND"); v != "" {
+		cfg.Backend = Backend(strings.ToLower(v))
+	}
+	if v := os.Getenv("ARCHIVE_BACKEND"); v != "" {
+		cfg.Archive = Backend(strings.ToLower(v))
+	}
+
+	switch cfg.Backend {
+	case BackendRedis:
+		if cfg.RedisURL == "" {
+			return cfg, fmt.Errorf("REDIS_URL environment variable is required for the %s store backend", cfg.Backend)
+		}
+	case BackendPostgres:
+		if cfg.PostgresURL == "" {
+			return cfg, fmt.Errorf("POSTGRES_URL environment variable is required for the %s store backend", cfg.Backend)
+		}
+	case BackendMemory:
+	default:
+		return cfg, fmt.Errorf("invalid STORE_BACKEND %q (expected redis, postgres or memory)", cfg.Backend)
+	}
+
+	switch cfg.Archive {
+	case BackendFile:
+		if cfg.ArchiveDir == "" {
+			return cfg, fmt.Errorf("ARCHIVE_DIR environment variable is required for the %s archive backend", cfg.Archive)
+		}
+	case BackendPostgres:
+		if cfg.PostgresURL == "" {
+			return cfg, fmt.Errorf("POSTGRES_URL environment variable is required for the %s archive backend", cfg.Archive)
+		}
+	case BackendMemory, BackendNone:
+	default:
+		return cfg, fmt.Errorf("invalid ARCHIVE_BACKEND %q (expected postgres, file, memory or none)", cfg.Archive)
+	}
+
+	return cfg, nil
+}
+
+func OpenAgentStore(cfg Config) (AgentStore, error) {
+	switch cfg.Backend {
+	case BackendRedis:
+		return NewRedisAgentStore(cfg.RedisURL)
+	case BackendPostgres:
+		client, err := openSharedPostgresClient(cfg.PostgresURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgresAgentStore(client), nil
+	case BackendMemory:
+		return NewMemoryAgentStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+func OpenTaskStore(cfg Config) (TaskStore, error) {
+	switch cfg.Backend {
+	case BackendRedis:
+		return NewRedisTaskStore(cfg.RedisURL)
+	case BackendPostgres:
+		client, err := openSharedPostgresClient(cfg.PostgresURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgresTaskStore(client), nil
+	case BackendMemory:
+		return NewMemoryTaskStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+func OpenScheduleStore(cfg Config) (ScheduleStore, error) {
+	switch cfg.Backend {
+	case BackendRedis:
+		return NewRedisScheduleStore(cfg.RedisURL)
+	case BackendPostgres:
+		client, err := openSharedPostgresClient(cfg.PostgresURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgresScheduleStore(client), nil
+	case BackendMemory:
+		return NewMemoryScheduleStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+func OpenProfileStore(cfg Config) (ProfileStore, error) {
+	switch cfg.Backend {
+	case BackendRedis:
+		return NewRedisProfileStore(cfg.RedisURL)
+	case BackendPostgres:
+		client, err := openSharedPostgresClient(cfg.PostgresURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgresProfileStore(client), nil
+	case BackendMemory:
+		return NewMemoryProfileStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+// OpenArchiveStore returns a nil store when archiving is disabled.
+func OpenArchiveStore(cfg Config) (ArchiveStore, error) {
+	switch cfg.Archive {
+	case BackendPostgres:
+		client, err := openSharedPostgresClient(cfg.PostgresURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgresArchiveStore(client), nil
+	case BackendFile:
+		return NewFileArchiveStore(cfg.ArchiveDir)
+	case BackendMemory:
+		return NewMemoryArchiveStore(), nil
+	case BackendNone, "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown archive backend %q", cfg.Archive)
+}
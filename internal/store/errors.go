@@ -0,0 +1,9 @@
+package store
+
+import "errors"
+
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrAlreadyExists        = errors.New("already exists")
+	ErrConversationConflict = errors.New("conversation was modified concurrently")
+)
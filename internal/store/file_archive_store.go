@@ -0,0 +1,94 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileArchiveStore keeps one JSON file per agent, grouped in a directory per
+// primary agent, so a task and its sub-agents can be read back together.
+type FileArchiveStore struct {
+	dir string
+}
+
+func NewFileArchiveStore(dir string) (*FileArchiveStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &FileArchiveStore{
+		dir: dir,
+	}, nil
+}
+
+func (fs *FileArchiveStore) taskDir(primaryAgentID string) string {
+	return filepath.Join(fs.dir, archiveFileName(primaryAgentID))
+}
+
+func archiveFileName(agentID string) string {
+	return strings.ReplaceAll(agentID, ":", "_")
+}
+
+func (fs *FileArchiveStore) ArchiveAgent(agent *ArchivedAgent) error {
+	data, err := json.MarshalIndent(agent, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal archived agent: %w", err)
+	}
+
+	dir := fs.taskDir(agent.PrimaryAgentID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to archive agent: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to archive agent: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to archive agent: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to archive agent: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, archiveFileName(agent.AgentID)+".json")); err != nil {
+		return fmt.Errorf("failed to archive agent: %w", err)
+	}
+	return nil
+}
+
+func (fs *FileArchiveStore) GetArchivedTask(agentID string) (*ArchivedTask, error) {
+	paths, err := filepath.Glob(filepath.Join(fs.taskDir(agentID), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived task: %w", err)
+	}
+
+	agents := make([]ArchivedAgent, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get archived task: %w", err)
+		}
+
+		var agent ArchivedAgent
+		if err := json.Unmarshal(data, &agent); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal archived agent: %w", err)
+		}
+		agents = append(agents, agent)
+	}
+
+	return assembleArchivedTask(agentID, agents)
+}
+
+func (fs *FileArchiveStore) Close() error {
+	return nil
+}
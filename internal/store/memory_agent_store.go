@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+type MemoryAgentStore struct {
+	mu            sync.RWMutex
+	agents        map[string]AgentData
+	conversations map[string]Conversation
+	locks         map[string]*memoryLock
+}
+
+type memoryLock struct {
+	held    chan struct{}
+	waiters int
+}
+
+func NewMemoryAgentStore() *MemoryAgentStore {
+	return &MemoryAgentStore{
+		agents:        make(map[string]AgentData),
+		conversations: make(map[string]Conversation),
+		locks:         make(map[string]*memoryLock),
+	}
+}
+
+func (ms *MemoryAgentStore) CreateAgent(agentData *AgentData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.agents[agentData.AgentID] = *agentData
+	return nil
+}
+
+func (ms *MemoryAgentStore) GetAgent(agentID string) (*AgentData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	agent, ok := ms.agents[agentID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get agent data: %w", ErrNotFound)
+	}
+	return &agent, nil
+}
+
+func (ms *MemoryAgentStore) UpdateAgent(agentData *AgentData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.agents[agentData.AgentID]; !ok {
+		return fmt.Errorf("failed to update agent data: %w", ErrNotFound)
+	}
+	ms.agents[agentData.AgentID] = *agentData
+	return nil
+}
+
+func (ms *MemoryAgentStore) GetConversation(agentID string) (*Conversation, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	conversation, ok := ms.conversations[agentID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get conversation: %w", ErrNotFound)
+	}
+	return &Conversation{
+		Messages: append([]llminterface.Message(nil), conversation.Messages...),
+		Version:  conversation.Version,
+	}, nil
+}
+
+func (ms *MemoryAgentStore) GetMessages(agentID string, start, stop int64) ([]llminterface.Message, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	messages := ms.conversations[agentID].Messages
+	from, to, ok := messageRange(int64(len(messages)), start, stop)
+	if !ok {
+		return []llminterface.Message{}, nil
+	}
+	return append([]llminterface.Message(nil), messages[from:to+1]...), nil
+}
+
+func (ms *MemoryAgentStore) AppendConversation(agentID string, version int64, messages ...llminterface.Message) (int64, error) {
+	return ms.writeConversation(agentID, version, func(current []llminterface.Message) []llminterface.Message {
+		return append(append([]llminterface.Message(nil), current...), messages...)
+	})
+}
+
+func (ms *MemoryAgentStore) ReplaceConversation(agentID string, version int64, messages []llminterface.Message) (int64, error) {
+	return ms.writeConversation(agentID, version, func([]llminterface.Message) []llminterface.Message {
+		return append([]llminterface.Message(nil), messages...)
+	})
+}
+
+func (ms *MemoryAgentStore) writeConversation(agentID string, version int64, update func([]llminterface.Message) []llminterface.Message) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current := ms.conversations[agentID]
+	if current.Version != version {
+		return 0, fmt.Errorf("failed to update conversation: %w", ErrConversationConflict)
+	}
+
+	ms.conversations[agentID] = Conversation{
+		Messages: update(current.Messages),
+		Version:  version + 1,
+	}
+	return version + 1, nil
+}
+
+func (ms *MemoryAgentStore) Exists(agentID string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, ok := ms.agents[agentID]
+	return ok, nil
+}
+
+func (ms *MemoryAgentStore) Delete(agentID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.agents, agentID)
+	delete(ms.conversations, agentID)
+	return nil
+}
+
+func (ms *MemoryAgentStore) ExpiredSessions(now time.Time) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var agentIDs []string
+	for agentID, agent := range ms.agents {
+		if agent.IsSession() && agent.SessionExpiresAt.Before(now) {
+			agentIDs = append(agentIDs, agentID)
+		}
+	}
+	return agentIDs, nil
+}
+
+func (ms *MemoryAgentStore) Lock(ctx context.Context, agentID string) (func(), error) {
+	ms.mu.Lock()
+	lock, ok := ms.locks[agentID]
+	if !ok {
+		lock = &memoryLock{held: make(chan struct{}, 1)}
+		ms.locks[agentID] = lock
+	}
+	lock.waiters++
+	ms.mu.Unlock()
+
+	release := func() {
+		ms.mu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(ms.locks, agentID)
+		}
+		ms.mu.Unlock()
+	}
+
+	select {
+	case lock.held <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, fmt.Errorf("failed to acquire agent lock: %w", ctx.Err())
+	}
+
+	return func() {
+		<-lock.held
+		release()
+	}, nil
+}
+
+func (ms *MemoryAgentStore) Close() error {
+	return nil
+}
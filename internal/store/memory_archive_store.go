@@ -0,0 +1,42 @@
+package store
+
+import (
+	"sync"
+)
+
+type MemoryArchiveStore struct {
+	mu     sync.RWMutex
+	agents map[string][]ArchivedAgent
+}
+
+func NewMemoryArchiveStore() *MemoryArchiveStore {
+	return &MemoryArchiveStore{
+		agents: make(map[string][]ArchivedAgent),
+	}
+}
+
+func (ms *MemoryArchiveStore) ArchiveAgent(agent *ArchivedAgent) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	archived := ms.agents[agent.PrimaryAgentID]
+	for i := range archived {
+		if archived[i].AgentID == agent.AgentID {
+			archived[i] = *agent
+			return nil
+		}
+	}
+	ms.agents[agent.PrimaryAgentID] = append(archived, *agent)
+	return nil
+}
+
+func (ms *MemoryArchiveStore) GetArchivedTask(agentID string) (*ArchivedTask, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return assembleArchivedTask(agentID, ms.agents[agentID])
+}
+
+func (ms *MemoryArchiveStore) Close() error {
+	return nil
+}
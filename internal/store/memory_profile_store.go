@@ -0,0 +1,103 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+type MemoryProfileStore struct {
+	mu sync.RWMutex
+	// Versions of each profile, oldest first.
+	profiles map[string][]ProfileData
+}
+
+func NewMemoryProfileStore() *MemoryProfileStore {
+	return &MemoryProfileStore{
+		profiles: make(map[string][]ProfileData),
+	}
+}
+
+func (ms *MemoryProfileStore) CreateProfile(profile *ProfileData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.profiles[profile.Name]; ok {
+		return fmt.Errorf("failed to create profile: %w", ErrAlreadyExists)
+	}
+	profile.Version = 1
+	ms.profiles[profile.Name] = []ProfileData{*profile}
+	return nil
+}
+
+func (ms *MemoryProfileStore) UpdateProfile(profile *ProfileData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	versions, ok := ms.profiles[profile.Name]
+	if !ok {
+		return fmt.Errorf("failed to update profile: %w", ErrNotFound)
+	}
+	profile.Version = len(versions) + 1
+	ms.profiles[profile.Name] = append(versions, *profile)
+	return nil
+}
+
+func (ms *MemoryProfileStore) GetProfile(name string, version int) (*ProfileData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	versions := ms.profiles[name]
+	if version == 0 {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return nil, fmt.Errorf("failed to get profile: %w", ErrNotFound)
+	}
+	profile := versions[version-1]
+	return &profile, nil
+}
+
+func (ms *MemoryProfileStore) ListProfiles() ([]ProfileData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	profiles := make([]ProfileData, 0, len(ms.profiles))
+	for _, versions := range ms.profiles {
+		profiles = append(profiles, versions[len(versions)-1])
+	}
+	sortProfiles(profiles)
+	return profiles, nil
+}
+
+func (ms *MemoryProfileStore) ListProfileVersions(name string) ([]ProfileData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	versions, ok := ms.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to list profile versions: %w", ErrNotFound)
+	}
+	versions = slices.Clone(versions)
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+func (ms *MemoryProfileStore) DeleteProfile(name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.profiles[name]; !ok {
+		return fmt.Errorf("failed to delete profile: %w", ErrNotFound)
+	}
+	delete(ms.profiles, name)
+	return nil
+}
+
+func (ms *MemoryProfileStore) HealthCheck() error {
+	return nil
+}
+
+func (ms *MemoryProfileStore) Close() error {
+	return nil
+}
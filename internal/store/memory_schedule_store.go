@@ -0,0 +1,133 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+type MemoryScheduleStore struct {
+	mu        sync.RWMutex
+	schedules map[string]ScheduleData
+	runs      map[string][]ScheduleRun
+}
+
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{
+		schedules: make(map[string]ScheduleData),
+		runs:      make(map[string][]ScheduleRun),
+	}
+}
+
+func (ms *MemoryScheduleStore) CreateSchedule(schedule *ScheduleData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.schedules[schedule.ScheduleID] = *schedule
+	return nil
+}
+
+func (ms *MemoryScheduleStore) GetSchedule(scheduleID string) (*ScheduleData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	schedule, ok := ms.schedules[scheduleID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get schedule: %w", ErrNotFound)
+	}
+	return &schedule, nil
+}
+
+func (ms *MemoryScheduleStore) ListSchedules() ([]ScheduleData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	schedules := make([]ScheduleData, 0, len(ms.schedules))
+	for _, schedule := range ms.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sortSchedules(schedules)
+	return schedules, nil
+}
+
+func (ms *MemoryScheduleStore) UpdateSchedule(schedule *ScheduleData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.schedules[schedule.ScheduleID]; !ok {
+		return fmt.Errorf("failed to update schedule: %w", ErrNotFound)
+	}
+	ms.schedules[schedule.ScheduleID] = *schedule
+	return nil
+}
+
+func (ms *MemoryScheduleStore) DeleteSchedule(scheduleID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.schedules[scheduleID]; !ok {
+		return fmt.Errorf("failed to delete schedule: %w", ErrNotFound)
+	}
+	delete(ms.schedules, scheduleID)
+	delete(ms.runs, scheduleID)
+	return nil
+}
+
+func (ms *MemoryScheduleStore) ClaimScheduleRun(run ScheduleRun, next time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	schedule, ok := ms.schedules[run.ScheduleID]
+	if !ok || !claimScheduleRun(&schedule, run.ScheduledAt, next) {
+		return false, nil
+	}
+	ms.schedules[run.ScheduleID] = schedule
+
+	runs := append([]ScheduleRun{run}, ms.runs[run.ScheduleID]...)
+	ms.runs[run.ScheduleID] = runs[:min(len(runs), MaxScheduleRuns)]
+	return true, nil
+}
+
+func (ms *MemoryScheduleStore) UpdateScheduleRun(run ScheduleRun, startedAt time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	runs := ms.runs[run.ScheduleID]
+	i := slices.IndexFunc(runs, func(stored ScheduleRun) bool { return stored.AgentID == run.AgentID })
+	if i < 0 {
+		return false, nil
+	}
+	return updateScheduleRun(&runs[i], run, startedAt), nil
+}
+
+func (ms *MemoryScheduleStore) PendingScheduleRuns(startedBefore time.Time) ([]ScheduleRun, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var pending []ScheduleRun
+	for _, runs := range ms.runs {
+		for _, run := range runs {
+			if run.Pending && run.StartedAt.Before(startedBefore) {
+				pending = append(pending, run)
+			}
+		}
+	}
+	return pending, nil
+}
+
+func (ms *MemoryScheduleStore) ListScheduleRuns(scheduleID string, limit int) ([]ScheduleRun, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	runs := ms.runs[scheduleID]
+	return slices.Clone(runs[:min(len(runs), limit)]), nil
+}
+
+func (ms *MemoryScheduleStore) HealthCheck() error {
+	return nil
+}
+
+func (ms *MemoryScheduleStore) Close() error {
+	return nil
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type MemoryTaskStore struct {
+	mu              sync.RWMutex
+	tasks           map[string]TaskData
+	idempotencyKeys map[string]IdempotencyRecord
+	batches         map[string]BatchData
+}
+
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		tasks:           make(map[string]TaskData),
+		idempotencyKeys: make(map[string]IdempotencyRecord),
+		batches:         make(map[string]BatchData),
+	}
+}
+
+func (ms *MemoryTaskStore) CreateTask(spec TaskSpec) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.tasks[spec.AgentID] = newTask(spec)
+	return nil
+}
+
+func (ms *MemoryTaskStore) UpdateCallbackDelivery(agentID string, delivery CallbackDelivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	task, ok := ms.tasks[agentID]
+	if !ok {
+		return fmt.Errorf("failed to update callback delivery: %w", ErrNotFound)
+	}
+	if err := applyCallbackDelivery(&task, delivery); err != nil {
+		return fmt.Errorf("failed to update callback delivery: %w", err)
+	}
+	ms.tasks[agentID] = task
+	return nil
+}
+
+func (ms *MemoryTaskStore) UpdateTask(agentID string, update TaskUpdate) (*TaskData, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	task, ok := ms.tasks[agentID]
+	if !ok {
+		return nil, fmt.Errorf("failed to update task: %w", ErrNotFound)
+	}
+	if err := applyTaskUpdate(&task, update); err != nil {
+		return &task, fmt.Errorf("failed to update task: %w", err)
+	}
+	ms.tasks[agentID] = task
+	return &task, nil
+}
+
+func (ms *MemoryTaskStore) GetTask(agentID string) (*TaskData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	task, ok := ms.tasks[agentID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get task: %w", ErrNotFound)
+	}
+	return &task, nil
+}
+
+func (ms *MemoryTaskStore) ListTasks(filter TaskFilter) (*TaskPage, error) {
+	cursor, err := decodeTaskCursor(filter.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	ms.mu.RLock()
+	var tasks []TaskData
+	for _, task := range ms.tasks {
+		if filter.Matches(&task) && cursor.after(&task) {
+			tasks = append(tasks, task)
+		}
+	}
+	ms.mu.RUnlock()
+
+	sortTasks(tasks)
+	limit := filter.limit()
+	if len(tasks) > limit+1 {
+		tasks = tasks[:limit+1]
+	}
+	return newTaskPage(tasks, limit), nil
+}
+
+func (ms *MemoryTaskStore) ReserveIdempotencyKey(record IdempotencyRecord) (*IdempotencyRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if existing, ok := ms.idempotencyKeys[record.Key]; ok && time.Now().Before(existing.ExpiresAt) {
+		return &existing, nil
+	}
+	ms.idempotencyKeys[record.Key] = record
+	return nil, nil
+}
+
+func (ms *MemoryTaskStore) ReleaseIdempotencyKey(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.idempotencyKeys, key)
+	return nil
+}
+
+func (ms *MemoryTaskStore) CreateBatch(batch *BatchData) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.batches[batch.BatchID] = *batch
+	return nil
+}
+
+func (ms *MemoryTaskStore) GetBatch(batchID string) (*BatchData, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	batch, ok := ms.batches[batchID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get batch: %w", ErrNotFound)
+	}
+	return &batch, nil
+}
+
+func (ms *MemoryTaskStore) ClaimBatchItem(batchID string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	batch, ok := ms.batches[batchID]
+	if !ok {
+		return -1, fmt.Errorf("failed to claim batch item: %w", ErrNotFound)
+	}
+	if batch.Launched >= len(batch.Items) {
+		return -1, nil
+	}
+	batch.Launched++
+	ms.batches[batchID] = batch
+	return batch.Launched - 1, nil
+}
+
+func (ms *MemoryTaskStore) ActiveBatches() ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var batchIDs []string
+	for batchID, batch := range ms.batches {
+		if batch.Launched < len(batch.Items) {
+			batchIDs = append(batchIDs, batchID)
+		}
+	}
+	return batchIDs, nil
+}
+
+func (ms *MemoryTaskStore) DeleteTask(agentID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.tasks, agentID)
+	return nil
+}
+
+func (ms *MemoryTaskStore) TaskExists(agentID string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, ok := ms.tasks[agentID]
+	return ok, nil
+}
+
+func (ms *MemoryTaskStore) HealthCheck() error {
+	return nil
+}
+
+func (ms *MemoryTaskStore) Close() error {
+	return nil
+}
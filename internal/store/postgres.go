@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS agents (
+	agent_id   TEXT PRIMARY KEY,
+	data       JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS conversations (
+	agent_id TEXT PRIMARY KEY,
+	version  BIGINT NOT NULL,
+	length   BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_messages (
+	agent_id TEXT NOT NULL,
+	seq      BIGINT NOT NULL,
+	message  JSONB NOT NULL,
+	PRIMARY KEY (agent_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS archived_agents (
+	agent_id         TEXT PRIMARY KEY,
+	primary_agent_id TEXT NOT NULL,
+	data             JSONB NOT NULL,
+	finished_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS archived_agents_primary_agent_id ON archived_agents (primary_agent_id);
+
+CREATE TABLE IF NOT EXISTS tasks (
+	agent_id   TEXT PRIMARY KEY,
+	task       TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	result     TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS error TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS callback JSONB;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS batch_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS profile TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS profile_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS event_seq BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS tasks_created_at ON tasks (created_at DESC, agent_id DESC);
+CREATE INDEX IF NOT EXISTS tasks_status_created_at ON tasks (status, created_at DESC, agent_id DESC);
+CREATE INDEX IF NOT EXISTS tasks_owner_created_at ON tasks (owner, created_at DESC, agent_id DESC);
+
+CREATE TABLE IF NOT EXISTS task_batches (
+	batch_id TEXT PRIMARY KEY,
+	data     JSONB NOT NULL,
+	total    INTEGER NOT NULL,
+	launched INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS schedules (
+	schedule_id TEXT PRIMARY KEY,
+	data        JSONB NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+	schedule_id TEXT NOT NULL,
+	agent_id    TEXT NOT NULL,
+	data        JSONB NOT NULL,
+	started_at  TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (schedule_id, agent_id)
+);
+
+CREATE INDEX IF NOT EXISTS schedule_runs_started_at ON schedule_runs (schedule_id, started_at DESC);
+
+CREATE TABLE IF NOT EXISTS agent_profiles (
+	name       TEXT NOT NULL,
+	version    INTEGER NOT NULL,
+	data       JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (name, version)
+);
+
+CREATE TABLE IF NOT EXISTS task_idempotency_keys (
+	key          TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	agent_id     TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL,
+	expires_at   TIMESTAMPTZ NOT NULL
+);
+`
+
+type PostgresClient struct {
+	db  *sql.DB
+	ctx context.Context
+
+	// url and refs are set on clients shared through openSharedPostgresClient.
+	url  string
+	refs int
+}
+
+var (
+	sharedPostgresMu      sync.Mutex
+	sharedPostgresClients = make(map[string]*PostgresClient)
+)
+
+// openSharedPostgresClient returns the client every store opened on
+// postgresURL shares, so that a process keeps one connection pool however
+// many stores it opens. Each store closes the client once, and the pool is
+// closed with the last of them.
+func openSharedPostgresClient(postgresURL string) (*PostgresClient, error) {
+	sharedPostgresMu.Lock()
+	defer sharedPostgresMu.Unlock()
+
+	client, ok := sharedPostgresClients[postgresURL]
+	if !ok {
+		var err error
+		if client, err = NewPostgresClient(postgresURL); err != nil {
+			return nil, err
+		}
+		client.url = postgresURL
+		sharedPostgresClients[postgresURL] = client
+	}
+	client.refs++
+	return client, nil
+}
+
+func NewPostgresClient(postgresURL string) (*PostgresClient, error) {
+	db, err := sql.Open("postgres", postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Postgres URL: %w", err)
+	}
+
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx := context.Background()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	client := &PostgresClient{
+		db:  db,
+		ctx: ctx,
+	}
+
+	// Replicas starting together would otherwise race on CREATE TABLE.
+	err = client.InTx(func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('agentlauncher-schema'))`); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, postgresSchema)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to apply Postgres schema: %w", err)
+	}
+
+	log.Println("Connected to Postgres")
+
+	return client, nil
+}
+
+// InTx runs fn in a transaction and commits it unless fn returns an error.
+func (p *PostgresClient) InTx(fn func(*sql.Tx) error) error {
+	tx, err := p.db.BeginTx(p.ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *PostgresClient) Ping() error {
+	return p.db.PingContext(p.ctx)
+}
+
+func (p *PostgresClient) Close() error {
+	if p.url != "" {
+		sharedPostgresMu.Lock()
+		defer sharedPostgresMu.Unlock()
+
+		if p.refs--; p.refs > 0 {
+			return nil
+		}
+		delete(sharedPostgresClients, p.url)
+	}
+
+	log.Println("Closing Postgres connection...")
+	return p.db.Close()
+}
+
+func (p *PostgresClient) GetDB() *sql.DB {
+	return p.db
+}
+
+func (p *PostgresClient) GetContext() context.Context {
+	return p.ctx
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+type PostgresAgentStore struct {
+	postgres *PostgresClient
+}
+
+func NewPostgresAgentStore(postgresClient *PostgresClient) *PostgresAgentStore {
+	return &PostgresAgentStore{
+		postgres: postgresClient,
+	}
+}
+
+func (ps *PostgresAgentStore) CreateAgent(agentData *AgentData) error {
+	data, err := json.Marshal(agentData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent data: %w", err)
+	}
+
+	_, err = ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`INSERT INTO agents (agent_id, data) VALUES ($1, $2)
+		 ON CONFLICT (agent_id) DO UPDATE SET data = EXCLUDED.data`,
+		agentData.AgentID, data)
+	if err != nil {
+		return fmt.Errorf("failed to store agent data: %w", err)
+	}
+
+	return nil
+}
+
+func (ps *PostgresAgentStore) GetAgent(agentID string) (*AgentData, error) {
+	var data []byte
+	err := ps.postgres.GetDB().QueryRowContext(ps.postgres.GetContext(),
+		`SELECT data FROM agents WHERE agent_id = $1`, agentID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get agent data: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent data: %w", err)
+	}
+
+	var agent AgentData
+	if err := json.Unmarshal(data, &agent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal agent data: %w", err)
+	}
+
+	return &agent, nil
+}
+
+func (ps *PostgresAgentStore) UpdateAgent(agentData *AgentData) error {
+	data, err := json.Marshal(agentData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent data: %w", err)
+	}
+
+	res, err := ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`UPDATE agents SET data = $2 WHERE agent_id = $1`, agentData.AgentID, data)
+	if err != nil {
+		return fmt.Errorf("failed to update agent data: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to update agent data: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (ps *PostgresAgentStore) GetConversation(agentID string) (*Conversation, error) {
+	var conversation *Conversation
+	err := ps.readConversation(agentID, func(tx *sql.Tx, version, length int64) error {
+		messages, err := ps.queryMessages(tx, agentID, 0, length-1)
+		if err != nil {
+			return err
+		}
+		conversation = &Conversation{
+			Messages: messages,
+			Version:  version,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return conversation, nil
+}
+
+func (ps *PostgresAgentStore) GetMessages(agentID string, start, stop int64) ([]llminterface.Message, error) {
+	messages := []llminterface.Message{}
+	err := ps.readConversation(agentID, func(tx *sql.Tx, version, length int64) error {
+		from, to, ok := messageRange(length, start, stop)
+		if !ok {
+			return nil
+		}
+		var err error
+		messages, err = ps.queryMessages(tx, agentID, from, to)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return []llminterface.Message{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	return messages, nil
+}
+
+// readConversation runs fn against a consistent snapshot of the conversation
+// header and its messages.
+func (ps *PostgresAgentStore) readConversation(agentID string, fn func(tx *sql.Tx, version, length int64) error) error {
+	tx, err := ps.postgres.GetDB().BeginTx(ps.postgres.GetContext(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version, length int64
+	err = tx.QueryRowContext(ps.postgres.GetContext(),
+		`SELECT version, length FROM conversations WHERE agent_id = $1`, agentID).Scan(&version, &length)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return fn(tx, version, length)
+}
+
+func (ps *PostgresAgentStore) queryMessages(tx *sql.Tx, agentID string, from, to int64) ([]llminterface.Message, error) {
+	rows, err := tx.QueryContext(ps.postgres.GetContext(),
+		`SELECT message FROM conversation_messages
+		 WHERE agent_id = $1 AND seq BETWEEN $2 AND $3
+		 ORDER BY seq`,
+		agentID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []llminterface.Message{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var message llminterface.Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (ps *PostgresAgentStore) AppendConversation(agentID string, version int64, messages ...llminterface.Message) (int64, error) {
+	return ps.writeConversation(agentID, version, false, messages)
+}
+
+func (ps *PostgresAgentStore) ReplaceConversation(agentID string, version int64, messages []llminterface.Message) (int64, error) {
+	return ps.writeConversation(agentID, version, true, messages)
+}
+
+// writeConversation only commits when the stored version still matches the
+// version the caller read; version 0 means the conversation must not exist yet.
+func (ps *PostgresAgentStore) writeConversation(agentID string, version int64, replace bool, messages []llminterface.Message) (int64, error) {
+	ctx := ps.postgres.GetContext()
+	count := int64(len(messages))
+
+	err := ps.postgres.InTx(func(tx *sql.Tx) error {
+		var length int64
+		var err error
+		if version == 0 {
+			err = tx.QueryRowContext(ctx,
+				`INSERT INTO conversations (agent_id, version, length) VALUES ($1, 1, $2)
+				 ON CONFLICT (agent_id) DO NOTHING
+				 RETURNING length`,
+				agentID, count).Scan(&length)
+		} else if replace {
+			err = tx.QueryRowContext(ctx,
+				`UPDATE conversations SET version = version + 1, length = $3
+				 WHERE agent_id = $1 AND version = $2
+				 RETURNING length`,
+				agentID, version, count).Scan(&length)
+		} else {
+			err = tx.QueryRowContext(ctx,
+				`UPDATE conversations SET version = version + 1, length = length + $3
+				 WHERE agent_id = $1 AND version = $2
+				 RETURNING length`,
+				agentID, version, count).Scan(&length)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConversationConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		if replace {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM conversation_messages WHERE agent_id = $1`, agentID); err != nil {
+				return err
+			}
+		}
+
+		offset := length - count
+		for i, message := range messages {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return fmt.Errorf("failed to marshal message: %w", err)
+			}
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO conversation_messages (agent_id, seq, message) VALUES ($1, $2, $3)`,
+				agentID, offset+int64(i), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update conversation: %w", err)
+	}
+	return version + 1, nil
+}
+
+func (ps *PostgresAgentStore) Exists(agentID string) (bool, error) {
+	var exists bool
+	err := ps.postgres.GetDB().QueryRowContext(ps.postgres.GetContext(),
+		`SELECT EXISTS (SELECT 1 FROM agents WHERE agent_id = $1)`, agentID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if agent exists: %w", err)
+	}
+	return exists, nil
+}
+
+func (ps *PostgresAgentStore) Delete(agentID string) error {
+	ctx := ps.postgres.GetContext()
+	err := ps.postgres.InTx(func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM conversation_messages WHERE agent_id = $1`,
+			`DELETE FROM conversations WHERE agent_id = $1`,
+			`DELETE FROM agents WHERE agent_id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, agentID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete agent: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresAgentStore) ExpiredSessions(now time.Time) ([]string, error) {
+	rows, err := ps.postgres.GetDB().QueryContext(ps.postgres.GetContext(),
+		`SELECT agent_id FROM agents
+		 WHERE data->>'session_expires_at' IS NOT NULL AND (data->>'session_expires_at')::timestamptz < $1`, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var agentIDs []string
+	for rows.Next() {
+		var agentID string
+		if err := rows.Scan(&agentID); err != nil {
+			return nil, fmt.Errorf("failed to list expired sessions: %w", err)
+		}
+		agentIDs = append(agentIDs, agentID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expired sessions: %w", err)
+	}
+	return agentIDs, nil
+}
+
+// Lock takes a session-level advisory lock, so the connection holding it is
+// pinned until the returned release function runs.
+func (ps *PostgresAgentStore) Lock(ctx context.Context, agentID string) (func(), error) {
+	conn, err := ps.postgres.GetDB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire agent lock: %w", err)
+	}
+
+	backoff := 10 * time.Millisecond
+	for {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, agentID).Scan(&acquired)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to acquire agent lock: %w", err)
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, fmt.Errorf("failed to acquire agent lock: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 200*time.Millisecond)
+	}
+
+	return func() {
+		defer conn.Close()
+		if _, err := conn.ExecContext(ps.postgres.GetContext(), `SELECT pg_advisory_unlock(hashtext($1))`, agentID); err != nil {
+			log.Printf("[%s] Failed to release agent lock: %v", agentID, err)
+		}
+	}, nil
+}
+
+func (ps *PostgresAgentStore) Close() error {
+	return ps.postgres.Close()
+}
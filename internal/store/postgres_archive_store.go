@@ -0,0 +1,63 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type PostgresArchiveStore struct {
+	postgres *PostgresClient
+}
+
+func NewPostgresArchiveStore(postgresClient *PostgresClient) *PostgresArchiveStore {
+	return &PostgresArchiveStore{
+		postgres: postgresClient,
+	}
+}
+
+func (ps *PostgresArchiveStore) ArchiveAgent(agent *ArchivedAgent) error {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal archived agent: %w", err)
+	}
+
+	_, err = ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`INSERT INTO archived_agents (agent_id, primary_agent_id, data, finished_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (agent_id) DO UPDATE SET data = EXCLUDED.data, finished_at = EXCLUDED.finished_at`,
+		agent.AgentID, agent.PrimaryAgentID, data, agent.FinishedAt)
+	if err != nil {
+		return fmt.Errorf("failed to archive agent: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresArchiveStore) GetArchivedTask(agentID string) (*ArchivedTask, error) {
+	rows, err := ps.postgres.GetDB().QueryContext(ps.postgres.GetContext(),
+		`SELECT data FROM archived_agents WHERE primary_agent_id = $1`, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived task: %w", err)
+	}
+	defer rows.Close()
+
+	var agents []ArchivedAgent
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to get archived task: %w", err)
+		}
+		var agent ArchivedAgent
+		if err := json.Unmarshal(data, &agent); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal archived agent: %w", err)
+		}
+		agents = append(agents, agent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get archived task: %w", err)
+	}
+
+	return assembleArchivedTask(agentID, agents)
+}
+
+func (ps *PostgresArchiveStore) Close() error {
+	return ps.postgres.Close()
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type PostgresProfileStore struct {
+	postgres *PostgresClient
+}
+
+func NewPostgresProfileStore(postgresClient *PostgresClient) *PostgresProfileStore {
+	return &PostgresProfileStore{
+		postgres: postgresClient,
+	}
+}
+
+func (ps *PostgresProfileStore) CreateProfile(profile *ProfileData) error {
+	profile.Version = 1
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("failed to marshal profile: %w", err)
+	}
+
+	result, err := ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`INSERT INTO agent_profiles (name, version, data, created_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (name, version) DO NOTHING`,
+		profile.Name, profile.Version, data, profile.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create profile: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("failed to create profile: %w", ErrAlreadyExists)
+	}
+	return nil
+}
+
+// UpdateProfile numbers the new version under a per-profile advisory lock so
+// that concurrent updates do not pick the same number.
+func (ps *PostgresProfileStore) UpdateProfile(profile *ProfileData) error {
+	ctx := ps.postgres.GetContext()
+
+	err := ps.postgres.InTx(func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('agent_profiles:' || $1))`, profile.Name); err != nil {
+			return err
+		}
+
+		var latest sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			`SELECT MAX(version) FROM agent_profiles WHERE name = $1`, profile.Name).Scan(&latest)
+		if err != nil {
+			return err
+		}
+		if !latest.Valid {
+			return ErrNotFound
+		}
+
+		profile.Version = int(latest.Int64) + 1
+		data, err := json.Marshal(profile)
+		if err != nil {
+			return fmt.Errorf("failed to marshal profile: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO agent_profiles (name, version, data, created_at) VALUES ($1, $2, $3, $4)`,
+			profile.Name, profile.Version, data, profile.CreatedAt)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresProfileStore) GetProfile(name string, version int) (*ProfileData, error) {
+	var data []byte
+	err := ps.postgres.GetDB().QueryRowContext(ps.postgres.GetContext(),
+		`SELECT data FROM agent_profiles WHERE name = $1 AND ($2 = 0 OR version = $2)
+		 ORDER BY version DESC LIMIT 1`, name, version).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get profile: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	var profile ProfileData
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal profile: %w", err)
+	}
+	return &profile, nil
+}
+
+func (ps *PostgresProfileStore) ListProfiles() ([]ProfileData, error) {
+	return ps.queryProfiles("failed to list profiles",
+		`SELECT DISTINCT ON (name) data FROM agent_profiles ORDER BY name, version DESC`)
+}
+
+func (ps *PostgresProfileStore) ListProfileVersions(name string) ([]ProfileData, error) {
+	versions, err := ps.queryProfiles("failed to list profile versions",
+		`SELECT data FROM agent_profiles WHERE name = $1 ORDER BY version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("failed to list profile versions: %w", ErrNotFound)
+	}
+	return versions, nil
+}
+
+func (ps *PostgresProfileStore) queryProfiles(errMsg, query string, args ...any) ([]ProfileData, error) {
+	rows, err := ps.postgres.GetDB().QueryContext(ps.postgres.GetContext(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	defer rows.Close()
+
+	profiles := []ProfileData{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		var profile ProfileData
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal profile: %w", err)
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	return profiles, nil
+}
+
+func (ps *PostgresProfileStore) DeleteProfile(name string) error {
+	result, err := ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`DELETE FROM agent_profiles WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete profile: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("failed to delete profile: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (ps *PostgresProfileStore) HealthCheck() error {
+	return ps.postgres.Ping()
+}
+
+func (ps *PostgresProfileStore) Close() error {
+	return ps.postgres.Close()
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PostgresScheduleStore struct {
+	postgres *PostgresClient
+}
+
+func NewPostgresScheduleStore(postgresClient *PostgresClient) *PostgresScheduleStore {
+	return &PostgresScheduleStore{
+		postgres: postgresClient,
+	}
+}
+
+func (ps *PostgresScheduleStore) CreateSchedule(schedule *ScheduleData) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+
+	_, err = ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`INSERT INTO schedules (schedule_id, data, created_at) VALUES ($1, $2, $3)`,
+		schedule.ScheduleID, data, schedule.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresScheduleStore) GetSchedule(scheduleID string) (*ScheduleData, error) {
+	var data []byte
+	err := ps.postgres.GetDB().QueryRowContext(ps.postgres.GetContext(),
+		`SELECT data FROM schedules WHERE schedule_id = $1`, scheduleID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get schedule: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+
+	var schedule ScheduleData
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+func (ps *PostgresScheduleStore) ListSchedules() ([]ScheduleData, error) {
+	rows, err := ps.postgres.GetDB().QueryContext(ps.postgres.GetContext(),
+		`SELECT data FROM schedules ORDER BY created_at, schedule_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []ScheduleData{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to list schedules: %w", err)
+		}
+		var schedule ScheduleData
+		if err := json.Unmarshal(data, &schedule); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+func (ps *PostgresScheduleStore) UpdateSchedule(schedule *ScheduleData) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+
+	result, err := ps.postgres.GetDB().ExecContext(ps.postgres.GetContext(),
+		`UPDATE schedules SET data = $2 WHERE schedule_id = $1`, schedule.ScheduleID, data)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("failed to update schedule: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (ps *PostgresScheduleStore) DeleteSchedule(scheduleID string) error {
+	ctx := ps.postgres.GetContext()
+
+	err := ps.postgres.InTx(func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM schedules WHERE schedule_id = $1`, scheduleID)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM schedule_runs WHERE schedule_id = $1`, scheduleID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	return nil
+}
+
+// ClaimScheduleRun compares and sets the next run with the row locked, and
+// records the run in the same transaction.
+func (ps *PostgresScheduleStore) ClaimScheduleRun(run ScheduleRun, next time.Time) (bool, error) {
+	ctx := ps.postgres.GetContext()
+
+	claimed := false
+	err := ps.postgres.InTx(func(tx *sql.Tx) error {
+		var data []byte
+		err := tx.QueryRowContext(ctx,
+			`SELECT data FROM schedules WHERE schedule_id = $1 FOR UPDATE`, run.ScheduleID).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var schedule ScheduleData
+		if err := json.Unmarshal(data, &schedule); err != nil {
+			return fmt.Errorf("failed to unmarshal schedule: %w", err)
+		}
+		if !claimScheduleRun(&schedule, run.ScheduledAt, next) {
+			return nil
+		}
+		if data, err = json.Marshal(&schedule); err != nil {
+			return fmt.Errorf("failed to marshal schedule: %w", err)
+		}
+
+		if _, err = tx.ExecContext(ctx, `UPDATE schedules SET data = $2 WHERE schedule_id = $1`, run.ScheduleID, data); err != nil {
+			return err
+		}
+		if err := ps.addScheduleRun(tx, run); err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to claim schedule run: %w", err)
+	}
+	return claimed, nil
+}
+
+func (ps *PostgresScheduleStore) addScheduleRun(tx *sql.Tx, run ScheduleRun) error {
+	ctx := ps.postgres.GetContext()
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule run: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO schedule_runs (schedule_id, agent_id, data, started_at) VALUES ($1, $2, $3, $4)`,
+		run.ScheduleID, run.AgentID, data, run.StartedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM schedule_runs WHERE schedule_id = $1 AND agent_id NOT IN (
+		     SELECT agent_id FROM schedule_runs WHERE schedule_id = $1 ORDER BY started_at DESC LIMIT $2)`,
+		run.ScheduleID, MaxScheduleRuns)
+	return err
+}
+
+// UpdateScheduleRun compares and sets the run with its row locked. The row
+// keeps its place in the history, which is ordered by the first start.
+func (ps *PostgresScheduleStore) UpdateScheduleRun(run ScheduleRun, startedAt time.Time) (bool, error) {
+	ctx := ps.postgres.GetContext()
+
+	updated := false
+	err := ps.postgres.InTx(func(tx *sql.Tx) error {
+		var data []byte
+		err := tx.QueryRowContext(ctx,
+			`SELECT data FROM schedule_runs WHERE schedule_id = $1 AND agent_id = $2 FOR UPDATE`,
+			run.ScheduleID, run.AgentID).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored ScheduleRun
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("failed to unmarshal schedule run: %w", err)
+		}
+		if !updateScheduleRun(&stored, run, startedAt) {
+			return nil
+		}
+		if data, err = json.Marshal(run); err != nil {
+			return fmt.Errorf("failed to marshal schedule run: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE schedule_runs SET data = $3 WHERE schedule_id = $1 AND agent_id = $2`,
+			run.ScheduleID, run.AgentID, data)
+		updated = err == nil
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to update schedule run: %w", err)
+	}
+	return updated, nil
+}
+
+func (ps *PostgresScheduleStore) PendingScheduleRuns(startedBefore time.Time) ([]ScheduleRun, error) {
+	rows, err := ps.postgres.GetDB().QueryContext(ps.postgres.GetContext(),
+		`SELECT data FROM schedule_runs WHERE data @> '{"pending": true}' AND (data->>'started_at')::timestamptz < $1`,
+		startedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending schedule runs: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []ScheduleRun
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to list pending schedule runs: %w", err)
+		}
+		var run ScheduleRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule run: %w", err)
+		}
+		pending = append(pending, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list pending schedule runs: %w", err)
+	}
+	return pending, nil
+}
+
+func (ps *PostgresScheduleStore) ListScheduleRuns(scheduleID string, limit int) ([]ScheduleRun, error) {
+	rows, err := ps.postgres.GetDB().QueryContext(ps.postgres.GetContext(),
+		`SELECT data FROM schedule_runs WHERE schedule_id = $1 ORDER BY started_at DESC LIMIT $2`, scheduleID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedule runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to list schedule runs: %w", err)
+		}
+		var run ScheduleRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list schedule runs: %w", err)
+	}
+	return runs, nil
+}
+
+func (ps *PostgresScheduleStore) HealthCheck() error {
+	return ps.postgres.Ping()
+}
+
+func (ps *PostgresScheduleStore) Close() error {
+	return ps.postgres.Close()
+}
@@ -0,0 +1,438 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PostgresTaskStore struct {
+	postgres *PostgresClient
+}
+
+func NewPostgresTaskStore(postgresClient *PostgresClient) *PostgresTaskStore {
+	return &PostgresTaskStore{
+		postgres: postgresClient,
+	}
+}
+
+func (ts *PostgresTaskStore) CreateTask(spec TaskSpec) error {
+	taskData := newTask(spec)
+	callbackData, err := marshalTaskCallback(taskData.Callback)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+	var profile ProfileRef
+	if taskData.Profile != nil {
+		profile = *taskData.Profile
+	}
+	_, err = ts.postgres.GetDB().ExecContext(ts.postgres.GetContext(),
+		`INSERT INTO tasks (agent_id, task, owner, status, created_at, updated_at, callback, batch_id, profile, profile_version)
+		 VALUES ($1, $2, $3, $4, $5, $5, $6, $7, $8, $9)
+		 ON CONFLICT (agent_id) DO UPDATE
+		 SET task = EXCLUDED.task, owner = EXCLUDED.owner, status = EXCLUDED.status, result = '', error = '',
+		     created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at, started_at = NULL, finished_at = NULL,
+		     callback = EXCLUDED.callback, batch_id = EXCLUDED.batch_id,
+		     profile = EXCLUDED.profile, profile_version = EXCLUDED.profile_version, event_seq = 0`,
+		taskData.AgentID, taskData.Task, taskData.Owner, taskData.Status, taskData.CreatedAt, callbackData, taskData.BatchID,
+		profile.Name, profile.Version)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+	return nil
+}
+
+func (ts *PostgresTaskStore) UpdateTask(agentID string, update TaskUpdate) (*TaskData, error) {
+	task, err := ts.modifyTask(agentID, func(task *TaskData) error {
+		return applyTaskUpdate(task, update)
+	})
+	if errors.Is(err, ErrTaskFinished) || errors.Is(err, ErrTaskActive) || errors.Is(err, ErrStaleUpdate) {
+		return task, fmt.Errorf("failed to update task: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update task: %w", err)
+	}
+	return task, nil
+}
+
+func (ts *PostgresTaskStore) UpdateCallbackDelivery(agentID string, delivery CallbackDelivery) error {
+	_, err := ts.modifyTask(agentID, func(task *TaskData) error {
+		return applyCallbackDelivery(task, delivery)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update callback delivery: %w", err)
+	}
+	return nil
+}
+
+// modifyTask reads, changes and writes the task with the row locked. The task
+// is returned even when apply fails.
+func (ts *PostgresTaskStore) modifyTask(agentID string, apply func(task *TaskData) error) (*TaskData, error) {
+	ctx := ts.postgres.GetContext()
+
+	var task TaskData
+	err := ts.postgres.InTx(func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`SELECT `+taskColumns+` FROM tasks WHERE agent_id = $1 FOR UPDATE`, agentID).
+			Scan(taskFields(&task)...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		normalizeTaskTimes(&task)
+
+		if err := apply(&task); err != nil {
+			return err
+		}
+
+		callbackData, err := marshalTaskCallback(task.Callback)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE tasks SET status = $2, result = $3, error = $4, updated_at = $5, started_at = $6, finished_at = $7,
+			     callback = $8, event_seq = $9
+			 WHERE agent_id = $1`,
+			agentID, task.Status, task.Result, task.Error, task.UpdatedAt, task.StartedAt, task.FinishedAt, callbackData,
+			int64(task.EventSeq))
+		return err
+	})
+	return &task, err
+}
+
+// ReserveIdempotencyKey takes over an expired record in place, so expired
+// keys need no separate cleanup to become usable again.
+func (ts *PostgresTaskStore) ReserveIdempotencyKey(record IdempotencyRecord) (*IdempotencyRecord, error) {
+	ctx := ts.postgres.GetContext()
+
+	var existing *IdempotencyRecord
+	err := ts.postgres.InTx(func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO task_idempotency_keys (key, request_hash, agent_id, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (key) DO UPDATE
+			 SET request_hash = EXCLUDED.request_hash, agent_id = EXCLUDED.agent_id,
+			     created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+			 WHERE task_idempotency_keys.expires_at <= EXCLUDED.created_at`,
+			record.Key, record.RequestHash, record.AgentID, record.CreatedAt, record.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+			return err
+		}
+
+		existing = &IdempotencyRecord{}
+		err = tx.QueryRowContext(ctx,
+			`SELECT key, request_hash, agent_id, created_at, expires_at FROM task_idempotency_keys WHERE key = $1`, record.Key).
+			Scan(&existing.Key, &existing.RequestHash, &existing.AgentID, &existing.CreatedAt, &existing.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		existing.CreatedAt = existing.CreatedAt.UTC()
+		existing.ExpiresAt = existing.ExpiresAt.UTC()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	return existing, nil
+}
+
+func (ts *PostgresTaskStore) ReleaseIdempotencyKey(key string) error {
+	_, err := ts.postgres.GetDB().ExecContext(ts.postgres.GetContext(),
+		`DELETE FROM task_idempotency_keys WHERE key = $1`, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (ts *PostgresTaskStore) CreateBatch(batch *BatchData) error {
+	jsonData, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch data: %w", err)
+	}
+	_, err = ts.postgres.GetDB().ExecContext(ts.postgres.GetContext(),
+		`INSERT INTO task_batches (batch_id, data, total, launched) VALUES ($1, $2, $3, $4)`,
+		batch.BatchID, jsonData, len(batch.Items), batch.Launched)
+	if err != nil {
+		return fmt.Errorf("failed to create batch: %w", err)
+	}
+	return nil
+}
+
+func (ts *PostgresTaskStore) GetBatch(batchID string) (*BatchData, error) {
+	var data []byte
+	var launched int
+	err := ts.postgres.GetDB().QueryRowContext(ts.postgres.GetContext(),
+		`SELECT data, launched FROM task_batches WHERE batch_id = $1`, batchID).
+		Scan(&data, &launched)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get batch: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch: %w", err)
+	}
+
+	var batch BatchData
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal batch data: %w", err)
+	}
+	batch.Launched = launched
+	batch.CreatedAt = batch.CreatedAt.UTC()
+	return &batch, nil
+}
+
+func (ts *PostgresTaskStore) ClaimBatchItem(batchID string) (int, error) {
+	ctx := ts.postgres.GetContext()
+
+	var index int
+	err := ts.postgres.GetDB().QueryRowContext(ctx,
+		`UPDATE task_batches SET launched = launched + 1
+		 WHERE batch_id = $1 AND launched < total
+		 RETURNING launched - 1`, batchID).Scan(&index)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = ts.postgres.GetDB().QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM task_batches WHERE batch_id = $1)`, batchID).Scan(&exists)
+		if err == nil && !exists {
+			err = ErrNotFound
+		}
+		index = -1
+	}
+	if err != nil {
+		return -1, fmt.Errorf("failed to claim batch item: %w", err)
+	}
+	return index, nil
+}
+
+func (ts *PostgresTaskStore) ActiveBatches() ([]string, error) {
+	rows, err := ts.postgres.GetDB().QueryContext(ts.postgres.GetContext(),
+		`SELECT batch_id FROM task_batches WHERE launched < total`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active batches: %w", err)
+	}
+	defer rows.Close()
+
+	var batchIDs []string
+	for rows.Next() {
+		var batchID string
+		if err := rows.Scan(&batchID); err != nil {
+			return nil, fmt.Errorf("failed to scan batch: %w", err)
+		}
+		batchIDs = append(batchIDs, batchID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list active batches: %w", err)
+	}
+	return batchIDs, nil
+}
+
+func (ts *PostgresTaskStore) GetTask(agentID string) (*TaskData, error) {
+	var task TaskData
+	err := ts.postgres.GetDB().QueryRowContext(ts.postgres.GetContext(),
+		`SELECT `+taskColumns+` FROM tasks WHERE agent_id = $1`, agentID).
+		Scan(taskFields(&task)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get task: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	normalizeTaskTimes(&task)
+	return &task, nil
+}
+
+func (ts *PostgresTaskStore) ListTasks(filter TaskFilter) (*TaskPage, error) {
+	cursor, err := decodeTaskCursor(filter.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.Status != "" {
+		where("status = ?", filter.Status)
+	}
+	if filter.Owner != "" {
+		where("owner = ?", filter.Owner)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where("created_at < ?", filter.CreatedBefore)
+	}
+	if filter.Query != "" {
+		where("task ILIKE ?", "%"+escapeLike(filter.Query)+"%")
+	}
+	if cursor != nil {
+		args = append(args, cursor.CreatedAt, cursor.AgentID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, agent_id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM tasks`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	limit := filter.limit()
+	args = append(args, limit+1)
+	query += fmt.Sprintf(` ORDER BY created_at DESC, agent_id DESC LIMIT $%d`, len(args))
+
+	rows, err := ts.postgres.GetDB().QueryContext(ts.postgres.GetContext(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []TaskData
+	for rows.Next() {
+		var task TaskData
+		if err := rows.Scan(taskFields(&task)...); err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		normalizeTaskTimes(&task)
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	return newTaskPage(tasks, limit), nil
+}
+
+const taskColumns = `agent_id, task, owner, status, result, error, created_at, updated_at, started_at, finished_at, callback, batch_id, profile, profile_version, event_seq`
+
+func taskFields(task *TaskData) []any {
+	return []any{
+		&task.AgentID, &task.Task, &task.Owner, &task.Status, &task.Result, &task.Error,
+		&task.CreatedAt, &task.UpdatedAt, &task.StartedAt, &task.FinishedAt, taskCallbackColumn{task}, &task.BatchID,
+		taskProfileColumn{task}, taskProfileVersionColumn{task}, &task.EventSeq,
+	}
+}
+
+// taskCallbackColumn scans the nullable callback JSONB column into the task.
+type taskCallbackColumn struct {
+	task *TaskData
+}
+
+func (c taskCallbackColumn) Scan(src any) error {
+	c.task.Callback = nil
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unexpected callback column type %T", src)
+	}
+	var callback TaskCallback
+	if err := json.Unmarshal(data, &callback); err != nil {
+		return fmt.Errorf("failed to unmarshal task callback: %w", err)
+	}
+	c.task.Callback = &callback
+	return nil
+}
+
+// taskProfileColumn scans the profile column, which is empty for tasks
+// launched without a profile. taskProfileVersionColumn must follow it.
+type taskProfileColumn struct {
+	task *TaskData
+}
+
+func (c taskProfileColumn) Scan(src any) error {
+	c.task.Profile = nil
+	var name string
+	switch v := src.(type) {
+	case []byte:
+		name = string(v)
+	case string:
+		name = v
+	default:
+		return fmt.Errorf("unexpected profile column type %T", src)
+	}
+	if name != "" {
+		c.task.Profile = &ProfileRef{Name: name}
+	}
+	return nil
+}
+
+type taskProfileVersionColumn struct {
+	task *TaskData
+}
+
+func (c taskProfileVersionColumn) Scan(src any) error {
+	version, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("unexpected profile_version column type %T", src)
+	}
+	if c.task.Profile != nil {
+		c.task.Profile.Version = int(version)
+	}
+	return nil
+}
+
+func marshalTaskCallback(callback *TaskCallback) (any, error) {
+	if callback == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(callback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal task callback: %w", err)
+	}
+	return data, nil
+}
+
+func normalizeTaskTimes(task *TaskData) {
+	task.CreatedAt = task.CreatedAt.UTC()
+	task.UpdatedAt = task.UpdatedAt.UTC()
+	for _, t := range []*time.Time{task.StartedAt, task.FinishedAt} {
+		if t != nil {
+			*t = t.UTC()
+		}
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (ts *PostgresTaskStore) DeleteTask(agentID string) error {
+	_, err := ts.postgres.GetDB().ExecContext(ts.postgres.GetContext(),
+		`DELETE FROM tasks WHERE agent_id = $1`, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+	return nil
+}
+
+func (ts *PostgresTaskStore) TaskExists(agentID string) (bool, error) {
+	var exists bool
+	err := ts.postgres.GetDB().QueryRowContext(ts.postgres.GetContext(),
+		`SELECT EXISTS (SELECT 1 FROM tasks WHERE agent_id = $1)`, agentID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if task exists: %w", err)
+	}
+	return exists, nil
+}
+
+func (ts *PostgresTaskStore) HealthCheck() error {
+	return ts.postgres.Ping()
+}
+
+func (ts *PostgresTaskStore) Close() error {
+	return ts.postgres.Close()
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestSharedPostgresClientClosesWithLastStore(t *testing.T) {
+	const url = "postgres://localhost/agentlauncher-test"
+
+	// sql.Open does not connect, so the client can stand in for one that
+	// already applied the schema.
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	sharedPostgresMu.Lock()
+	sharedPostgresClients[url] = &PostgresClient{db: db, ctx: context.Background(), url: url}
+	sharedPostgresMu.Unlock()
+
+	cfg := Config{Backend: BackendPostgres, PostgresURL: url, Archive: BackendPostgres}
+	taskStore, err := OpenTaskStore(cfg)
+	if err != nil {
+		t.Fatalf("OpenTaskStore: %v", err)
+	}
+	archiveStore, err := OpenArchiveStore(cfg)
+	if err != nil {
+		t.Fatalf("OpenArchiveStore: %v", err)
+	}
+	if taskStore.(*PostgresTaskStore).postgres != archiveStore.(*PostgresArchiveStore).postgres {
+		t.Fatal("stores opened on one URL use different clients")
+	}
+
+	taskStore.Close()
+	sharedPostgresMu.Lock()
+	_, open := sharedPostgresClients[url]
+	sharedPostgresMu.Unlock()
+	if !open {
+		t.Fatal("client closed while a store still uses it")
+	}
+
+	archiveStore.Close()
+	sharedPostgresMu.Lock()
+	_, open = sharedPostgresClients[url]
+	sharedPostgresMu.Unlock()
+	if open {
+		t.Fatal("client still open after its last store closed")
+	}
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+)
+
+// ProfileData is one version of a named agent profile: the defaults a task
+// that references the profile starts from. Updating a profile adds a version
+// and keeps the earlier ones, so every task can name the version it ran.
+type ProfileData struct {
+	Name            string                   `json:"name"`
+	Version         int                      `json:"version"`
+	Description     string                   `json:"description,omitempty"`
+	SystemPrompt    string                   `json:"system_prompt,omitempty"`
+	Tools           []string                 `json:"tools,omitempty"`
+	RequireApproval []string                 `json:"require_approval,omitempty"`
+	Limits          llminterface.AgentLimits `json:"limits"`
+	ModelParams     llminterface.ModelParams `json:"model_params"`
+	CreatedAt       time.Time                `json:"created_at"`
+}
+
+type ProfileStore interface {
+	// CreateProfile stores version 1 of a new profile. It fails with
+	// ErrAlreadyExists when the name is taken.
+	CreateProfile(profile *ProfileData) error
+	// UpdateProfile stores profile as the next version of an existing
+	// profile and sets its Version.
+	UpdateProfile(profile *ProfileData) error
+	// GetProfile returns the given version, or the latest one for version 0.
+	GetProfile(name string, version int) (*ProfileData, error)
+	// ListProfiles returns the latest version of every profile.
+	ListProfiles() ([]ProfileData, error)
+	// ListProfileVersions returns every version of a profile, newest first.
+	ListProfileVersions(name string) ([]ProfileData, error)
+	DeleteProfile(name string) error
+	HealthCheck() error
+	Close() error
+}
+
+func sortProfiles(profiles []ProfileData) {
+	slices.SortFunc(profiles, func(a, b ProfileData) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestProfile(name, prompt string) *ProfileData {
+	return &ProfileData{
+		Name:         name,
+		SystemPrompt: prompt,
+		Tools:        []string{"calculator"},
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+func profileVersions(profiles []ProfileData) []int {
+	versions := make([]int, len(profiles))
+	for i, profile := range profiles {
+		versions[i] = profile.Version
+	}
+	return versions
+}
+
+func TestProfileVersions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		profileStore := openStore(t, cfg, OpenProfileStore)
+		name := testID("profile")
+
+		if _, err := profileStore.GetProfile(name, 0); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetProfile of a missing profile: got %v, want ErrNotFound", err)
+		}
+		if err := profileStore.UpdateProfile(newTestProfile(name, "v2")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateProfile of a missing profile: got %v, want ErrNotFound", err)
+		}
+
+		profile := newTestProfile(name, "v1")
+		profile.Version = 7
+		if err := profileStore.CreateProfile(profile); err != nil || profile.Version != 1 {
+			t.Fatalf("CreateProfile: got version %d, error %v; want version 1", profile.Version, err)
+		}
+		if err := profileStore.CreateProfile(newTestProfile(name, "again")); !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("CreateProfile of a taken name: got %v, want ErrAlreadyExists", err)
+		}
+		for _, prompt := range []string{"v2", "v3"} {
+			profile := newTestProfile(name, prompt)
+			if err := profileStore.UpdateProfile(profile); err != nil {
+				t.Fatalf("UpdateProfile: %v", err)
+			}
+			if want := fmt.Sprintf("v%d", profile.Version); want != prompt {
+				t.Fatalf("update with prompt %s became version %d", prompt, profile.Version)
+			}
+		}
+
+		tests := []struct {
+			version int
+			prompt  string
+			err     error
+		}{
+			{0, "v3", nil},
+			{1, "v1", nil},
+			{2, "v2", nil},
+			{3, "v3", nil},
+			{4, "", ErrNotFound},
+			{-1, "", ErrNotFound},
+		}
+		for _, tt := range tests {
+			got, err := profileStore.GetProfile(name, tt.version)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("GetProfile version %d: got error %v, want %v", tt.version, err, tt.err)
+				continue
+			}
+			if err == nil && (got.SystemPrompt != tt.prompt || !slices.Equal(got.Tools, []string{"calculator"})) {
+				t.Errorf("GetProfile version %d: got %+v, want prompt %s", tt.version, got, tt.prompt)
+			}
+		}
+
+		versions, err := profileStore.ListProfileVersions(name)
+		if err != nil {
+			t.Fatalf("ListProfileVersions: %v", err)
+		}
+		if got := profileVersions(versions); !slices.Equal(got, []int{3, 2, 1}) {
+			t.Fatalf("ListProfileVersions returned versions %v, want [3 2 1]", got)
+		}
+
+		other := newTestProfile(name+"-other", "other")
+		if err := profileStore.CreateProfile(other); err != nil {
+			t.Fatalf("CreateProfile: %v", err)
+		}
+		profiles, err := profileStore.ListProfiles()
+		if err != nil {
+			t.Fatalf("ListProfiles: %v", err)
+		}
+		var listed []ProfileData
+		for _, profile := range profiles {
+			if profile.Name == name || profile.Name == other.Name {
+				listed = append(listed, profile)
+			}
+		}
+		if len(listed) != 2 || listed[0].Name != name || listed[0].Version != 3 || listed[1].Name != other.Name {
+			t.Fatalf("ListProfiles returned %+v, want the latest %s then %s", listed, name, other.Name)
+		}
+
+		if err := profileStore.DeleteProfile(name); err != nil {
+			t.Fatalf("DeleteProfile: %v", err)
+		}
+		if _, err := profileStore.GetProfile(name, 1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetProfile after delete: got %v, want ErrNotFound", err)
+		}
+		if _, err := profileStore.ListProfileVersions(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ListProfileVersions after delete: got %v, want ErrNotFound", err)
+		}
+		if err := profileStore.DeleteProfile(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteProfile twice: got %v, want ErrNotFound", err)
+		}
+		recreated := newTestProfile(name, "new")
+		if err := profileStore.CreateProfile(recreated); err != nil || recreated.Version != 1 {
+			t.Errorf("CreateProfile after delete: got version %d, error %v; want version 1", recreated.Version, err)
+		}
+	})
+}
+
+func TestUpdateProfileRace(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		profileStore := openStore(t, cfg, OpenProfileStore)
+		name := testID("profile")
+
+		if err := profileStore.CreateProfile(newTestProfile(name, "v1")); err != nil {
+			t.Fatalf("CreateProfile: %v", err)
+		}
+
+		const writers = 8
+		var wg sync.WaitGroup
+		for range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := profileStore.UpdateProfile(newTestProfile(name, "update")); err != nil {
+					t.Errorf("UpdateProfile: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		versions, err := profileStore.ListProfileVersions(name)
+		if err != nil {
+			t.Fatalf("ListProfileVersions: %v", err)
+		}
+		got := profileVersions(versions)
+		for i, version := range got {
+			if version != len(got)-i {
+				t.Fatalf("versions %v are not numbered without gaps or repeats", got)
+			}
+		}
+		if len(got) != writers+1 {
+			t.Fatalf("%d versions stored, want %d", len(got), writers+1)
+		}
+	})
+}
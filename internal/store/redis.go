@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -47,7 +48,11 @@ func (r *RedisClient) Set(key string, value any, expiration time.Duration) error
 }
 
 func (r *RedisClient) Get(key string) (string, error) {
-	return r.client.Get(r.ctx, key).Result()
+	value, err := r.client.Get(r.ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return value, err
 }
 
 func (r *RedisClient) HGet(key, field string) (string, error) {
@@ -62,6 +67,18 @@ func (r *RedisClient) Exists(keys ...string) (int64, error) {
 	return r.client.Exists(r.ctx, keys...).Result()
 }
 
+func (r *RedisClient) SetNX(key string, value any, expiration time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, key, value, expiration).Result()
+}
+
+func (r *RedisClient) Eval(script *redis.Script, keys []string, args ...any) (any, error) {
+	return script.Run(r.ctx, r.client, keys, args...).Result()
+}
+
+func (r *RedisClient) Watch(fn func(*redis.Tx) error, keys ...string) error {
+	return r.client.Watch(r.ctx, fn, keys...)
+}
+
 func (r *RedisClient) HSetWithExpire(key string, expiration time.Duration, values ...any) error {
 	pipe := r.client.Pipeline()
 	pipe.HSet(r.ctx, key, values...)
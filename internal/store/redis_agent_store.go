@@ -0,0 +1,387 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cugtyt/agentlauncher-distributed/internal/llminterface"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Conversations are stored as one list entry per message next to a version
+// counter. Both keys share the agent data TTL and every write refreshes all
+// three, so a lost AgentDeletedEvent never leaks keys.
+var appendMessagesScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[2]) or "0") ~= tonumber(ARGV[1]) then
+	return -1
+end
+for i = 3, #ARGV, 1000 do
+	redis.call("RPUSH", KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+local version = redis.call("INCR", KEYS[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[2])
+redis.call("PEXPIRE", KEYS[3], ARGV[2])
+return version
+`)
+
+var replaceMessagesScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[2]) or "0") ~= tonumber(ARGV[1]) then
+	return -1
+end
+redis.call("DEL", KEYS[1])
+for i = 3, #ARGV, 1000 do
+	redis.call("RPUSH", KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+local version = redis.call("INCR", KEYS[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[2])
+redis.call("PEXPIRE", KEYS[3], ARGV[2])
+return version
+`)
+
+// migrateLegacyConversationScript moves a transcript stored under the legacy
+// key into the list, unless the conversation was written or migrated since
+// the caller read the legacy value.
+var migrateLegacyConversationScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 or redis.call("GET", KEYS[3]) ~= ARGV[2] then
+	return 0
+end
+for i = 3, #ARGV, 1000 do
+	redis.call("RPUSH", KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+redis.call("SET", KEYS[2], 1, "PX", ARGV[1])
+redis.call("DEL", KEYS[3])
+return 1
+`)
+
+type RedisAgentStore struct {
+	redis *RedisClient
+}
+
+func (as *RedisAgentStore) agentDataKey(agentID string) string {
+	return fmt.Sprintf("%s:data", agentID)
+}
+
+// agentConversationKey held the whole transcript as a single JSON string
+// before messages moved to a list. GetConversation moves a transcript still
+// stored there into the list the first time it is read.
+func (as *RedisAgentStore) agentConversationKey(agentID string) string {
+	return fmt.Sprintf("%s:conversation", agentID)
+}
+
+func (as *RedisAgentStore) agentMessagesKey(agentID string) string {
+	return fmt.Sprintf("%s:messages", agentID)
+}
+
+func (as *RedisAgentStore) agentConversationVersionKey(agentID string) string {
+	return fmt.Sprintf("%s:conversation:version", agentID)
+}
+
+func (as *RedisAgentStore) agentLockKey(agentID string) string {
+	return fmt.Sprintf("%s:lock", agentID)
+}
+
+// sessionExpiryKey is a sorted set of session agent IDs scored by their
+// expiry in Unix milliseconds.
+const sessionExpiryKey = "sessions:expiry"
+
+func NewRedisAgentStore(redisURL string) (*RedisAgentStore, error) {
+	redisClient, err := NewRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisAgentStore{
+		redis: redisClient,
+	}, nil
+}
+
+func (as *RedisAgentStore) SetAgentData(agentID string, agentData *AgentData) error {
+	data, err := json.Marshal(agentData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent data: %w", err)
+	}
+
+	if err := as.redis.Set(as.agentDataKey(agentID), string(data), agentDataTTL); err != nil {
+		return fmt.Errorf("failed to store agent data: %w", err)
+	}
+
+	client, ctx := as.redis.GetClient(), as.redis.GetContext()
+	if agentData.IsSession() {
+		err = client.ZAdd(ctx, sessionExpiryKey, redis.Z{
+			Score:  float64(agentData.SessionExpiresAt.UnixMilli()),
+			Member: agentID,
+		}).Err()
+	} else {
+		err = client.ZRem(ctx, sessionExpiryKey, agentID).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store session expiry: %w", err)
+	}
+
+	return nil
+}
+
+func (as *RedisAgentStore) GetAgentData(agentID string) (*AgentData, error) {
+	data, err := as.redis.Get(as.agentDataKey(agentID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent data: %w", err)
+	}
+
+	var agent AgentData
+	if err := json.Unmarshal([]byte(data), &agent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal agent data: %w", err)
+	}
+
+	return &agent, nil
+}
+
+func (as *RedisAgentStore) GetConversation(agentID string) (*Conversation, error) {
+	ctx := as.redis.GetContext()
+
+	var versionCmd *redis.StringCmd
+	var messagesCmd *redis.StringSliceCmd
+	_, err := as.redis.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		versionCmd = pipe.Get(ctx, as.agentConversationVersionKey(agentID))
+		messagesCmd = pipe.LRange(ctx, as.agentMessagesKey(agentID), 0, -1)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		found, err := as.migrateLegacyConversation(agentID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("failed to get conversation: %w", ErrNotFound)
+		}
+		return as.GetConversation(agentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	version, err := versionCmd.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation version: %w", err)
+	}
+	messages, err := decodeMessages(messagesCmd.Val())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conversation{
+		Messages: messages,
+		Version:  version,
+	}, nil
+}
+
+// migrateLegacyConversation moves the transcript of an agent stored before
+// messages moved to a list, and reports whether there was one. The migrated
+// conversation starts at version 1.
+func (as *RedisAgentStore) migrateLegacyConversation(agentID string) (bool, error) {
+	legacy, err := as.redis.Get(as.agentConversationKey(agentID))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get legacy conversation: %w", err)
+	}
+
+	var messages []llminterface.Message
+	if err := json.Unmarshal([]byte(legacy), &messages); err != nil {
+		return false, fmt.Errorf("failed to unmarshal legacy conversation: %w", err)
+	}
+	args := []any{agentDataTTL.Milliseconds(), legacy}
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		args = append(args, string(data))
+	}
+
+	keys := []string{
+		as.agentMessagesKey(agentID),
+		as.agentConversationVersionKey(agentID),
+		as.agentConversationKey(agentID),
+	}
+	result, err := as.redis.Eval(migrateLegacyConversationScript, keys, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to migrate legacy conversation: %w", err)
+	}
+	if migrated, _ := result.(int64); migrated == 1 {
+		log.Printf("[%s] Migrated %d messages from the legacy conversation key", agentID, len(messages))
+	}
+	return true, nil
+}
+
+func (as *RedisAgentStore) GetMessages(agentID string, start, stop int64) ([]llminterface.Message, error) {
+	entries, err := as.redis.GetClient().LRange(as.redis.GetContext(), as.agentMessagesKey(agentID), start, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	return decodeMessages(entries)
+}
+
+func (as *RedisAgentStore) AppendConversation(agentID string, version int64, messages ...llminterface.Message) (int64, error) {
+	return as.writeConversation(appendMessagesScript, agentID, version, messages)
+}
+
+func (as *RedisAgentStore) ReplaceConversation(agentID string, version int64, messages []llminterface.Message) (int64, error) {
+	return as.writeConversation(replaceMessagesScript, agentID, version, messages)
+}
+
+// writeConversation only commits when the stored version still matches the
+// version the caller read; version 0 means the conversation must not exist yet.
+func (as *RedisAgentStore) writeConversation(script *redis.Script, agentID string, version int64, messages []llminterface.Message) (int64, error) {
+	args := []any{version, agentDataTTL.Milliseconds()}
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		args = append(args, string(data))
+	}
+
+	keys := []string{
+		as.agentMessagesKey(agentID),
+		as.agentConversationVersionKey(agentID),
+		as.agentDataKey(agentID),
+	}
+	result, err := as.redis.Eval(script, keys, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update conversation: %w", err)
+	}
+
+	next, ok := result.(int64)
+	if !ok || next < 0 {
+		return 0, fmt.Errorf("failed to update conversation: %w", ErrConversationConflict)
+	}
+	return next, nil
+}
+
+func decodeMessages(entries []string) ([]llminterface.Message, error) {
+	messages := make([]llminterface.Message, 0, len(entries))
+	for _, entry := range entries {
+		var message llminterface.Message
+		if err := json.Unmarshal([]byte(entry), &message); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (as *RedisAgentStore) Exists(agentID string) (bool, error) {
+	exists, err := as.redis.Exists(as.agentDataKey(agentID))
+	if err != nil {
+		return false, fmt.Errorf("failed to check if agent exists: %w", err)
+	}
+	return exists > 0, nil
+}
+
+func (as *RedisAgentStore) Delete(agentID string) error {
+	if err := as.redis.Del(as.agentDataKey(agentID)); err != nil {
+		return fmt.Errorf("failed to delete agent data: %w", err)
+	}
+
+	err := as.redis.Del(
+		as.agentMessagesKey(agentID),
+		as.agentConversationVersionKey(agentID),
+		as.agentConversationKey(agentID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete agent conversation: %w", err)
+	}
+
+	if err := as.redis.GetClient().ZRem(as.redis.GetContext(), sessionExpiryKey, agentID).Err(); err != nil {
+		return fmt.Errorf("failed to delete session expiry: %w", err)
+	}
+
+	return nil
+}
+
+// ExpiredSessions also forgets sessions whose agent data has already expired,
+// since nothing else removes them from the expiry set.
+func (as *RedisAgentStore) ExpiredSessions(now time.Time) ([]string, error) {
+	client, ctx := as.redis.GetClient(), as.redis.GetContext()
+	expired, err := client.ZRangeByScore(ctx, sessionExpiryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", now.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired sessions: %w", err)
+	}
+
+	var agentIDs []string
+	for _, agentID := range expired {
+		exists, err := as.Exists(agentID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			client.ZRem(ctx, sessionExpiryKey, agentID)
+			continue
+		}
+		agentIDs = append(agentIDs, agentID)
+	}
+	return agentIDs, nil
+}
+
+func (as *RedisAgentStore) CreateAgent(agentData *AgentData) error {
+	return as.SetAgentData(agentData.AgentID, agentData)
+}
+
+func (as *RedisAgentStore) GetAgent(agentID string) (*AgentData, error) {
+	return as.GetAgentData(agentID)
+}
+
+func (as *RedisAgentStore) UpdateAgent(agentData *AgentData) error {
+	return as.SetAgentData(agentData.AgentID, agentData)
+}
+
+func (as *RedisAgentStore) Lock(ctx context.Context, agentID string) (func(), error) {
+	lockKey := as.agentLockKey(agentID)
+	token := uuid.New().String()
+	backoff := 10 * time.Millisecond
+
+	for {
+		acquired, err := as.redis.SetNX(lockKey, token, agentLockTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire agent lock: %w", err)
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to acquire agent lock: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 200*time.Millisecond)
+	}
+
+	return func() {
+		if _, err := as.redis.Eval(releaseLockScript, []string{lockKey}, token); err != nil {
+			log.Printf("[%s] Failed to release agent lock: %v", agentID, err)
+		}
+	}, nil
+}
+
+func (as *RedisAgentStore) Close() error {
+	return as.redis.Close()
+}
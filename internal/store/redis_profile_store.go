@@ -0,0 +1,201 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisProfileStore struct {
+	redis *RedisClient
+}
+
+func NewRedisProfileStore(redisURL string) (*RedisProfileStore, error) {
+	redisClient, err := NewRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisProfileStore{
+		redis: redisClient,
+	}, nil
+}
+
+// Profiles do not expire. The versions of each are kept as JSON in a list,
+// oldest first, and the names are listed in one set.
+func (ps *RedisProfileStore) profileKey(name string) string {
+	return fmt.Sprintf("profile:%s", name)
+}
+
+func (ps *RedisProfileStore) profilesKey() string {
+	return "profiles"
+}
+
+// appendProfileVersionScript appends a version only if the profile still has
+// the number of versions the caller numbered it after.
+var appendProfileVersionScript = redis.NewScript(`
+if redis.call("LLEN", KEYS[1]) ~= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("RPUSH", KEYS[1], ARGV[2])
+redis.call("SADD", KEYS[2], ARGV[3])
+return 1
+`)
+
+func (ps *RedisProfileStore) appendVersion(profile *ProfileData) (bool, error) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal profile: %w", err)
+	}
+	result, err := ps.redis.Eval(appendProfileVersionScript,
+		[]string{ps.profileKey(profile.Name), ps.profilesKey()}, profile.Version-1, data, profile.Name)
+	if err != nil {
+		return false, err
+	}
+	appended, _ := result.(int64)
+	return appended == 1, nil
+}
+
+func (ps *RedisProfileStore) CreateProfile(profile *ProfileData) error {
+	profile.Version = 1
+	created, err := ps.appendVersion(profile)
+	if err != nil {
+		return fmt.Errorf("failed to create profile: %w", err)
+	}
+	if !created {
+		return fmt.Errorf("failed to create profile: %w", ErrAlreadyExists)
+	}
+	return nil
+}
+
+// UpdateProfile numbers the new version after the current count and tries
+// again when a concurrent update got there first.
+func (ps *RedisProfileStore) UpdateProfile(profile *ProfileData) error {
+	ctx := ps.redis.GetContext()
+	for {
+		count, err := ps.redis.GetClient().LLen(ctx, ps.profileKey(profile.Name)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to update profile: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("failed to update profile: %w", ErrNotFound)
+		}
+
+		profile.Version = int(count) + 1
+		appended, err := ps.appendVersion(profile)
+		if err != nil {
+			return fmt.Errorf("failed to update profile: %w", err)
+		}
+		if appended {
+			return nil
+		}
+	}
+}
+
+func (ps *RedisProfileStore) GetProfile(name string, version int) (*ProfileData, error) {
+	if version < 0 {
+		return nil, fmt.Errorf("failed to get profile: %w", ErrNotFound)
+	}
+	index := int64(version - 1)
+	if version == 0 {
+		index = -1
+	}
+	data, err := ps.redis.GetClient().LIndex(ps.redis.GetContext(), ps.profileKey(name), index).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to get profile: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	var profile ProfileData
+	if err := json.Unmarshal([]byte(data), &profile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal profile: %w", err)
+	}
+	return &profile, nil
+}
+
+func (ps *RedisProfileStore) ListProfiles() ([]ProfileData, error) {
+	ctx := ps.redis.GetContext()
+
+	names, err := ps.redis.GetClient().SMembers(ctx, ps.profilesKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	cmds := make([]*redis.StringCmd, len(names))
+	_, err = ps.redis.GetClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, name := range names {
+			cmds[i] = pipe.LIndex(ctx, ps.profileKey(name), -1)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	profiles := make([]ProfileData, 0, len(names))
+	for _, cmd := range cmds {
+		data, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list profiles: %w", err)
+		}
+		var profile ProfileData
+		if err := json.Unmarshal([]byte(data), &profile); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal profile: %w", err)
+		}
+		profiles = append(profiles, profile)
+	}
+	sortProfiles(profiles)
+	return profiles, nil
+}
+
+func (ps *RedisProfileStore) ListProfileVersions(name string) ([]ProfileData, error) {
+	entries, err := ps.redis.GetClient().LRange(ps.redis.GetContext(), ps.profileKey(name), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profile versions: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("failed to list profile versions: %w", ErrNotFound)
+	}
+
+	versions := make([]ProfileData, len(entries))
+	for i, entry := range entries {
+		if err := json.Unmarshal([]byte(entry), &versions[i]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal profile: %w", err)
+		}
+	}
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+func (ps *RedisProfileStore) DeleteProfile(name string) error {
+	ctx := ps.redis.GetContext()
+
+	var deleted *redis.IntCmd
+	_, err := ps.redis.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, ps.profileKey(name))
+		pipe.SRem(ctx, ps.profilesKey(), name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete profile: %w", err)
+	}
+	if deleted.Val() == 0 {
+		return fmt.Errorf("failed to delete profile: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (ps *RedisProfileStore) HealthCheck() error {
+	return ps.redis.Ping()
+}
+
+func (ps *RedisProfileStore) Close() error {
+	return ps.redis.Close()
+}
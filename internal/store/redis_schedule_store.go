@@ -0,0 +1,322 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisScheduleStore struct {
+	redis *RedisClient
+}
+
+func NewRedisScheduleStore(redisURL string) (*RedisScheduleStore, error) {
+	redisClient, err := NewRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisScheduleStore{
+		redis: redisClient,
+	}, nil
+}
+
+// Schedules do not expire. Each is kept as JSON, listed in one set and has
+// its run history in a capped list, newest first.
+func (ss *RedisScheduleStore) scheduleKey(scheduleID string) string {
+	return fmt.Sprintf("schedule:%s", scheduleID)
+}
+
+func (ss *RedisScheduleStore) scheduleRunsKey(scheduleID string) string {
+	return fmt.Sprintf("schedule:%s:runs", scheduleID)
+}
+
+func (ss *RedisScheduleStore) schedulesKey() string {
+	return "schedules"
+}
+
+// pendingRunsKey is a sorted set of pending runs, as "<schedule ID> <agent
+// ID>", scored by when they started in Unix milliseconds.
+func (ss *RedisScheduleStore) pendingRunsKey() string {
+	return "schedules:pending-runs"
+}
+
+func pendingRunMember(run ScheduleRun) string {
+	return run.ScheduleID + " " + run.AgentID
+}
+
+func (ss *RedisScheduleStore) CreateSchedule(schedule *ScheduleData) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+
+	ctx := ss.redis.GetContext()
+	_, err = ss.redis.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, ss.scheduleKey(schedule.ScheduleID), data, 0)
+		pipe.SAdd(ctx, ss.schedulesKey(), schedule.ScheduleID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return nil
+}
+
+func (ss *RedisScheduleStore) GetSchedule(scheduleID string) (*ScheduleData, error) {
+	data, err := ss.redis.Get(ss.scheduleKey(scheduleID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+
+	var schedule ScheduleData
+	if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+func (ss *RedisScheduleStore) ListSchedules() ([]ScheduleData, error) {
+	ctx := ss.redis.GetContext()
+
+	scheduleIDs, err := ss.redis.GetClient().SMembers(ctx, ss.schedulesKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	if len(scheduleIDs) == 0 {
+		return []ScheduleData{}, nil
+	}
+
+	keys := make([]string, len(scheduleIDs))
+	for i, scheduleID := range scheduleIDs {
+		keys[i] = ss.scheduleKey(scheduleID)
+	}
+	values, err := ss.redis.GetClient().MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+
+	schedules := make([]ScheduleData, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var schedule ScheduleData
+		if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	sortSchedules(schedules)
+	return schedules, nil
+}
+
+func (ss *RedisScheduleStore) UpdateSchedule(schedule *ScheduleData) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+
+	updated, err := ss.redis.GetClient().SetXX(ss.redis.GetContext(), ss.scheduleKey(schedule.ScheduleID), data, redis.KeepTTL).Result()
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+	if !updated {
+		return fmt.Errorf("failed to update schedule: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (ss *RedisScheduleStore) DeleteSchedule(scheduleID string) error {
+	ctx := ss.redis.GetContext()
+
+	var deleted *redis.IntCmd
+	_, err := ss.redis.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, ss.scheduleKey(scheduleID))
+		pipe.Del(ctx, ss.scheduleRunsKey(scheduleID))
+		pipe.SRem(ctx, ss.schedulesKey(), scheduleID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	if deleted.Val() == 0 {
+		return fmt.Errorf("failed to delete schedule: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// ClaimScheduleRun compares and sets the next run under WATCH, and records
+// the run in the same transaction.
+func (ss *RedisScheduleStore) ClaimScheduleRun(run ScheduleRun, next time.Time) (bool, error) {
+	ctx := ss.redis.GetContext()
+	key := ss.scheduleKey(run.ScheduleID)
+
+	runData, err := json.Marshal(run)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal schedule run: %w", err)
+	}
+
+	claimed := false
+	err = ss.redis.Watch(func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var schedule ScheduleData
+		if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+			return fmt.Errorf("failed to unmarshal schedule: %w", err)
+		}
+		if !claimScheduleRun(&schedule, run.ScheduledAt, next) {
+			return nil
+		}
+		updated, err := json.Marshal(&schedule)
+		if err != nil {
+			return fmt.Errorf("failed to marshal schedule: %w", err)
+		}
+
+		runsKey := ss.scheduleRunsKey(run.ScheduleID)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, updated, 0)
+			pipe.LPush(ctx, runsKey, runData)
+			pipe.LTrim(ctx, runsKey, 0, MaxScheduleRuns-1)
+			ss.trackPendingRun(ctx, pipe, run)
+			return nil
+		})
+		claimed = err == nil
+		return err
+	}, key)
+
+	// Losing the race to another replica is not an error.
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim schedule run: %w", err)
+	}
+	return claimed, nil
+}
+
+// UpdateScheduleRun rewrites the run in place in the history list under
+// WATCH.
+func (ss *RedisScheduleStore) UpdateScheduleRun(run ScheduleRun, startedAt time.Time) (bool, error) {
+	ctx := ss.redis.GetContext()
+	runsKey := ss.scheduleRunsKey(run.ScheduleID)
+
+	runData, err := json.Marshal(run)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal schedule run: %w", err)
+	}
+
+	updated := false
+	err = ss.redis.Watch(func(tx *redis.Tx) error {
+		i, stored, err := ss.findRun(tx, runsKey, run.AgentID)
+		if err != nil || i < 0 || !updateScheduleRun(&stored, run, startedAt) {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LSet(ctx, runsKey, int64(i), runData)
+			ss.trackPendingRun(ctx, pipe, run)
+			return nil
+		})
+		updated = err == nil
+		return err
+	}, runsKey)
+
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update schedule run: %w", err)
+	}
+	return updated, nil
+}
+
+// PendingScheduleRuns forgets pending runs that are no longer in their
+// schedule's history, e.g. because the schedule was deleted.
+func (ss *RedisScheduleStore) PendingScheduleRuns(startedBefore time.Time) ([]ScheduleRun, error) {
+	ctx := ss.redis.GetContext()
+	client := ss.redis.GetClient()
+
+	members, err := client.ZRangeByScore(ctx, ss.pendingRunsKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", startedBefore.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending schedule runs: %w", err)
+	}
+
+	var pending []ScheduleRun
+	for _, member := range members {
+		scheduleID, agentID, _ := strings.Cut(member, " ")
+		i, run, err := ss.findRun(client, ss.scheduleRunsKey(scheduleID), agentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pending schedule runs: %w", err)
+		}
+		if i < 0 || !run.Pending {
+			client.ZRem(ctx, ss.pendingRunsKey(), member)
+			continue
+		}
+		pending = append(pending, run)
+	}
+	return pending, nil
+}
+
+func (ss *RedisScheduleStore) trackPendingRun(ctx context.Context, pipe redis.Pipeliner, run ScheduleRun) {
+	if run.Pending {
+		pipe.ZAdd(ctx, ss.pendingRunsKey(), redis.Z{Score: float64(run.StartedAt.UnixMilli()), Member: pendingRunMember(run)})
+	} else {
+		pipe.ZRem(ctx, ss.pendingRunsKey(), pendingRunMember(run))
+	}
+}
+
+// findRun returns the index of the agent's run in the history list, or -1.
+func (ss *RedisScheduleStore) findRun(client redis.Cmdable, runsKey, agentID string) (int, ScheduleRun, error) {
+	entries, err := client.LRange(ss.redis.GetContext(), runsKey, 0, -1).Result()
+	if err != nil {
+		return -1, ScheduleRun{}, err
+	}
+	for i, entry := range entries {
+		var run ScheduleRun
+		if err := json.Unmarshal([]byte(entry), &run); err != nil {
+			return -1, ScheduleRun{}, fmt.Errorf("failed to unmarshal schedule run: %w", err)
+		}
+		if run.AgentID == agentID {
+			return i, run, nil
+		}
+	}
+	return -1, ScheduleRun{}, nil
+}
+
+func (ss *RedisScheduleStore) ListScheduleRuns(scheduleID string, limit int) ([]ScheduleRun, error) {
+	entries, err := ss.redis.GetClient().LRange(ss.redis.GetContext(), ss.scheduleRunsKey(scheduleID), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedule runs: %w", err)
+	}
+
+	runs := make([]ScheduleRun, len(entries))
+	for i, entry := range entries {
+		if err := json.Unmarshal([]byte(entry), &runs[i]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule run: %w", err)
+		}
+	}
+	return runs, nil
+}
+
+func (ss *RedisScheduleStore) HealthCheck() error {
+	return ss.redis.Ping()
+}
+
+func (ss *RedisScheduleStore) Close() error {
+	return ss.redis.Close()
+}
@@ -0,0 +1,476 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	taskIndexScanBatch    = 100
+	taskIndexScanLimit    = 1000
+	maxTaskUpdateAttempts = 5
+)
+
+type RedisTaskStore struct {
+	redis *RedisClient
+	// scanLimit caps the index entries one ListTasks call reads.
+	scanLimit int
+}
+
+func NewRedisTaskStore(redisURL string) (*RedisTaskStore, error) {
+	redisClient, err := NewRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisTaskStore{
+		redis:     redisClient,
+		scanLimit: taskIndexScanLimit,
+	}, nil
+}
+
+func (ts *RedisTaskStore) taskKey(agentID string) string {
+	return fmt.Sprintf("task:%s", agentID)
+}
+
+// A batch is stored as JSON next to a hash of its item count and launched
+// items, which claimBatchItemScript advances so that replicas never launch an
+// item twice. Batches with items left to claim are kept in a set until the
+// script takes their last item.
+func (ts *RedisTaskStore) batchKey(batchID string) string {
+	return fmt.Sprintf("batch:%s", batchID)
+}
+
+func (ts *RedisTaskStore) batchProgressKey(batchID string) string {
+	return fmt.Sprintf("batch:%s:progress", batchID)
+}
+
+const activeBatchesKey = "batches:active"
+
+var claimBatchItemScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -2
+end
+local launched = tonumber(redis.call("HGET", KEYS[1], "launched"))
+if launched >= tonumber(redis.call("HGET", KEYS[1], "total")) then
+	return -1
+end
+redis.call("HINCRBY", KEYS[1], "launched", 1)
+if launched + 1 >= tonumber(redis.call("HGET", KEYS[1], "total")) then
+	redis.call("SREM", KEYS[2], ARGV[1])
+end
+return launched
+`)
+
+func (ts *RedisTaskStore) idempotencyKey(key string) string {
+	return fmt.Sprintf("task-idempotency:%s", key)
+}
+
+// Tasks are indexed in sorted sets scored by creation time in microseconds:
+// one over all tasks, one per status and one per owner. Entries outlive the
+// task keys they point to, so writes trim them by age and reads drop any
+// entry whose task has expired.
+func (ts *RedisTaskStore) createdIndexKey() string {
+	return "tasks:index:created"
+}
+
+func (ts *RedisTaskStore) statusIndexKey(status string) string {
+	return fmt.Sprintf("tasks:index:status:%s", status)
+}
+
+func (ts *RedisTaskStore) ownerIndexKey(owner string) string {
+	return fmt.Sprintf("tasks:index:owner:%s", owner)
+}
+
+func (ts *RedisTaskStore) indexKeys(task *TaskData) []string {
+	keys := []string{ts.createdIndexKey(), ts.statusIndexKey(task.Status)}
+	if task.Owner != "" {
+		keys = append(keys, ts.ownerIndexKey(task.Owner))
+	}
+	return keys
+}
+
+// writeTask queues the task write and moves it from previousStatus's index
+// to its current one; callers run it inside a transaction.
+func (ts *RedisTaskStore) writeTask(pipe redis.Pipeliner, task *TaskData, previousStatus string) error {
+	jsonData, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task data: %w", err)
+	}
+
+	ctx := ts.redis.GetContext()
+	score := float64(task.CreatedAt.UnixMicro())
+	expired := strconv.FormatInt(time.Now().Add(-taskTTL).UnixMicro(), 10)
+
+	pipe.Set(ctx, ts.taskKey(task.AgentID), jsonData, taskTTL)
+	if previousStatus != "" && previousStatus != task.Status {
+		pipe.ZRem(ctx, ts.statusIndexKey(previousStatus), task.AgentID)
+	}
+	for _, key := range ts.indexKeys(task) {
+		pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: task.AgentID})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+expired)
+		pipe.Expire(ctx, key, taskTTL)
+	}
+	return nil
+}
+
+func (ts *RedisTaskStore) CreateTask(spec TaskSpec) error {
+	taskData := newTask(spec)
+
+	_, err := ts.redis.GetClient().TxPipelined(ts.redis.GetContext(), func(pipe redis.Pipeliner) error {
+		return ts.writeTask(pipe, &taskData, "")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTask applies the transition under WATCH so that concurrent updates
+// from different launcher replicas cannot overwrite each other.
+func (ts *RedisTaskStore) UpdateTask(agentID string, update TaskUpdate) (*TaskData, error) {
+	task, err := ts.modifyTask(agentID, func(task *TaskData) error {
+		return applyTaskUpdate(task, update)
+	})
+	if errors.Is(err, ErrTaskFinished) || errors.Is(err, ErrTaskActive) || errors.Is(err, ErrStaleUpdate) {
+		return task, fmt.Errorf("failed to update task: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update task: %w", err)
+	}
+	return task, nil
+}
+
+func (ts *RedisTaskStore) UpdateCallbackDelivery(agentID string, delivery CallbackDelivery) error {
+	_, err := ts.modifyTask(agentID, func(task *TaskData) error {
+		return applyCallbackDelivery(task, delivery)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update callback delivery: %w", err)
+	}
+	return nil
+}
+
+// modifyTask reads, changes and writes the task under WATCH, retrying when
+// another writer got in between. The task is returned even when apply fails.
+func (ts *RedisTaskStore) modifyTask(agentID string, apply func(task *TaskData) error) (*TaskData, error) {
+	ctx := ts.redis.GetContext()
+	taskKey := ts.taskKey(agentID)
+
+	var task TaskData
+	for attempt := 0; attempt < maxTaskUpdateAttempts; attempt++ {
+		err := ts.redis.Watch(func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, taskKey).Result()
+			if errors.Is(err, redis.Nil) {
+				return ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			task = TaskData{}
+			if err := json.Unmarshal([]byte(data), &task); err != nil {
+				return fmt.Errorf("failed to unmarshal task data: %w", err)
+			}
+
+			previousStatus := task.Status
+			if err := apply(&task); err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return ts.writeTask(pipe, &task, previousStatus)
+			})
+			return err
+		}, taskKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return &task, err
+	}
+
+	return nil, redis.TxFailedErr
+}
+
+func (ts *RedisTaskStore) ReserveIdempotencyKey(record IdempotencyRecord) (*IdempotencyRecord, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+	key := ts.idempotencyKey(record.Key)
+
+	// The existing record may expire between SETNX and GET; try again then.
+	for attempt := 0; attempt < maxTaskUpdateAttempts; attempt++ {
+		reserved, err := ts.redis.SetNX(key, data, time.Until(record.ExpiresAt))
+		if err != nil {
+			return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+		}
+		if reserved {
+			return nil, nil
+		}
+
+		existingData, err := ts.redis.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get idempotency record: %w", err)
+		}
+		var existing IdempotencyRecord
+		if err := json.Unmarshal([]byte(existingData), &existing); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+		}
+		return &existing, nil
+	}
+
+	return nil, fmt.Errorf("failed to reserve idempotency key %s", record.Key)
+}
+
+func (ts *RedisTaskStore) ReleaseIdempotencyKey(key string) error {
+	if err := ts.redis.Del(ts.idempotencyKey(key)); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (ts *RedisTaskStore) CreateBatch(batch *BatchData) error {
+	jsonData, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch data: %w", err)
+	}
+
+	ctx := ts.redis.GetContext()
+	_, err = ts.redis.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, ts.batchKey(batch.BatchID), jsonData, taskTTL)
+		pipe.HSet(ctx, ts.batchProgressKey(batch.BatchID), "total", len(batch.Items), "launched", batch.Launched)
+		pipe.Expire(ctx, ts.batchProgressKey(batch.BatchID), taskTTL)
+		if batch.Launched < len(batch.Items) {
+			pipe.SAdd(ctx, activeBatchesKey, batch.BatchID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create batch: %w", err)
+	}
+	return nil
+}
+
+func (ts *RedisTaskStore) GetBatch(batchID string) (*BatchData, error) {
+	data, err := ts.redis.Get(ts.batchKey(batchID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch: %w", err)
+	}
+
+	var batch BatchData
+	if err := json.Unmarshal([]byte(data), &batch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal batch data: %w", err)
+	}
+
+	launched, err := ts.redis.HGet(ts.batchProgressKey(batchID), "launched")
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to get batch progress: %w", err)
+	}
+	if launched != "" {
+		if batch.Launched, err = strconv.Atoi(launched); err != nil {
+			return nil, fmt.Errorf("failed to parse batch progress: %w", err)
+		}
+	}
+	return &batch, nil
+}
+
+func (ts *RedisTaskStore) ClaimBatchItem(batchID string) (int, error) {
+	result, err := ts.redis.Eval(claimBatchItemScript,
+		[]string{ts.batchProgressKey(batchID), activeBatchesKey}, batchID)
+	if err != nil {
+		return -1, fmt.Errorf("failed to claim batch item: %w", err)
+	}
+	index, _ := result.(int64)
+	if index == -2 {
+		return -1, fmt.Errorf("failed to claim batch item: %w", ErrNotFound)
+	}
+	return int(index), nil
+}
+
+// ActiveBatches also forgets batches that expired before their last item was
+// claimed, since nothing else removes them from the set.
+func (ts *RedisTaskStore) ActiveBatches() ([]string, error) {
+	client, ctx := ts.redis.GetClient(), ts.redis.GetContext()
+	active, err := client.SMembers(ctx, activeBatchesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active batches: %w", err)
+	}
+
+	var batchIDs []string
+	for _, batchID := range active {
+		exists, err := ts.redis.Exists(ts.batchProgressKey(batchID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check batch: %w", err)
+		}
+		if exists == 0 {
+			client.SRem(ctx, activeBatchesKey, batchID)
+			continue
+		}
+		batchIDs = append(batchIDs, batchID)
+	}
+	return batchIDs, nil
+}
+
+func (ts *RedisTaskStore) GetTask(agentID string) (*TaskData, error) {
+	data, err := ts.redis.Get(ts.taskKey(agentID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	var task TaskData
+	if err := json.Unmarshal([]byte(data), &task); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal task data: %w", err)
+	}
+
+	return &task, nil
+}
+
+func (ts *RedisTaskStore) ListTasks(filter TaskFilter) (*TaskPage, error) {
+	cursor, err := decodeTaskCursor(filter.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	// Scan the most selective index; the remaining criteria are checked
+	// against each task as it is loaded.
+	index := ts.createdIndexKey()
+	switch {
+	case filter.Status != "":
+		index = ts.statusIndexKey(filter.Status)
+	case filter.Owner != "":
+		index = ts.ownerIndexKey(filter.Owner)
+	}
+
+	maxScore := "+inf"
+	if !filter.CreatedBefore.IsZero() {
+		maxScore = "(" + strconv.FormatInt(filter.CreatedBefore.UnixMicro(), 10)
+	}
+	if cursor != nil && (filter.CreatedBefore.IsZero() || cursor.CreatedAt.Before(filter.CreatedBefore)) {
+		maxScore = strconv.FormatInt(cursor.CreatedAt.UnixMicro(), 10)
+	}
+	minScore := "-inf"
+	if !filter.CreatedAfter.IsZero() {
+		minScore = strconv.FormatInt(filter.CreatedAfter.UnixMicro(), 10)
+	}
+
+	ctx := ts.redis.GetContext()
+	client := ts.redis.GetClient()
+	limit := filter.limit()
+
+	// A filter the index does not narrow can match few of its entries, so
+	// the scan stops after scanLimit of them. If the page is not full by
+	// then it ends early, with a cursor at the last entry scanned.
+	var tasks []TaskData
+	var stale []any
+	var last *TaskData
+	scanned := 0
+	for offset := int64(0); len(tasks) <= limit && scanned < ts.scanLimit; offset += taskIndexScanBatch {
+		entries, err := client.ZRevRangeByScoreWithScores(ctx, index, &redis.ZRangeBy{
+			Max:    maxScore,
+			Min:    minScore,
+			Offset: offset,
+			Count:  min(taskIndexScanBatch, int64(ts.scanLimit-scanned)),
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		if len(entries) == 0 {
+			last = nil
+			break
+		}
+		scanned += len(entries)
+
+		agentIDs := make([]string, len(entries))
+		keys := make([]string, len(entries))
+		for i, entry := range entries {
+			agentIDs[i] = entry.Member.(string)
+			keys[i] = ts.taskKey(agentIDs[i])
+		}
+		last = &TaskData{
+			AgentID:   agentIDs[len(entries)-1],
+			CreatedAt: time.UnixMicro(int64(entries[len(entries)-1].Score)).UTC(),
+		}
+		values, err := client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+
+		for i, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				stale = append(stale, agentIDs[i])
+				continue
+			}
+			var task TaskData
+			if err := json.Unmarshal([]byte(data), &task); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal task data: %w", err)
+			}
+			if filter.Matches(&task) && cursor.after(&task) {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+
+	if len(stale) > 0 {
+		client.ZRem(ctx, index, stale...)
+	}
+
+	sortTasks(tasks)
+	if len(tasks) > limit+1 {
+		tasks = tasks[:limit+1]
+	}
+	page := newTaskPage(tasks, limit)
+	if page.NextCursor == "" && scanned >= ts.scanLimit && last != nil {
+		page.NextCursor = encodeTaskCursor(last)
+	}
+	return page, nil
+}
+
+func (ts *RedisTaskStore) DeleteTask(agentID string) error {
+	task, err := ts.GetTask(agentID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+
+	ctx := ts.redis.GetContext()
+	_, err = ts.redis.GetClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, ts.taskKey(agentID))
+		for _, key := range ts.indexKeys(task) {
+			pipe.ZRem(ctx, key, agentID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+	return nil
+}
+
+func (ts *RedisTaskStore) TaskExists(agentID string) (bool, error) {
+	exists, err := ts.redis.Exists(ts.taskKey(agentID))
+	if err != nil {
+		return false, fmt.Errorf("failed to check if task exists: %w", err)
+	}
+	return exists > 0, nil
+}
+
+func (ts *RedisTaskStore) HealthCheck() error {
+	return ts.redis.Ping()
+}
+
+func (ts *RedisTaskStore) Close() error {
+	return ts.redis.Close()
+}
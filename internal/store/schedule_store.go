@@ -0,0 +1,87 @@
+package store
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// MaxScheduleRuns is how many past runs are kept per schedule.
+const MaxScheduleRuns = 100
+
+// ScheduleData is a task template that is launched on a cron expression or
+// a fixed interval. Task holds the launcher's request, which the store does
+// not interpret.
+type ScheduleData struct {
+	ScheduleID string          `json:"schedule_id"`
+	Name       string          `json:"name,omitempty"`
+	Cron       string          `json:"cron,omitempty"`
+	Interval   string          `json:"interval,omitempty"`
+	Timezone   string          `json:"timezone,omitempty"`
+	Task       json.RawMessage `json:"task"`
+	Enabled    bool            `json:"enabled"`
+	NextRunAt  time.Time       `json:"next_run_at"`
+	LastRunAt  *time.Time      `json:"last_run_at,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ScheduleRun records one firing of a schedule. A run is Pending from the
+// moment it is claimed until its task has been launched; Error is set when
+// the task could not be launched.
+type ScheduleRun struct {
+	ScheduleID  string    `json:"schedule_id"`
+	AgentID     string    `json:"agent_id"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	StartedAt   time.Time `json:"started_at"`
+	Pending     bool      `json:"pending,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type ScheduleStore interface {
+	CreateSchedule(schedule *ScheduleData) error
+	GetSchedule(scheduleID string) (*ScheduleData, error)
+	ListSchedules() ([]ScheduleData, error)
+	UpdateSchedule(schedule *ScheduleData) error
+	DeleteSchedule(scheduleID string) error
+	// ClaimScheduleRun moves the schedule's next run from run.ScheduledAt to
+	// next and records run, provided no one else has moved it yet. Replicas
+	// that find the same schedule due race on this, and only the one that
+	// wins fires it.
+	ClaimScheduleRun(run ScheduleRun, next time.Time) (bool, error)
+	// UpdateScheduleRun replaces a pending run, provided it still started at
+	// startedAt. Replicas retrying the same stale run race on this as well.
+	UpdateScheduleRun(run ScheduleRun, startedAt time.Time) (bool, error)
+	// PendingScheduleRuns lists the pending runs started before startedBefore.
+	PendingScheduleRuns(startedBefore time.Time) ([]ScheduleRun, error)
+	// ListScheduleRuns returns the most recent runs first.
+	ListScheduleRuns(scheduleID string, limit int) ([]ScheduleRun, error)
+	HealthCheck() error
+	Close() error
+}
+
+// claimScheduleRun applies a claim to a schedule read by the caller.
+func claimScheduleRun(schedule *ScheduleData, due, next time.Time) bool {
+	if !schedule.Enabled || !schedule.NextRunAt.Equal(due) {
+		return false
+	}
+	schedule.LastRunAt = &due
+	schedule.NextRunAt = next
+	return true
+}
+
+// updateScheduleRun applies an update to a run read by the caller.
+func updateScheduleRun(stored *ScheduleRun, run ScheduleRun, startedAt time.Time) bool {
+	if !stored.Pending || !stored.StartedAt.Equal(startedAt) {
+		return false
+	}
+	*stored = run
+	return true
+}
+
+func sortSchedules(schedules []ScheduleData) {
+	slices.SortFunc(schedules, func(a, b ScheduleData) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ScheduleID, b.ScheduleID))
+	})
+}
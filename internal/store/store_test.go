@@ -0,0 +1,54 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// forEachBackend runs a store contract test against every backend. The
+// memory backend always runs; Redis and Postgres run when
+// STORE_TEST_REDIS_URL or STORE_TEST_POSTGRES_URL points at a scratch server,
+// which the tests write to but never clear.
+func forEachBackend(t *testing.T, test func(t *testing.T, cfg Config)) {
+	backends := []struct {
+		backend Backend
+		env     string
+	}{
+		{BackendMemory, ""},
+		{BackendRedis, "STORE_TEST_REDIS_URL"},
+		{BackendPostgres, "STORE_TEST_POSTGRES_URL"},
+	}
+	for _, b := range backends {
+		t.Run(string(b.backend), func(t *testing.T) {
+			cfg := Config{Backend: b.backend}
+			if b.env != "" {
+				url := os.Getenv(b.env)
+				if url == "" {
+					t.Skipf("%s is not set", b.env)
+				}
+				cfg.RedisURL, cfg.PostgresURL = url, url
+			}
+			test(t, cfg)
+		})
+	}
+}
+
+// openStore opens a store for a contract test and closes it when the test
+// ends.
+func openStore[S interface{ Close() error }](t *testing.T, cfg Config, open func(Config) (S, error)) S {
+	t.Helper()
+
+	s, err := open(cfg)
+	if err != nil {
+		t.Fatalf("failed to open %s store: %v", cfg.Backend, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// testID returns an ID no earlier run left behind in a persistent backend.
+func testID(prefix string) string {
+	return prefix + "-" + uuid.NewString()
+}
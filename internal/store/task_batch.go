@@ -0,0 +1,25 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BatchData is a group of tasks submitted together. Items are launched in
+// order, Launched of them so far; the rest wait for a slot under the batch's
+// concurrency limit.
+type BatchData struct {
+	BatchID     string      `json:"batch_id"`
+	Owner       string      `json:"owner,omitempty"`
+	Concurrency int         `json:"concurrency,omitempty"`
+	Items       []BatchItem `json:"items"`
+	Launched    int         `json:"launched"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// BatchItem holds the agent ID reserved for one task of the batch and the
+// launcher's request for it, which the store does not interpret.
+type BatchItem struct {
+	AgentID string          `json:"agent_id"`
+	Request json.RawMessage `json:"request"`
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBatch(t *testing.T, taskStore TaskStore, items, launched int) *BatchData {
+	t.Helper()
+
+	batch := &BatchData{
+		BatchID:     testID("batch"),
+		Concurrency: 2,
+		Items:       make([]BatchItem, items),
+		Launched:    launched,
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+	}
+	for i := range batch.Items {
+		batch.Items[i] = BatchItem{AgentID: testID("agent"), Request: []byte(`{"task":"report"}`)}
+	}
+	if err := taskStore.CreateBatch(batch); err != nil {
+		t.Fatalf("CreateBatch: %v", err)
+	}
+	return batch
+}
+
+func isActiveBatch(t *testing.T, taskStore TaskStore, batchID string) bool {
+	t.Helper()
+
+	batchIDs, err := taskStore.ActiveBatches()
+	if err != nil {
+		t.Fatalf("ActiveBatches: %v", err)
+	}
+	return slices.Contains(batchIDs, batchID)
+}
+
+func TestClaimBatchItem(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		batch := newTestBatch(t, taskStore, 4, 2)
+
+		if _, err := taskStore.ClaimBatchItem(testID("batch")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("ClaimBatchItem of a missing batch: got %v, want ErrNotFound", err)
+		}
+
+		claims := []struct {
+			index  int
+			active bool
+		}{
+			{2, true},
+			{3, false},
+			{-1, false},
+			{-1, false},
+		}
+		if !isActiveBatch(t, taskStore, batch.BatchID) {
+			t.Fatal("new batch with items left is not active")
+		}
+		for i, claim := range claims {
+			index, err := taskStore.ClaimBatchItem(batch.BatchID)
+			if err != nil {
+				t.Fatalf("claim %d: %v", i, err)
+			}
+			if index != claim.index {
+				t.Fatalf("claim %d took item %d, want %d", i, index, claim.index)
+			}
+			if active := isActiveBatch(t, taskStore, batch.BatchID); active != claim.active {
+				t.Fatalf("after claim %d the batch is active %v, want %v", i, active, claim.active)
+			}
+		}
+
+		stored, err := taskStore.GetBatch(batch.BatchID)
+		if err != nil {
+			t.Fatalf("GetBatch: %v", err)
+		}
+		if stored.Launched != len(batch.Items) || len(stored.Items) != len(batch.Items) ||
+			stored.Items[3].AgentID != batch.Items[3].AgentID {
+			t.Fatalf("stored batch %+v, want all %d items launched", stored, len(batch.Items))
+		}
+	})
+}
+
+func TestClaimBatchItemFullyLaunched(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		batch := newTestBatch(t, taskStore, 2, 2)
+
+		if isActiveBatch(t, taskStore, batch.BatchID) {
+			t.Fatal("batch launched in full is active")
+		}
+		if index, err := taskStore.ClaimBatchItem(batch.BatchID); err != nil || index != -1 {
+			t.Fatalf("ClaimBatchItem: got %d, %v; want -1", index, err)
+		}
+	})
+}
+
+func TestClaimBatchItemRace(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		batch := newTestBatch(t, taskStore, 10, 0)
+
+		const claimers = 16
+		var wg sync.WaitGroup
+		indexes := make(chan int, claimers)
+		for range claimers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				index, err := taskStore.ClaimBatchItem(batch.BatchID)
+				if err != nil {
+					t.Errorf("ClaimBatchItem: %v", err)
+					return
+				}
+				indexes <- index
+			}()
+		}
+		wg.Wait()
+		close(indexes)
+
+		var claimed []int
+		exhausted := 0
+		for index := range indexes {
+			if index < 0 {
+				exhausted++
+				continue
+			}
+			claimed = append(claimed, index)
+		}
+		slices.Sort(claimed)
+		if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !slices.Equal(claimed, want) {
+			t.Fatalf("claimed items %v, want each of %v once", claimed, want)
+		}
+		if exhausted != claimers-len(batch.Items) {
+			t.Fatalf("%d claims found the batch exhausted, want %d", exhausted, claimers-len(batch.Items))
+		}
+	})
+}
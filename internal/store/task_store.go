@@ -1,154 +1,370 @@
 package store
 
 import (
-	"encoding/json"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	taskTTL          = 12 * time.Hour
+	DefaultTaskLimit = 50
+	MaxTaskLimit     = 200
+)
+
+const (
+	TaskStatusQueued             = "queued"
+	TaskStatusRunning            = "running"
+	TaskStatusWaitingForTool     = "waiting_for_tool"
+	TaskStatusWaitingForSubagent = "waiting_for_subagent"
+	TaskStatusWaitingForApproval = "waiting_for_approval"
+	TaskStatusWaitingForInput    = "waiting_for_input"
+	TaskStatusSucceeded          = "succeeded"
+	TaskStatusFailed             = "failed"
+	TaskStatusCancelled          = "cancelled"
+	TaskStatusTimedOut           = "timed_out"
+)
+
+const (
+	CallbackStatusPending   = "pending"
+	CallbackStatusDelivered = "delivered"
+	CallbackStatusFailed    = "failed"
+)
+
+// ActiveTaskStatuses are the states a task can still leave.
+var ActiveTaskStatuses = []string{
+	TaskStatusQueued,
+	TaskStatusRunning,
+	TaskStatusWaitingForTool,
+	TaskStatusWaitingForSubagent,
+	TaskStatusWaitingForApproval,
+	TaskStatusWaitingForInput,
+}
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrTaskFinished  = errors.New("task already finished")
+	ErrTaskActive    = errors.New("task still active")
+	ErrStaleUpdate   = errors.New("task already updated by a later event")
+)
+
 type TaskData struct {
-	AgentID string `json:"agent_id"`
-	Task    string `json:"task"`
-	Status  string `json:"status"` // "pending", "success", "failed"
-	Result  string `json:"result,omitempty"`
+	AgentID    string        `json:"agent_id"`
+	Task       string        `json:"task"`
+	Owner      string        `json:"owner,omitempty"`
+	BatchID    string        `json:"batch_id,omitempty"`
+	Profile    *ProfileRef   `json:"profile,omitempty"`
+	Status     string        `json:"status"`
+	Result     string        `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Callback   *TaskCallback `json:"callback,omitempty"`
+	// EventSeq is the sequence of the latest event that updated the task.
+	EventSeq uint64 `json:"event_seq,omitempty"`
 }
 
-type TaskStore struct {
-	redis *RedisClient
+// TaskSpec describes a task to create.
+type TaskSpec struct {
+	AgentID  string
+	Task     string
+	Owner    string
+	BatchID  string
+	Profile  *ProfileRef
+	Callback *TaskCallback
 }
 
-func NewTaskStore(redisURL string) (*TaskStore, error) {
-	redisClient, err := NewRedisClient(redisURL)
-	if err != nil {
-		return nil, err
-	}
-	return &TaskStore{
-		redis: redisClient,
-	}, nil
+// ProfileRef names the agent profile version a task was launched from.
+type ProfileRef struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
 }
 
-func (ts *TaskStore) taskKey(agentID string) string {
-	return fmt.Sprintf("task:%s", agentID)
+// TaskCallback is the webhook notified when the task finishes. Secret signs
+// the payload and is never returned to clients; see TaskData.Redacted.
+type TaskCallback struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+	CallbackDelivery
 }
 
-func (ts *TaskStore) CreateTaskPending(agentID, task string) error {
-	taskData := TaskData{
-		AgentID: agentID,
-		Task:    task,
-		Status:  "pending",
-	}
+type CallbackDelivery struct {
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
 
-	jsonData, err := json.Marshal(taskData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal task data: %w", err)
+// Redacted returns a copy of the task that is safe to hand to clients.
+func (t TaskData) Redacted() TaskData {
+	if t.Callback != nil {
+		callback := *t.Callback
+		callback.Secret = ""
+		t.Callback = &callback
 	}
+	t.EventSeq = 0
+	return t
+}
 
-	if err := ts.redis.Set(ts.taskKey(agentID), string(jsonData), 12*time.Hour); err != nil {
-		return fmt.Errorf("failed to create pending task: %w", err)
-	}
+// TaskUpdate moves a task to Status. Result and Error are only recorded for
+// terminal states. Reopen starts another turn of a session whose previous
+// turn succeeded or failed. EventSeq, when set, is the sequence of the event
+// that caused the update; updates older than the task's last one are
+// rejected with ErrStaleUpdate, since events reach the launcher out of order.
+type TaskUpdate struct {
+	Status   string
+	Result   string
+	Error    string
+	Reopen   bool
+	EventSeq uint64
+}
 
-	return nil
+// TaskFilter narrows ListTasks. Zero values match everything; Query is a
+// case-insensitive substring match on the task text.
+type TaskFilter struct {
+	Status        string
+	Owner         string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Query         string
+	Cursor        string
+	Limit         int
 }
 
-func (ts *TaskStore) CreateTaskSuccess(agentID, result string) error {
-	exists, err := ts.TaskExists(agentID)
-	if err != nil {
-		return fmt.Errorf("failed to check task existence: %w", err)
-	}
-	if !exists {
-		return fmt.Errorf("task does not exist for agent %s", agentID)
-	}
+// TaskPage lists tasks newest first. NextCursor is empty on the last page. A
+// store that stops scanning before the page is full can return fewer tasks
+// than the limit, or none, with a NextCursor to carry on from.
+type TaskPage struct {
+	Tasks      []TaskData `json:"tasks"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
 
-	existingTask, err := ts.GetTask(agentID)
-	if err != nil {
-		return fmt.Errorf("failed to get existing task: %w", err)
-	}
+// IdempotencyRecord remembers which task a client's Idempotency-Key created
+// and a hash of the request, so that retries can be told from key reuse.
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	RequestHash string    `json:"request_hash"`
+	AgentID     string    `json:"agent_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
 
-	taskData := TaskData{
-		AgentID: existingTask.AgentID,
-		Task:    existingTask.Task,
-		Status:  "success",
-		Result:  result,
-	}
+type TaskStore interface {
+	CreateTask(spec TaskSpec) error
+	UpdateTask(agentID string, update TaskUpdate) (*TaskData, error)
+	UpdateCallbackDelivery(agentID string, delivery CallbackDelivery) error
+	// ReserveIdempotencyKey stores the record unless an unexpired record
+	// already holds its key, in which case that record is returned instead.
+	ReserveIdempotencyKey(record IdempotencyRecord) (*IdempotencyRecord, error)
+	ReleaseIdempotencyKey(key string) error
+	CreateBatch(batch *BatchData) error
+	GetBatch(batchID string) (*BatchData, error)
+	// ClaimBatchItem takes the next item of the batch that has not been
+	// launched and returns its index, or -1 when every item has been taken.
+	ClaimBatchItem(batchID string) (int, error)
+	// ActiveBatches lists the batches that still have items to claim.
+	ActiveBatches() ([]string, error)
+	GetTask(agentID string) (*TaskData, error)
+	ListTasks(filter TaskFilter) (*TaskPage, error)
+	DeleteTask(agentID string) error
+	TaskExists(agentID string) (bool, error)
+	HealthCheck() error
+	Close() error
+}
 
-	jsonData, err := json.Marshal(taskData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal task data: %w", err)
-	}
+// taskTimestamp is truncated to microseconds so that it round-trips through
+// every backend and cursor comparisons stay exact.
+func taskTimestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
 
-	if err := ts.redis.Set(ts.taskKey(agentID), string(jsonData), 12*time.Hour); err != nil {
-		return fmt.Errorf("failed to create success task: %w", err)
+func IsTerminalTaskStatus(status string) bool {
+	switch status {
+	case TaskStatusSucceeded, TaskStatusFailed, TaskStatusCancelled, TaskStatusTimedOut:
+		return true
 	}
-
-	return nil
+	return false
 }
 
-func (ts *TaskStore) CreateTaskFailed(agentID, errorMsg string) error {
-	exists, err := ts.TaskExists(agentID)
-	if err != nil {
-		return fmt.Errorf("failed to check task existence: %w", err)
+func newTask(spec TaskSpec) TaskData {
+	now := taskTimestamp()
+	taskData := TaskData{
+		AgentID:   spec.AgentID,
+		Task:      spec.Task,
+		Owner:     spec.Owner,
+		BatchID:   spec.BatchID,
+		Profile:   spec.Profile,
+		Status:    TaskStatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
-	if !exists {
-		return fmt.Errorf("task does not exist for agent %s", agentID)
+	if spec.Callback != nil {
+		taskData.Callback = &TaskCallback{
+			URL:              spec.Callback.URL,
+			Secret:           spec.Callback.Secret,
+			CallbackDelivery: CallbackDelivery{Status: CallbackStatusPending},
+		}
 	}
+	return taskData
+}
 
-	existingTask, err := ts.GetTask(agentID)
-	if err != nil {
-		return fmt.Errorf("failed to get existing task: %w", err)
+// applyTaskUpdate is the single place task transitions are decided, so every
+// backend agrees on them. Finished tasks only change again when a session
+// explicitly reopens them, which keeps late or redelivered agent events from
+// overwriting a cancellation or a result.
+func applyTaskUpdate(task *TaskData, update TaskUpdate) error {
+	if update.Reopen {
+		return reopenTask(task, update)
+	}
+	if IsTerminalTaskStatus(task.Status) {
+		return ErrTaskFinished
+	}
+	if update.EventSeq != 0 && update.EventSeq <= task.EventSeq {
+		return ErrStaleUpdate
 	}
 
-	taskData := TaskData{
-		AgentID: existingTask.AgentID,
-		Task:    existingTask.Task,
-		Status:  "failed",
-		Result:  errorMsg,
+	now := taskTimestamp()
+	task.Status = update.Status
+	task.EventSeq = max(task.EventSeq, update.EventSeq)
+	task.UpdatedAt = now
+	if update.Status != TaskStatusQueued && task.StartedAt == nil {
+		task.StartedAt = &now
 	}
+	if IsTerminalTaskStatus(update.Status) {
+		task.FinishedAt = &now
+		task.Result = update.Result
+		task.Error = update.Error
+	}
+	return nil
+}
 
-	jsonData, err := json.Marshal(taskData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal task data: %w", err)
+func reopenTask(task *TaskData, update TaskUpdate) error {
+	switch task.Status {
+	case TaskStatusSucceeded, TaskStatusFailed:
+	case TaskStatusCancelled, TaskStatusTimedOut:
+		return ErrTaskFinished
+	default:
+		return ErrTaskActive
 	}
 
-	if err := ts.redis.Set(ts.taskKey(agentID), string(jsonData), 12*time.Hour); err != nil {
-		return fmt.Errorf("failed to create failed task: %w", err)
+	now := taskTimestamp()
+	task.Status = update.Status
+	task.UpdatedAt = now
+	task.StartedAt = &now
+	task.FinishedAt = nil
+	task.Result = ""
+	task.Error = ""
+	if task.Callback != nil {
+		callback := *task.Callback
+		callback.CallbackDelivery = CallbackDelivery{Status: CallbackStatusPending}
+		task.Callback = &callback
 	}
+	return nil
+}
 
+func applyCallbackDelivery(task *TaskData, delivery CallbackDelivery) error {
+	if task.Callback == nil {
+		return fmt.Errorf("task has no callback: %w", ErrNotFound)
+	}
+	callback := *task.Callback
+	callback.CallbackDelivery = delivery
+	task.Callback = &callback
 	return nil
 }
 
-func (ts *TaskStore) GetTask(agentID string) (*TaskData, error) {
-	data, err := ts.redis.Get(ts.taskKey(agentID))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get task: %w", err)
+func (f TaskFilter) limit() int {
+	if f.Limit <= 0 {
+		return DefaultTaskLimit
 	}
+	return min(f.Limit, MaxTaskLimit)
+}
 
-	var task TaskData
-	if err := json.Unmarshal([]byte(data), &task); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal task data: %w", err)
+func (f TaskFilter) Matches(task *TaskData) bool {
+	if f.Status != "" && task.Status != f.Status {
+		return false
+	}
+	if f.Owner != "" && task.Owner != f.Owner {
+		return false
 	}
+	if !f.CreatedAfter.IsZero() && task.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !task.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(task.Task), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
 
-	return &task, nil
+// taskCursor marks the last task of a page; the next page starts strictly
+// after it in (created_at desc, agent_id desc) order.
+type taskCursor struct {
+	CreatedAt time.Time
+	AgentID   string
 }
 
-func (ts *TaskStore) DeleteTask(agentID string) error {
-	if err := ts.redis.Del(ts.taskKey(agentID)); err != nil {
-		return fmt.Errorf("failed to delete task: %w", err)
-	}
-	return nil
+func encodeTaskCursor(task *TaskData) string {
+	raw := fmt.Sprintf("%d|%s", task.CreatedAt.UnixMicro(), task.AgentID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
 }
 
-func (ts *TaskStore) TaskExists(agentID string) (bool, error) {
-	exists, err := ts.redis.Exists(ts.taskKey(agentID))
+func decodeTaskCursor(cursor string) (*taskCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
-		return false, fmt.Errorf("failed to check if task exists: %w", err)
+		return nil, ErrInvalidCursor
+	}
+	micros, agentID, ok := strings.Cut(string(raw), "|")
+	if !ok || agentID == "" {
+		return nil, ErrInvalidCursor
 	}
-	return exists > 0, nil
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &taskCursor{CreatedAt: time.UnixMicro(n).UTC(), AgentID: agentID}, nil
+}
+
+func (c *taskCursor) after(task *TaskData) bool {
+	if c == nil {
+		return true
+	}
+	if !task.CreatedAt.Equal(c.CreatedAt) {
+		return task.CreatedAt.Before(c.CreatedAt)
+	}
+	return task.AgentID < c.AgentID
 }
 
-func (ts *TaskStore) HealthCheck() error {
-	return ts.redis.Ping()
+func sortTasks(tasks []TaskData) {
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+		}
+		return tasks[i].AgentID > tasks[j].AgentID
+	})
 }
 
-func (ts *TaskStore) Close() error {
-	return ts.redis.Close()
+// newTaskPage trims tasks, already sorted and one longer than the limit when
+// more remain, to a page and its continuation cursor.
+func newTaskPage(tasks []TaskData, limit int) *TaskPage {
+	page := &TaskPage{Tasks: tasks}
+	if len(tasks) > limit {
+		page.Tasks = tasks[:limit]
+		page.NextCursor = encodeTaskCursor(&page.Tasks[limit-1])
+	}
+	if page.Tasks == nil {
+		page.Tasks = []TaskData{}
+	}
+	return page
 }
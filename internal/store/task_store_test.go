@@ -0,0 +1,548 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTaskCursor(t *testing.T) {
+	createdAt := time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	cursor := encodeTaskCursor(&TaskData{AgentID: "agent-b", CreatedAt: createdAt})
+
+	decoded, err := decodeTaskCursor(cursor)
+	if err != nil {
+		t.Fatalf("decodeTaskCursor: %v", err)
+	}
+	if !decoded.CreatedAt.Equal(createdAt) || decoded.AgentID != "agent-b" {
+		t.Fatalf("cursor decoded to %+v", decoded)
+	}
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		agentID   string
+		after     bool
+	}{
+		{"older task", createdAt.Add(-time.Microsecond), "agent-z", true},
+		{"newer task", createdAt.Add(time.Microsecond), "agent-a", false},
+		{"same time, lower ID", createdAt, "agent-a", true},
+		{"the cursor's own task", createdAt, "agent-b", false},
+		{"same time, higher ID", createdAt, "agent-c", false},
+	}
+	for _, tt := range tests {
+		task := &TaskData{AgentID: tt.agentID, CreatedAt: tt.createdAt}
+		if got := decoded.after(task); got != tt.after {
+			t.Errorf("%s: after = %v, want %v", tt.name, got, tt.after)
+		}
+	}
+}
+
+func TestDecodeTaskCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   *taskCursor
+		err    error
+	}{
+		{"empty", "", nil, nil},
+		{"valid", encode("1700000000000000|agent"), &taskCursor{CreatedAt: time.UnixMicro(1700000000000000).UTC(), AgentID: "agent"}, nil},
+		{"not base64", "not base64!", nil, ErrInvalidCursor},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("1|ab")), nil, ErrInvalidCursor},
+		{"no separator", encode("1700000000000000"), nil, ErrInvalidCursor},
+		{"no agent ID", encode("1700000000000000|"), nil, ErrInvalidCursor},
+		{"time not a number", encode("yesterday|agent"), nil, ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		got, err := decodeTaskCursor(tt.cursor)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && (!got.CreatedAt.Equal(tt.want.CreatedAt) || got.AgentID != tt.want.AgentID) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListTasksPages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		owner := testID("owner")
+
+		specs := []struct {
+			task   string
+			status string
+		}{
+			{"Write the report", TaskStatusSucceeded},
+			{"summarise the REPORT", TaskStatusRunning},
+			{"book a flight", TaskStatusRunning},
+			{"review the report draft", TaskStatusQueued},
+			{"plan the offsite", TaskStatusFailed},
+		}
+		var all []TaskData
+		for _, spec := range specs {
+			agentID := testID("agent")
+			if err := taskStore.CreateTask(TaskSpec{AgentID: agentID, Task: spec.task, Owner: owner}); err != nil {
+				t.Fatalf("CreateTask: %v", err)
+			}
+			if spec.status != TaskStatusQueued {
+				if _, err := taskStore.UpdateTask(agentID, TaskUpdate{Status: spec.status}); err != nil {
+					t.Fatalf("UpdateTask: %v", err)
+				}
+			}
+			task, err := taskStore.GetTask(agentID)
+			if err != nil {
+				t.Fatalf("GetTask: %v", err)
+			}
+			all = append(all, *task)
+		}
+		sortTasks(all)
+
+		want := func(match func(*TaskData) bool) []string {
+			var agentIDs []string
+			for _, task := range all {
+				if match(&task) {
+					agentIDs = append(agentIDs, task.AgentID)
+				}
+			}
+			return agentIDs
+		}
+		tests := []struct {
+			name   string
+			filter TaskFilter
+			want   []string
+		}{
+			{"owner", TaskFilter{Owner: owner}, want(func(*TaskData) bool { return true })},
+			{"status", TaskFilter{Owner: owner, Status: TaskStatusRunning}, want(func(task *TaskData) bool {
+				return task.Status == TaskStatusRunning
+			})},
+			{"query ignores case", TaskFilter{Owner: owner, Query: "report"}, want(func(task *TaskData) bool {
+				return task.Task != "book a flight" && task.Task != "plan the offsite"
+			})},
+			{"created before", TaskFilter{Owner: owner, CreatedBefore: all[1].CreatedAt}, want(func(task *TaskData) bool {
+				return task.CreatedAt.Before(all[1].CreatedAt)
+			})},
+			{"no match", TaskFilter{Owner: owner, Status: TaskStatusCancelled}, nil},
+		}
+		for _, tt := range tests {
+			for _, limit := range []int{1, 2, 10} {
+				filter := tt.filter
+				filter.Limit = limit
+
+				var got []string
+				for pages := 0; ; pages++ {
+					if pages > len(all) {
+						t.Fatalf("%s, limit %d: pagination did not end", tt.name, limit)
+					}
+					page, err := taskStore.ListTasks(filter)
+					if err != nil {
+						t.Fatalf("%s, limit %d: ListTasks: %v", tt.name, limit, err)
+					}
+					if len(page.Tasks) > limit {
+						t.Fatalf("%s, limit %d: page holds %d tasks", tt.name, limit, len(page.Tasks))
+					}
+					for _, task := range page.Tasks {
+						got = append(got, task.AgentID)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					filter.Cursor = page.NextCursor
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("%s, limit %d: listed %v, want %v", tt.name, limit, got, tt.want)
+				}
+			}
+		}
+
+		if _, err := taskStore.ListTasks(TaskFilter{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ListTasks with a bad cursor: got %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestRedisListTasksResumesLongScans(t *testing.T) {
+	url := os.Getenv("STORE_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("STORE_TEST_REDIS_URL is not set")
+	}
+	taskStore := openStore(t, Config{Backend: BackendRedis, RedisURL: url}, OpenTaskStore).(*RedisTaskStore)
+	taskStore.scanLimit = 10
+	owner := testID("owner")
+
+	// The owner index holds 30 tasks, of which only the oldest matches.
+	var needle string
+	for i := range 30 {
+		agentID := testID("agent")
+		task := "hay"
+		if i == 0 {
+			needle, task = agentID, "needle"
+		}
+		if err := taskStore.CreateTask(TaskSpec{AgentID: agentID, Task: task, Owner: owner}); err != nil {
+			t.Fatalf("CreateTask: %v", err)
+		}
+	}
+
+	filter := TaskFilter{Owner: owner, Query: "needle", Limit: 5}
+	var got []string
+	for pages := 1; ; pages++ {
+		if pages > 4 {
+			t.Fatal("pagination did not end")
+		}
+		page, err := taskStore.ListTasks(filter)
+		if err != nil {
+			t.Fatalf("ListTasks: %v", err)
+		}
+		if pages == 1 && (len(page.Tasks) != 0 || page.NextCursor == "") {
+			t.Fatalf("first page = %+v, want no tasks and a cursor", page)
+		}
+		for _, task := range page.Tasks {
+			got = append(got, task.AgentID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	if !slices.Equal(got, []string{needle}) {
+		t.Fatalf("listed %v, want %v", got, []string{needle})
+	}
+}
+
+func TestApplyTaskUpdate(t *testing.T) {
+	started := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+
+	tests := []struct {
+		name   string
+		task   TaskData
+		update TaskUpdate
+		err    error
+		check  func(t *testing.T, task TaskData)
+	}{
+		{
+			name:   "start",
+			task:   TaskData{Status: TaskStatusQueued},
+			update: TaskUpdate{Status: TaskStatusRunning, Result: "ignored"},
+			check: func(t *testing.T, task TaskData) {
+				if task.StartedAt == nil || task.FinishedAt != nil || task.Result != "" {
+					t.Errorf("started task has started %v, finished %v, result %q", task.StartedAt, task.FinishedAt, task.Result)
+				}
+			},
+		},
+		{
+			name:   "stay queued",
+			task:   TaskData{Status: TaskStatusQueued},
+			update: TaskUpdate{Status: TaskStatusQueued},
+			check: func(t *testing.T, task TaskData) {
+				if task.StartedAt != nil {
+					t.Errorf("queued task has started at %v", task.StartedAt)
+				}
+			},
+		},
+		{
+			name:   "keep the first start",
+			task:   TaskData{Status: TaskStatusWaitingForTool, StartedAt: &started},
+			update: TaskUpdate{Status: TaskStatusRunning},
+			check: func(t *testing.T, task TaskData) {
+				if !task.StartedAt.Equal(started) {
+					t.Errorf("started at %v, want %v", task.StartedAt, started)
+				}
+			},
+		},
+		{
+			name:   "wait for a sub-agent",
+			task:   TaskData{Status: TaskStatusRunning, StartedAt: &started, EventSeq: 5},
+			update: TaskUpdate{Status: TaskStatusWaitingForSubagent, EventSeq: 6},
+			check: func(t *testing.T, task TaskData) {
+				if !task.StartedAt.Equal(started) || task.FinishedAt != nil {
+					t.Errorf("task waiting for a sub-agent has started %v, finished %v", task.StartedAt, task.FinishedAt)
+				}
+			},
+		},
+		{
+			name:   "finish",
+			task:   TaskData{Status: TaskStatusRunning, StartedAt: &started},
+			update: TaskUpdate{Status: TaskStatusFailed, Result: "partial", Error: "boom"},
+			check: func(t *testing.T, task TaskData) {
+				if task.FinishedAt == nil || task.Result != "partial" || task.Error != "boom" {
+					t.Errorf("failed task has finished %v, result %q, error %q", task.FinishedAt, task.Result, task.Error)
+				}
+			},
+		},
+		{
+			name:   "finished task",
+			task:   TaskData{Status: TaskStatusCancelled},
+			update: TaskUpdate{Status: TaskStatusSucceeded, Result: "late"},
+			err:    ErrTaskFinished,
+		},
+		{
+			name:   "finished task checked before the sequence",
+			task:   TaskData{Status: TaskStatusSucceeded, EventSeq: 5},
+			update: TaskUpdate{Status: TaskStatusRunning, EventSeq: 3},
+			err:    ErrTaskFinished,
+		},
+		{
+			name:   "older event",
+			task:   TaskData{Status: TaskStatusWaitingForTool, EventSeq: 5},
+			update: TaskUpdate{Status: TaskStatusRunning, EventSeq: 4},
+			err:    ErrStaleUpdate,
+		},
+		{
+			name:   "redelivered event",
+			task:   TaskData{Status: TaskStatusWaitingForTool, EventSeq: 5},
+			update: TaskUpdate{Status: TaskStatusRunning, EventSeq: 5},
+			err:    ErrStaleUpdate,
+		},
+		{
+			name:   "newer event",
+			task:   TaskData{Status: TaskStatusRunning, EventSeq: 5},
+			update: TaskUpdate{Status: TaskStatusWaitingForTool, EventSeq: 9},
+			check: func(t *testing.T, task TaskData) {
+				if task.Status != TaskStatusWaitingForTool || task.EventSeq != 9 {
+					t.Errorf("task is %s at sequence %d, want %s at 9", task.Status, task.EventSeq, TaskStatusWaitingForTool)
+				}
+			},
+		},
+		{
+			name:   "update without a sequence",
+			task:   TaskData{Status: TaskStatusRunning, EventSeq: 5},
+			update: TaskUpdate{Status: TaskStatusCancelled},
+			check: func(t *testing.T, task TaskData) {
+				if task.Status != TaskStatusCancelled || task.EventSeq != 5 {
+					t.Errorf("task is %s at sequence %d, want %s at 5", task.Status, task.EventSeq, TaskStatusCancelled)
+				}
+			},
+		},
+		{
+			name: "reopen",
+			task: TaskData{
+				Status:     TaskStatusSucceeded,
+				Result:     "done",
+				StartedAt:  &started,
+				FinishedAt: &finished,
+				Callback: &TaskCallback{URL: "http://example.com", CallbackDelivery: CallbackDelivery{
+					Status:      CallbackStatusDelivered,
+					Attempts:    1,
+					DeliveredAt: &finished,
+				}},
+			},
+			update: TaskUpdate{Status: TaskStatusQueued, Reopen: true},
+			check: func(t *testing.T, task TaskData) {
+				if task.Status != TaskStatusQueued || task.Result != "" || task.FinishedAt != nil {
+					t.Errorf("reopened task is %s with result %q, finished %v", task.Status, task.Result, task.FinishedAt)
+				}
+				if task.StartedAt == nil || !task.StartedAt.After(started) {
+					t.Errorf("reopened task started at %v, want a new start", task.StartedAt)
+				}
+				if task.Callback.Status != CallbackStatusPending || task.Callback.Attempts != 0 || task.Callback.URL == "" {
+					t.Errorf("reopened task callback is %+v, want it pending again", task.Callback)
+				}
+			},
+		},
+		{
+			name:   "reopen failed task",
+			task:   TaskData{Status: TaskStatusFailed, Error: "boom"},
+			update: TaskUpdate{Status: TaskStatusQueued, Reopen: true},
+			check: func(t *testing.T, task TaskData) {
+				if task.Error != "" {
+					t.Errorf("reopened task keeps error %q", task.Error)
+				}
+			},
+		},
+		{
+			name:   "reopen cancelled task",
+			task:   TaskData{Status: TaskStatusCancelled},
+			update: TaskUpdate{Status: TaskStatusQueued, Reopen: true},
+			err:    ErrTaskFinished,
+		},
+		{
+			name:   "reopen timed out task",
+			task:   TaskData{Status: TaskStatusTimedOut},
+			update: TaskUpdate{Status: TaskStatusQueued, Reopen: true},
+			err:    ErrTaskFinished,
+		},
+		{
+			name:   "reopen task waiting for a sub-agent",
+			task:   TaskData{Status: TaskStatusWaitingForSubagent},
+			update: TaskUpdate{Status: TaskStatusQueued, Reopen: true},
+			err:    ErrTaskActive,
+		},
+		{
+			name:   "reopen active task",
+			task:   TaskData{Status: TaskStatusWaitingForInput},
+			update: TaskUpdate{Status: TaskStatusQueued, Reopen: true},
+			err:    ErrTaskActive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := tt.task
+			err := applyTaskUpdate(&task, tt.update)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if task.Status != tt.task.Status || task.EventSeq != tt.task.EventSeq {
+					t.Errorf("rejected update changed the task to %s at sequence %d", task.Status, task.EventSeq)
+				}
+				return
+			}
+			if task.Status != tt.update.Status {
+				t.Errorf("task is %s, want %s", task.Status, tt.update.Status)
+			}
+			if tt.check != nil {
+				tt.check(t, task)
+			}
+		})
+	}
+}
+
+func TestUpdateTaskTransitions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		agentID := testID("agent")
+
+		if _, err := taskStore.UpdateTask(agentID, TaskUpdate{Status: TaskStatusRunning}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateTask of a missing task: got %v, want ErrNotFound", err)
+		}
+		if err := taskStore.CreateTask(TaskSpec{AgentID: agentID, Task: "report"}); err != nil {
+			t.Fatalf("CreateTask: %v", err)
+		}
+
+		steps := []struct {
+			update TaskUpdate
+			err    error
+			status string
+		}{
+			{TaskUpdate{Status: TaskStatusRunning, EventSeq: 3}, nil, TaskStatusRunning},
+			{TaskUpdate{Status: TaskStatusWaitingForTool, EventSeq: 2}, ErrStaleUpdate, TaskStatusRunning},
+			{TaskUpdate{Status: TaskStatusWaitingForTool, EventSeq: 7}, nil, TaskStatusWaitingForTool},
+			{TaskUpdate{Status: TaskStatusWaitingForSubagent, EventSeq: 8}, nil, TaskStatusWaitingForSubagent},
+			{TaskUpdate{Status: TaskStatusSucceeded, Result: "done", EventSeq: 9}, nil, TaskStatusSucceeded},
+			{TaskUpdate{Status: TaskStatusFailed, EventSeq: 10}, ErrTaskFinished, TaskStatusSucceeded},
+			{TaskUpdate{Status: TaskStatusQueued, Reopen: true}, nil, TaskStatusQueued},
+			{TaskUpdate{Status: TaskStatusRunning, EventSeq: 8}, ErrStaleUpdate, TaskStatusQueued},
+			{TaskUpdate{Status: TaskStatusCancelled}, nil, TaskStatusCancelled},
+			{TaskUpdate{Status: TaskStatusQueued, Reopen: true}, ErrTaskFinished, TaskStatusCancelled},
+		}
+		for i, step := range steps {
+			task, err := taskStore.UpdateTask(agentID, step.update)
+			if !errors.Is(err, step.err) {
+				t.Fatalf("step %d: got error %v, want %v", i, err, step.err)
+			}
+			if task == nil || task.Status != step.status {
+				t.Fatalf("step %d: UpdateTask returned %+v, want a %s task", i, task, step.status)
+			}
+
+			stored, err := taskStore.GetTask(agentID)
+			if err != nil {
+				t.Fatalf("step %d: GetTask: %v", i, err)
+			}
+			if stored.Status != step.status {
+				t.Fatalf("step %d: stored task is %s, want %s", i, stored.Status, step.status)
+			}
+		}
+	})
+}
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		key := testID("key")
+
+		record := func(hash string, ttl time.Duration) IdempotencyRecord {
+			now := time.Now().UTC().Truncate(time.Microsecond)
+			return IdempotencyRecord{
+				Key:         key,
+				RequestHash: hash,
+				AgentID:     testID("agent"),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(ttl),
+			}
+		}
+
+		first := record("first", time.Hour)
+		if existing, err := taskStore.ReserveIdempotencyKey(first); err != nil || existing != nil {
+			t.Fatalf("first reservation: got %+v, %v; want it reserved", existing, err)
+		}
+
+		existing, err := taskStore.ReserveIdempotencyKey(record("second", time.Hour))
+		if err != nil {
+			t.Fatalf("second reservation: %v", err)
+		}
+		if existing == nil || existing.RequestHash != first.RequestHash || existing.AgentID != first.AgentID ||
+			!existing.ExpiresAt.Equal(first.ExpiresAt) {
+			t.Fatalf("second reservation returned %+v, want the first record %+v", existing, first)
+		}
+
+		if err := taskStore.ReleaseIdempotencyKey(key); err != nil {
+			t.Fatalf("ReleaseIdempotencyKey: %v", err)
+		}
+		if existing, err := taskStore.ReserveIdempotencyKey(record("released", 200*time.Millisecond)); err != nil || existing != nil {
+			t.Fatalf("reservation after release: got %+v, %v; want it reserved", existing, err)
+		}
+
+		time.Sleep(300 * time.Millisecond)
+		if existing, err := taskStore.ReserveIdempotencyKey(record("expired", time.Hour)); err != nil || existing != nil {
+			t.Fatalf("reservation after expiry: got %+v, %v; want it reserved", existing, err)
+		}
+	})
+}
+
+func TestReserveIdempotencyKeyRace(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config) {
+		taskStore := openStore(t, cfg, OpenTaskStore)
+		key := testID("key")
+
+		const clients = 8
+		var wg sync.WaitGroup
+		reserved := make(chan string, clients)
+		for range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				now := time.Now().UTC().Truncate(time.Microsecond)
+				record := IdempotencyRecord{
+					Key:         key,
+					RequestHash: "hash",
+					AgentID:     testID("agent"),
+					CreatedAt:   now,
+					ExpiresAt:   now.Add(time.Hour),
+				}
+				existing, err := taskStore.ReserveIdempotencyKey(record)
+				if err != nil {
+					t.Errorf("ReserveIdempotencyKey: %v", err)
+					return
+				}
+				if existing == nil {
+					reserved <- record.AgentID
+				} else {
+					reserved <- existing.AgentID
+				}
+			}()
+		}
+		wg.Wait()
+		close(reserved)
+
+		// The winner keeps its own task and every other client is handed it.
+		var owners []string
+		for agentID := range reserved {
+			owners = append(owners, agentID)
+		}
+		slices.Sort(owners)
+		owners = slices.Compact(owners)
+		if len(owners) != 1 {
+			t.Fatalf("clients were handed different tasks for one key: %v", owners)
+		}
+	})
+}
@@ -11,6 +11,14 @@ func CreatePrimaryAgentID() string {
 	return fmt.Sprintf("agent:%s", uuid.New().String())
 }
 
+func CreateBatchID() string {
+	return fmt.Sprintf("batch:%s", uuid.New().String())
+}
+
+func CreateScheduleID() string {
+	return fmt.Sprintf("schedule:%s", uuid.New().String())
+}
+
 func CreateSubAgentID(primaryAgentID string) string {
 	primaryUUID := strings.TrimPrefix(primaryAgentID, "agent:")
 	return fmt.Sprintf("agent:%s:%s", primaryUUID, uuid.New().String())
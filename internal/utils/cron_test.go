@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			from: time.Date(2026, 1, 1, 10, 0, 30, 0, time.UTC),
+			want: time.Date(2026, 1, 1, 10, 1, 0, 0, time.UTC),
+		},
+		{
+			name: "steps",
+			expr: "*/15 * * * *",
+			from: time.Date(2026, 1, 1, 10, 16, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "shorthand",
+			expr: "@monthly",
+			from: time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "named months and weekdays",
+			expr: "0 9 * FEB MON",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 2, 2, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 2026-01-05 is a Monday, before the 13th.
+			name: "day of month or day of week, weekday first",
+			expr: "0 0 13 * MON",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 2026-02-13 is a Friday, before the next Monday.
+			name: "day of month or day of week, day of month first",
+			expr: "0 0 13 * MON",
+			from: time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 2, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "unrestricted day of week leaves day of month alone",
+			expr: "0 0 13 * *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "unrestricted day of month leaves day of week alone",
+			expr: "0 0 * * FRI",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never matches",
+			expr: "0 0 30 2 *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Time{},
+		},
+		{
+			name: "never matches on the 31st of a short month",
+			expr: "0 0 31 4,6,9,11 *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Time{},
+		},
+		{
+			name: "local time zone",
+			expr: "0 9 * * *",
+			from: time.Date(2026, 1, 1, 12, 0, 0, 0, newYork),
+			want: time.Date(2026, 1, 2, 9, 0, 0, 0, newYork),
+		},
+		{
+			// Clocks jump from 02:00 to 03:00 on 2026-03-08, so that day
+			// has no 02:30 and the run is skipped.
+			name: "skipped by the spring DST change",
+			expr: "30 2 * * *",
+			from: time.Date(2026, 3, 7, 12, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 9, 2, 30, 0, 0, newYork),
+		},
+		{
+			name: "after the spring DST change",
+			expr: "30 3 * * *",
+			from: time.Date(2026, 3, 8, 0, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 8, 3, 30, 0, 0, newYork),
+		},
+		{
+			// Clocks go back from 02:00 to 01:00 on 2026-11-01; 01:30 comes
+			// round twice but runs once.
+			name: "repeated by the autumn DST change",
+			expr: "30 1 * * *",
+			from: time.Date(2026, 11, 1, 1, 30, 0, 0, newYork),
+			want: time.Date(2026, 11, 2, 1, 30, 0, 0, newYork),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}